@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// awsStub serves canned AWS API responses by action and records the calls.
+// The query protocol responses are wrapped in the action response and
+// result elements unless they hold the response element, like the EC2 ones,
+// the JSON protocol ones are returned as is.
+type awsStub struct {
+	// responses are the response bodies by action, the JSON protocol
+	// actions default to an empty object
+	responses map[string]string
+	// errors are the error codes returned by action
+	errors map[string]string
+
+	mu    sync.Mutex
+	calls []awsStubCall
+}
+
+// awsStubCall is an AWS API call received by the stub
+type awsStubCall struct {
+	action string
+	params url.Values
+}
+
+// newAWSStub starts the stub server and returns an API client pointed at it
+func newAWSStub(t *testing.T, responses, errors map[string]string) (*aws.APIClient, *awsStub) {
+	t.Helper()
+
+	stub := &awsStub{responses: responses, errors: errors}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	sess := session.Must(session.NewSession(&awssdk.Config{
+		Region:      awssdk.String("us-east-1"),
+		Endpoint:    awssdk.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  awssdk.Int(0),
+	}))
+	return aws.NewAPIClientFromSession(sess), stub
+}
+
+func (s *awsStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	action, json := "", false
+	if target := r.Header.Get("X-Amz-Target"); target != "" {
+		action, json = target[strings.LastIndex(target, ".")+1:], true
+	} else {
+		_ = r.ParseForm()
+		action = r.PostForm.Get("Action")
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, awsStubCall{action: action, params: r.PostForm})
+	s.mu.Unlock()
+
+	if code, ok := s.errors[action]; ok {
+		if json {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"` + code + `","message":"stubbed error"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>` + code +
+			`</Code><Message>stubbed error</Message></Error></ErrorResponse>`))
+		return
+	}
+
+	if json {
+		body, ok := s.responses[action]
+		if !ok {
+			body = "{}"
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_, _ = w.Write([]byte(body))
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	if body := s.responses[action]; strings.HasPrefix(body, `<`+action+`Response>`) {
+		_, _ = w.Write([]byte(body))
+		return
+	}
+	_, _ = w.Write([]byte(`<` + action + `Response><` + action + `Result>` + s.responses[action] +
+		`</` + action + `Result></` + action + `Response>`))
+}
+
+// called returns the parameters of the calls of the action
+func (s *awsStub) called(action string) []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	params := []url.Values{}
+	for _, call := range s.calls {
+		if call.action == action {
+			params = append(params, call.params)
+		}
+	}
+	return params
+}
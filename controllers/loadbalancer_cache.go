@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// loadBalancerCacheTTL is how long the load balancers of the Services are
+// cached between the polls of the draining nodes and of the target health,
+// about a drain cycle
+const loadBalancerCacheTTL = 5 * time.Minute
+
+// getLoadBalancer returns the load balancer of the Service, from the cache
+// while it is fresh. The cache is shared by the controllers embedding the
+// ServiceReconciler, which only read the target groups and the tags of the
+// load balancers, the Services being reconciled with a fresh discovery.
+func (r *ServiceReconciler) getLoadBalancer(svc *corev1.Service) (*aws.NetworkLoadBalancer, error) {
+	hostname := svc.Status.LoadBalancer.Ingress[0].Hostname
+	key := svc.Namespace + "/" + svc.Name + "@" + hostname
+	if nlb := r.loadBalancers.get(key); nlb != nil {
+		return nlb, nil
+	}
+	nlb, err := r.AWSClient.GetNetworkLoadBalancer(hostname, svc.Namespace+"/"+svc.Name)
+	if err != nil {
+		return nil, err
+	}
+	r.loadBalancers.set(key, nlb)
+	return nlb, nil
+}
+
+// loadBalancerCache caches the load balancers by Service and hostname for
+// loadBalancerCacheTTL
+type loadBalancerCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedLoadBalancer
+}
+
+// cachedLoadBalancer is a load balancer cached until it expires
+type cachedLoadBalancer struct {
+	nlb     *aws.NetworkLoadBalancer
+	expires time.Time
+}
+
+// get returns the cached load balancer, nil if missing or expired
+func (c *loadBalancerCache) get(key string) *aws.NetworkLoadBalancer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.nlb
+}
+
+// set caches the load balancer, dropping the expired entries
+func (c *loadBalancerCache) set(key string, nlb *aws.NetworkLoadBalancer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = map[string]cachedLoadBalancer{}
+	}
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedLoadBalancer{nlb: nlb, expires: now.Add(loadBalancerCacheTTL)}
+}
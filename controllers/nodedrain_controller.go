@@ -0,0 +1,325 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// nodeDrainPollInterval is the interval between checks of the targets of
+	// a draining node until they are deregistered
+	nodeDrainPollInterval = 10 * time.Second
+
+	// excludeFromLoadBalancersLabel excludes a node from the load balancers
+	excludeFromLoadBalancersLabel = "node.kubernetes.io/exclude-from-external-load-balancers"
+	// clusterAutoscalerTaint is set on the nodes about to be terminated by
+	// the cluster autoscaler
+	clusterAutoscalerTaint = "ToBeDeletedByClusterAutoscaler"
+	// karpenterDisruptionTaint is set on the nodes disrupted by Karpenter
+	karpenterDisruptionTaint = "karpenter.sh/disruption"
+	// nodeTerminationHandlerTaintPrefix is the prefix of the taints set by
+	// the AWS node termination handler on the nodes about to be terminated
+	nodeTerminationHandlerTaintPrefix = "aws-node-termination-handler/"
+)
+
+// NodeDrainReconciler deregisters the draining nodes from the instance target
+// groups of the managed load balancers, without waiting for the cloud
+// controller to notice. It shares the settings and scope of the
+// ServiceReconciler.
+type NodeDrainReconciler struct {
+	*ServiceReconciler
+}
+
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;patch
+
+func (r *NodeDrainReconciler) Reconcile(
+	ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+
+	rLogger := r.Log.WithName("nodedrain").WithValues("Node", req.Name)
+
+	node := &corev1.Node{}
+	if err := r.Get(ctx, req.NamespacedName, node); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	instanceID := instanceIDFromProviderID(node.Spec.ProviderID)
+	reason := nodeDrainReason(node)
+	deregistered, err := r.deregisteredTargets(node)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if reason == "" || instanceID == "" {
+		if err := r.registerNode(ctx, node, instanceID, deregistered); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, r.removeStatusAnnotation(ctx, node, annotationDeregistrationKey)
+	}
+
+	settings := r.Settings.Get()
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	progress := nodeDeregistration{}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if err := r.deregisterNode(ctx, svc, instanceID, settings, &progress, deregistered); err != nil {
+			rLogger.Error(err, "unable to deregister the node",
+				"Namespace", svc.Namespace, "Service", svc.Name,
+			)
+			progress.failed++
+		}
+	}
+
+	rLogger.Info("Deregistering the draining node from the load balancers",
+		"reason", reason, "progress", progress.String(),
+	)
+	if len(deregistered) > 0 {
+		if err := r.setStatusAnnotation(ctx, node, annotationDeregisteredTargetsKey,
+			deregistered.String()); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.setStatusAnnotation(ctx, node, annotationDeregistrationKey,
+		fmt.Sprintf("%s (%s)", progress, reason)); err != nil {
+		return ctrl.Result{}, err
+	}
+	if progress.done() {
+		return ctrl.Result{RequeueAfter: settings.ResyncInterval.Duration}, nil
+	}
+	return ctrl.Result{RequeueAfter: nodeDrainPollInterval}, nil
+}
+
+// nodeDeregistration counts the target groups of the managed load balancers
+// a node is being deregistered from
+type nodeDeregistration struct {
+	// draining target groups, waiting for the deregistration delay
+	draining int
+	// planned deregistrations, in dry run mode
+	planned int
+	// failed load balancers, checked again on the next poll
+	failed int
+}
+
+// done returns true once the node is deregistered from all the target groups
+func (d nodeDeregistration) done() bool {
+	return d.draining == 0 && d.planned == 0 && d.failed == 0
+}
+
+// String returns a human readable progress, like
+// `deregistering, 2 target groups draining`
+func (d nodeDeregistration) String() string {
+	if d.done() {
+		return "deregistered"
+	}
+	progress := []string{fmt.Sprintf("%d target groups draining", d.draining)}
+	if d.planned > 0 {
+		progress = append(progress, fmt.Sprintf("%d planned in dry run", d.planned))
+	}
+	if d.failed > 0 {
+		progress = append(progress, fmt.Sprintf("%d load balancers failed", d.failed))
+	}
+	return "deregistering, " + strings.Join(progress, ", ")
+}
+
+// deregisteredTargets lists the ports of a node deregistered by the helper,
+// by target group ARN, to register them back once the node stops draining
+type deregisteredTargets map[string][]int64
+
+// add records the ports of the targets deregistered from a target group
+func (d deregisteredTargets) add(targetGroupARN string, targets []aws.TargetHealth) {
+	for _, target := range targets {
+		if !containsPort(d[targetGroupARN], target.Port) {
+			d[targetGroupARN] = append(d[targetGroupARN], target.Port)
+		}
+	}
+}
+
+// String returns the JSON representation stored in the Node annotation
+func (d deregisteredTargets) String() string {
+	value, _ := json.Marshal(map[string][]int64(d))
+	return string(value)
+}
+
+// containsPort returns true if the port is in the list
+func containsPort(ports []int64, port int64) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// deregisteredTargets returns the targets of the node deregistered by the
+// helper, from its status annotation
+func (r *NodeDrainReconciler) deregisteredTargets(node *corev1.Node) (deregisteredTargets, error) {
+	deregistered := deregisteredTargets{}
+	value, ok := node.GetAnnotations()[r.statusAnnotationKey(annotationDeregisteredTargetsKey)]
+	if !ok {
+		return deregistered, nil
+	}
+	if err := json.Unmarshal([]byte(value), &deregistered); err != nil {
+		return nil, fmt.Errorf(
+			"unable to parse %s annotation: %w", r.statusAnnotationKey(annotationDeregisteredTargetsKey), err,
+		)
+	}
+	return deregistered, nil
+}
+
+// registerNode registers the node back in the target groups it was
+// deregistered from, once it isn't draining anymore, as the cloud controller
+// doesn't register back the nodes it didn't deregister itself. The target
+// groups deleted in the meantime are skipped.
+func (r *NodeDrainReconciler) registerNode(ctx context.Context, node *corev1.Node,
+	instanceID string, deregistered deregisteredTargets) error {
+
+	if instanceID != "" {
+		for tgARN, ports := range deregistered {
+			targets := make([]aws.TargetHealth, 0, len(ports))
+			for _, port := range ports {
+				targets = append(targets, aws.TargetHealth{ID: instanceID, Port: port})
+			}
+			if err := r.AWSClient.RegisterTargets(tgARN, targets); err != nil {
+				if aws.IsTargetGroupNotFound(err) {
+					continue
+				}
+				return err
+			}
+			r.Recorder.Eventf(node, corev1.EventTypeNormal, eventReasonNodeRegistered,
+				"Node %s registered back in %s", instanceID, tgARN,
+			)
+		}
+	}
+	return r.removeStatusAnnotation(ctx, node, annotationDeregisteredTargetsKey)
+}
+
+// deregisterNode deregisters the instance from the instance target groups of
+// the Service load balancer, if it is managed by the helper, adds the target
+// groups where it is draining to the progress, and records the deregistered
+// targets
+func (r *NodeDrainReconciler) deregisterNode(ctx context.Context, svc *corev1.Service,
+	instanceID string, settings config.Settings, progress *nodeDeregistration,
+	deregistered deregisteredTargets) error {
+
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: svc.Namespace}, ns); err != nil {
+		return err
+	}
+	if !r.inScope(svc, ns, settings) || !r.hasHelperAnnotation(svc.GetAnnotations()) ||
+		svc.GetAnnotations()[awsELBTypeAnnotationKey] != awsELBTypeNLBAnnotationValue ||
+		len(svc.Status.LoadBalancer.Ingress) < 1 {
+		return nil
+	}
+
+	nlb, err := r.getLoadBalancer(svc)
+	if err != nil {
+		return err
+	}
+	if _, conflict := nlb.ManagingInstance(r.InstanceID); conflict {
+		return nil
+	}
+	dryRun := r.isDryRun(svc, settings)
+
+	for _, tg := range nlb.TargetGroups {
+		if tg.TargetType != aws.TargetTypeInstance {
+			continue
+		}
+		targets, err := r.AWSClient.GetTargetHealth(tg.ARN)
+		if err != nil {
+			return err
+		}
+
+		registered := []aws.TargetHealth{}
+		draining := false
+		for _, target := range targets {
+			switch {
+			case target.ID != instanceID:
+			case target.State == aws.TargetHealthDraining:
+				draining = true
+			default:
+				registered = append(registered, target)
+			}
+		}
+		if len(registered) == 0 {
+			if draining {
+				progress.draining++
+			}
+			continue
+		}
+
+		if dryRun {
+			r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonPlannedChanges,
+				"Dry run, planned deregistration of the draining node %s from %s", instanceID, tg.ARN,
+			)
+			progress.planned++
+			continue
+		}
+		if err := r.AWSClient.DeregisterTargets(tg.ARN, registered); err != nil {
+			return err
+		}
+		deregistered.add(tg.ARN, registered)
+		r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonNodeDeregistered,
+			"Draining node %s deregistered from %s", instanceID, tg.ARN,
+		)
+		progress.draining++
+	}
+	return nil
+}
+
+// nodeDrainReason returns why the node must be removed from the load
+// balancers, empty if it must not
+func nodeDrainReason(node *corev1.Node) string {
+	if _, ok := node.GetLabels()[excludeFromLoadBalancersLabel]; ok {
+		return "excluded from the load balancers"
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == clusterAutoscalerTaint || taint.Key == karpenterDisruptionTaint ||
+			strings.HasPrefix(taint.Key, nodeTerminationHandlerTaintPrefix) {
+			return fmt.Sprintf("tainted %s", taint.Key)
+		}
+	}
+	if node.Spec.Unschedulable {
+		return "cordoned"
+	}
+	return ""
+}
+
+// SetupWithManager sets up the controller with the Manager, watching the
+// draining Nodes and the Nodes whose deregistration progress is reported.
+func (r *NodeDrainReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("nodedrain").
+		For(&corev1.Node{}, builder.WithPredicates(predicate.NewPredicateFuncs(r.isDrainingNode))).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.Concurrency}).
+		Complete(r)
+}
+
+// isDrainingNode returns true if the node is draining, or was draining
+func (r *NodeDrainReconciler) isDrainingNode(obj client.Object) bool {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return false
+	}
+	_, reported := node.GetAnnotations()[r.statusAnnotationKey(annotationDeregistrationKey)]
+	_, deregistered := node.GetAnnotations()[r.statusAnnotationKey(annotationDeregisteredTargetsKey)]
+	return reported || deregistered || nodeDrainReason(node) != ""
+}
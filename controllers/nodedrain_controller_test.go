@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_nodeDrainReason(t *testing.T) {
+	tests := []struct {
+		name string
+		node *corev1.Node
+		want string
+	}{
+		{name: "schedulable", node: &corev1.Node{}},
+		{
+			name: "cordoned",
+			node: &corev1.Node{Spec: corev1.NodeSpec{Unschedulable: true}},
+			want: "cordoned",
+		},
+		{
+			name: "terminated by the cluster autoscaler",
+			node: &corev1.Node{Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+				{Key: "ToBeDeletedByClusterAutoscaler", Effect: corev1.TaintEffectNoSchedule},
+			}}},
+			want: "tainted ToBeDeletedByClusterAutoscaler",
+		},
+		{
+			name: "spot interruption",
+			node: &corev1.Node{Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+				{Key: "aws-node-termination-handler/spot-itn", Effect: corev1.TaintEffectNoSchedule},
+			}}},
+			want: "tainted aws-node-termination-handler/spot-itn",
+		},
+		{
+			name: "excluded",
+			node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				"node.kubernetes.io/exclude-from-external-load-balancers": "",
+			}}},
+			want: "excluded from the load balancers",
+		},
+		{
+			name: "other taint",
+			node: &corev1.Node{Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+				{Key: "dedicated", Value: "ingress", Effect: corev1.TaintEffectNoSchedule},
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeDrainReason(tt.node); got != tt.want {
+				t.Errorf("nodeDrainReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deregisteredTargets(t *testing.T) {
+	const tgARN = "arn:aws:elasticloadbalancing:us-east-1:000000000000:targetgroup/tg/1"
+	r := &NodeDrainReconciler{ServiceReconciler: &ServiceReconciler{AnnotationPrefix: config.DefaultAnnotationPrefix}}
+	key := r.statusAnnotationKey(annotationDeregisteredTargetsKey)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		targets     []aws.TargetHealth
+		want        deregisteredTargets
+		wantErr     bool
+	}{
+		{
+			name:    "first deregistration",
+			targets: []aws.TargetHealth{{ID: "i-1", Port: 30080}},
+			want:    deregisteredTargets{tgARN: {30080}},
+		},
+		{
+			name:        "recorded ports kept",
+			annotations: map[string]string{key: `{"` + tgARN + `":[30080]}`},
+			targets:     []aws.TargetHealth{{ID: "i-1", Port: 30080}, {ID: "i-1", Port: 30443}},
+			want:        deregisteredTargets{tgARN: {30080, 30443}},
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{key: "30080"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			got, err := r.deregisteredTargets(node)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deregisteredTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got.add(tgARN, tt.targets)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("deregisteredTargets() = %v, want %v", got, tt.want)
+			}
+
+			// the annotation value round trips
+			node.Annotations = map[string]string{key: got.String()}
+			parsed, err := r.deregisteredTargets(node)
+			if err != nil || !reflect.DeepEqual(parsed, tt.want) {
+				t.Errorf("deregisteredTargets() = %v, %v, want %v", parsed, err, tt.want)
+			}
+		})
+	}
+}
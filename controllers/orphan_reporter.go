@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/metrics"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// OrphanReportConfigMapName is the name of the ConfigMap holding the last
+	// orphaned load balancers report
+	OrphanReportConfigMapName = "aws-nlb-helper-orphans"
+	orphanReportConfigMapKey  = "report.json"
+
+	eventReasonOrphanedLoadBalancer       = "OrphanedLoadBalancer"
+	eventReasonDeletionProtectionDisabled = "DeletionProtectionDisabled"
+)
+
+// OrphanedLoadBalancer is a load balancer whose Service no longer exists
+type OrphanedLoadBalancer struct {
+	LoadBalancerARN           string `json:"loadBalancerARN"`
+	Service                   string `json:"service"`
+	DeletionProtection        bool   `json:"deletionProtection"`
+	DeletionProtectionRemoved bool   `json:"deletionProtectionRemoved,omitempty"`
+	// DeletionProtectionRemovalPlanned is set in dry run mode instead of
+	// disabling the deletion protection
+	DeletionProtectionRemovalPlanned bool `json:"deletionProtectionRemovalPlanned,omitempty"`
+}
+
+// OrphanReport is the result of an orphaned load balancers scan
+type OrphanReport struct {
+	Timestamp     metav1.Time            `json:"timestamp"`
+	ClusterID     string                 `json:"clusterID,omitempty"`
+	LoadBalancers []OrphanedLoadBalancer `json:"loadBalancers"`
+}
+
+// OrphanReporter looks for network load balancers tagged with a
+// `kubernetes.io/service-name` whose Service no longer exists, and publishes
+// a report as a metric, a ConfigMap and Events.
+type OrphanReporter struct {
+	// Reader is used to check the Services and the report ConfigMap
+	// existence, it should not be cache backed
+	Reader    client.Reader
+	Client    client.Client
+	Recorder  record.EventRecorder
+	AWSClient *aws.APIClient
+	Log       logr.Logger
+	// ClusterID restricts the scan to the load balancers tagged with the
+	// `kubernetes.io/cluster/<ClusterID>` tag, the scans fail without it
+	ClusterID string
+	// Namespaces restricts the scan to the Services of the listed
+	// namespaces, all namespaces are scanned if empty
+	Namespaces []string
+	// ReportNamespace is the namespace of the report ConfigMap, no ConfigMap
+	// is published if empty
+	ReportNamespace string
+	// Interval between scans
+	Interval time.Duration
+	// RemoveDeletionProtection disables the deletion protection of the
+	// orphaned load balancers
+	RemoveDeletionProtection bool
+	// PlanDeletionProtectionRemoval reports the deletion protection removals
+	// as planned instead, in dry run mode
+	PlanDeletionProtectionRemoval bool
+}
+
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;create;update
+
+// Start runs a scan every Interval until the context is done, implementing
+// the manager Runnable interface.
+func (o *OrphanReporter) Start(ctx context.Context) error {
+
+	o.Log.Info("Starting orphaned load balancers scans", "interval", o.Interval)
+
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := o.Scan(ctx)
+		if err != nil {
+			o.Log.Error(err, "unable to scan for orphaned load balancers")
+		} else if err := o.Publish(ctx, report); err != nil {
+			o.Log.Error(err, "unable to publish the orphaned load balancers report")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes the scans run only on the leader instance
+func (o *OrphanReporter) NeedLeaderElection() bool {
+	return true
+}
+
+// Scan returns the list of load balancers of the cluster whose Service no
+// longer exists. If RemoveDeletionProtection is set, the deletion protection
+// of the orphaned load balancers is disabled, if PlanDeletionProtectionRemoval
+// is set it is only reported as planned. The load balancers whose
+// deletion protection can't be read or disabled are logged and skipped.
+func (o *OrphanReporter) Scan(ctx context.Context) (*OrphanReport, error) {
+
+	loadBalancers, err := o.AWSClient.ListServiceLoadBalancers(o.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &OrphanReport{
+		Timestamp:     metav1.Now(),
+		ClusterID:     o.ClusterID,
+		LoadBalancers: []OrphanedLoadBalancer{},
+	}
+
+	for _, lb := range loadBalancers {
+		service := strings.SplitN(lb.Service, "/", 2)
+		if len(service) != 2 || !o.inScope(service[0]) {
+			continue
+		}
+		namespace, name := service[0], service[1]
+
+		err := o.Reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &corev1.Service{})
+		if err == nil {
+			continue
+		}
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+
+		orphan := OrphanedLoadBalancer{LoadBalancerARN: lb.ARN, Service: lb.Service}
+		orphan.DeletionProtection, err = o.AWSClient.IsDeletionProtected(lb.ARN)
+		if err != nil {
+			o.Log.Error(err, "unable to get the orphaned load balancer deletion protection, skipping",
+				"LoadBalancerARN", lb.ARN, "Service", lb.Service,
+			)
+			continue
+		}
+		o.Log.Info("Orphaned load balancer found",
+			"LoadBalancerARN", lb.ARN, "Service", lb.Service,
+			"DeletionProtection", orphan.DeletionProtection,
+		)
+
+		if orphan.DeletionProtection && o.RemoveDeletionProtection {
+			if err := o.AWSClient.DisableDeletionProtection(lb.ARN); err != nil {
+				o.Log.Error(err, "unable to disable the orphaned load balancer deletion protection",
+					"LoadBalancerARN", lb.ARN, "Service", lb.Service,
+				)
+			} else {
+				orphan.DeletionProtectionRemoved = true
+				o.Log.Info("Orphaned load balancer deletion protection disabled",
+					"LoadBalancerARN", lb.ARN, "Service", lb.Service,
+				)
+			}
+		} else if orphan.DeletionProtection && o.PlanDeletionProtectionRemoval {
+			orphan.DeletionProtectionRemovalPlanned = true
+			o.Log.Info("Dry run, planned removal of the orphaned load balancer deletion protection",
+				"LoadBalancerARN", lb.ARN, "Service", lb.Service,
+			)
+		}
+
+		report.LoadBalancers = append(report.LoadBalancers, orphan)
+	}
+
+	return report, nil
+}
+
+// Publish exposes the report as a metric and, if ReportNamespace is set, as
+// a ConfigMap with an Event per orphaned load balancer.
+func (o *OrphanReporter) Publish(ctx context.Context, report *OrphanReport) error {
+
+	metrics.OrphanedLoadBalancers.Reset()
+	for _, orphan := range report.LoadBalancers {
+		metrics.OrphanedLoadBalancers.WithLabelValues(
+			orphan.Service, orphan.LoadBalancerARN,
+			strconv.FormatBool(orphan.DeletionProtection && !orphan.DeletionProtectionRemoved),
+		).Set(1)
+	}
+
+	if o.ReportNamespace == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{}
+	key := types.NamespacedName{Namespace: o.ReportNamespace, Name: OrphanReportConfigMapName}
+	if err := o.Reader.Get(ctx, key, cm); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Data:       map[string]string{orphanReportConfigMapKey: string(data)},
+		}
+		if err := o.Client.Create(ctx, cm); err != nil {
+			return err
+		}
+	} else {
+		cm.Data = map[string]string{orphanReportConfigMapKey: string(data)}
+		if err := o.Client.Update(ctx, cm); err != nil {
+			return err
+		}
+	}
+
+	for _, orphan := range report.LoadBalancers {
+		o.Recorder.Eventf(cm, corev1.EventTypeWarning, eventReasonOrphanedLoadBalancer,
+			"Load balancer %s belongs to the Service %s which no longer exists (deletion protection: %t)",
+			orphan.LoadBalancerARN, orphan.Service, orphan.DeletionProtection,
+		)
+		if orphan.DeletionProtectionRemoved {
+			o.Recorder.Eventf(cm, corev1.EventTypeNormal, eventReasonDeletionProtectionDisabled,
+				"Deletion protection disabled on the orphaned load balancer %s", orphan.LoadBalancerARN,
+			)
+		}
+		if orphan.DeletionProtectionRemovalPlanned {
+			o.Recorder.Eventf(cm, corev1.EventTypeNormal, eventReasonPlannedChanges,
+				"Dry run, planned deletion protection removal on the orphaned load balancer %s", orphan.LoadBalancerARN,
+			)
+		}
+	}
+
+	return nil
+}
+
+// inScope returns true if the Services of the namespace are in the scan scope
+func (o *OrphanReporter) inScope(namespace string) bool {
+	if len(o.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range o.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_OrphanReporter_Scan_withoutClusterID(t *testing.T) {
+	o := &OrphanReporter{AWSClient: &aws.APIClient{}, Log: ctrl.Log.WithName("orphans")}
+	if _, err := o.Scan(context.Background()); !errors.Is(err, aws.ErrClusterIDRequired) {
+		t.Errorf("Scan() error = %v, want %v", err, aws.ErrClusterIDRequired)
+	}
+}
+
+func Test_OrphanReporter_Scan(t *testing.T) {
+	const (
+		present = "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/present/1"
+		orphan  = "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/orphan/1"
+		other   = "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/other/1"
+	)
+	resource := func(arn, service string) string {
+		return `{"ResourceARN":"` + arn + `","Tags":[` +
+			`{"Key":"kubernetes.io/service-name","Value":"` + service + `"},` +
+			`{"Key":"kubernetes.io/cluster/cluster","Value":"owned"}]}`
+	}
+	responses := map[string]string{
+		"GetResources": `{"ResourceTagMappingList":[` + resource(present, "apps/present") + `,` +
+			resource(orphan, "apps/orphan") + `,` + resource(other, "other/orphan") + `]}`,
+		"DescribeLoadBalancerAttributes": `<Attributes><member>` +
+			`<Key>deletion_protection.enabled</Key><Value>true</Value></member></Attributes>`,
+		"ModifyLoadBalancerAttributes": `<Attributes><member>` +
+			`<Key>deletion_protection.enabled</Key><Value>false</Value></member></Attributes>`,
+	}
+
+	tests := []struct {
+		name       string
+		remove     bool
+		plan       bool
+		errors     map[string]string
+		want       []OrphanedLoadBalancer
+		wantModify bool
+	}{
+		{
+			name: "report only",
+			want: []OrphanedLoadBalancer{{LoadBalancerARN: orphan, Service: "apps/orphan", DeletionProtection: true}},
+		},
+		{
+			name:   "remove deletion protection",
+			remove: true,
+			want: []OrphanedLoadBalancer{{
+				LoadBalancerARN: orphan, Service: "apps/orphan", DeletionProtection: true, DeletionProtectionRemoved: true,
+			}},
+			wantModify: true,
+		},
+		{
+			name: "plan deletion protection removal",
+			plan: true,
+			want: []OrphanedLoadBalancer{{
+				LoadBalancerARN: orphan, Service: "apps/orphan", DeletionProtection: true, DeletionProtectionRemovalPlanned: true,
+			}},
+		},
+		{
+			name:       "deletion protection not removed",
+			remove:     true,
+			errors:     map[string]string{"ModifyLoadBalancerAttributes": "AccessDenied"},
+			want:       []OrphanedLoadBalancer{{LoadBalancerARN: orphan, Service: "apps/orphan", DeletionProtection: true}},
+			wantModify: true,
+		},
+		{
+			name:   "deletion protection not read",
+			errors: map[string]string{"DescribeLoadBalancerAttributes": "AccessDenied"},
+			want:   []OrphanedLoadBalancer{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			awsClient, stub := newAWSStub(t, responses, tt.errors)
+			k8sClient := fake.NewClientBuilder().WithObjects(&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "present"},
+			}).Build()
+			o := &OrphanReporter{
+				Reader:     k8sClient,
+				Client:     k8sClient,
+				AWSClient:  awsClient,
+				Log:        ctrl.Log.WithName("orphans"),
+				ClusterID:  "cluster",
+				Namespaces: []string{"apps"},
+
+				RemoveDeletionProtection:      tt.remove,
+				PlanDeletionProtectionRemoval: tt.plan,
+			}
+
+			report, err := o.Scan(context.Background())
+			if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			if !reflect.DeepEqual(report.LoadBalancers, tt.want) {
+				t.Errorf("Scan() load balancers = %+v, want %+v", report.LoadBalancers, tt.want)
+			}
+			if got := len(stub.called("ModifyLoadBalancerAttributes")) > 0; got != tt.wantModify {
+				t.Errorf("Scan() modified the load balancer attributes = %t, want %t", got, tt.wantModify)
+			}
+			for _, params := range stub.called("DescribeLoadBalancerAttributes") {
+				if arn := params.Get("LoadBalancerArn"); arn != orphan {
+					t.Errorf("Scan() described the attributes of %s, want only %s", arn, orphan)
+				}
+			}
+		})
+	}
+}
@@ -1,18 +1,208 @@
 package controllers
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The helper annotation keys are relative to the configured annotation
+// prefix, `aws-nlb-helper.3scale.net` by default, and the annotations written
+// by the helper are prefixed with `status.<annotation prefix>`.
 const (
-	annotationPrefix                                   = "aws-nlb-helper.3scale.net"
-	annotationLoadBalancerTerminationProtectionKey     = "aws-nlb-helper.3scale.net/loadbalanacer-termination-protection"
+	annotationLoadBalancerTerminationProtectionKey     = "/load-balancer-termination-protection"
 	annotationLoadBalancerTerminationProtectionDefault = false
-	annotationTargetGroupsProxyProcotolKey             = "aws-nlb-helper.3scale.net/enable-targetgroups-proxy-protocol"
+	annotationTargetGroupsProxyProcotolKey             = "/enable-targetgroups-proxy-protocol"
 	annotationTargetGroupsProxyProcotolDefault         = false
-	annotationTargetGroupsSticknessKey                 = "aws-nlb-helper.3scale.net/enable-targetgroups-stickness"
-	annotationTargetGroupsSticknessDefault             = false
-	annotationTargetGroupsDeregistrationDelayKey       = "/targetgroups-deregisration-delay"
+	annotationTargetGroupsStickinessKey                = "/enable-targetgroups-stickiness"
+	annotationTargetGroupsStickinessDefault            = false
+	annotationTargetGroupsDeregistrationDelayKey       = "/targetgroups-deregistration-delay"
 	annotationTargetGroupsDeregistrationDelayDefault   = 300
+	annotationResourceTagsKey                          = "/resource-tags"
+	annotationDryRunKey                                = "/dry-run"
+	annotationStrictKey                                = "/strict"
+	annotationGuardedRolloutKey                        = "/proxy-protocol-guarded-rollout"
+	annotationProxyProtocolRolloutKey                  = "/proxy-protocol-rollout"
+	annotationProxyProtocolBackendKey                  = "/proxy-protocol-backend"
+	annotationTLSCertificatesKey                       = "/tls-certificates"
+	annotationTLSSSLPolicyKey                          = "/tls-ssl-policy"
+	annotationTLSALPNPolicyKey                         = "/tls-alpn-policy"
+	annotationTLSPortsKey                              = "/tls-ports"
+	annotationTLSSecretsKey                            = "/tls-secrets"
+	annotationTCPIdleTimeoutKey                        = "/tcp-idle-timeout"
+	annotationSecurityGroupsKey                        = "/security-groups"
+	annotationManagedSecurityGroupKey                  = "/managed-security-group"
+	annotationSecurityGroupsPrivateLinkKey             = "/security-groups-enforce-private-link"
+	annotationIPAddressTypeKey                         = "/ip-address-type"
+	annotationStatusPrefix                             = "status."
+	annotationOriginalAttributesKey                    = "/original-attributes"
+	annotationEffectiveAttributesKey                   = "/effective-attributes"
+	annotationTargetHealthKey                          = "/target-health"
+	annotationDeregistrationKey                        = "/load-balancer-deregistration"
+	annotationDeregisteredTargetsKey                   = "/deregistered-targets"
 	awsELBTypeAnnotationKey                            = "service.beta.kubernetes.io/aws-load-balancer-type"
 	awsELBTypeNLBAnnotationValue                       = "nlb"
 	awsELBTypeClassicAnnotationValue                   = "classic"
+	awsSourceRangesAnnotationKey                       = "service.beta.kubernetes.io/load-balancer-source-ranges"
 	awsELBNotReadyRetryInterval                        = 30
-	reconcileInterval                                  = 60
 )
+
+const (
+	eventReasonPlannedChanges    = "PlannedChanges"
+	eventReasonAttributesUpdated = "AttributesUpdated"
+	eventReasonUpdateFailed      = "UpdateFailed"
+	eventReasonTagsUpdated       = "TagsUpdated"
+	eventReasonInvalidTags       = "InvalidTags"
+	eventReasonOwnershipTaken    = "OwnershipTaken"
+	eventReasonOwnershipReleased = "OwnershipReleased"
+	eventReasonRestoreFailed     = "RestoreFailed"
+	eventReasonManagedByAnother  = "ManagedByAnotherInstance"
+	eventReasonDeprecatedKey     = "DeprecatedAnnotation"
+	eventReasonConflictingKeys   = "ConflictingAnnotations"
+	eventReasonInvalidAnnotation = "InvalidAnnotations"
+	eventReasonNoHealthyTargets  = "NoHealthyTargets"
+	eventReasonRolloutStep       = "ProxyProtocolRolloutStep"
+	eventReasonRolloutDone       = "ProxyProtocolRolledOut"
+	eventReasonRolledBack        = "ProxyProtocolRolledBack"
+	eventReasonBackendMismatch   = "ProxyProtocolBackendMismatch"
+	eventReasonBackendUnknown    = "ProxyProtocolBackendUnknown"
+	eventReasonNodeDeregistered  = "NodeDeregistered"
+	eventReasonNodeRegistered    = "NodeRegistered"
+	eventReasonListenersUpdated  = "ListenersUpdated"
+	eventReasonCertificates      = "CertificatesNotResolved"
+	eventReasonSecretImported    = "CertificateImported"
+	eventReasonSecurityGroups    = "SecurityGroupsUpdated"
+	eventReasonGroupsInvalid     = "SecurityGroupsNotResolved"
+	eventReasonIPAddressType     = "IPAddressTypeUpdated"
+	eventReasonSubnetsNoIPv6     = "SubnetsWithoutIPv6"
+)
+
+const (
+	// RestoreOnAnnotationsRemoved restores the original load balancer
+	// attributes when the last helper annotation is removed from a Service
+	RestoreOnAnnotationsRemoved = "restore"
+	// ReleaseOnAnnotationsRemoved leaves the load balancer attributes as they
+	// are when the last helper annotation is removed from a Service
+	ReleaseOnAnnotationsRemoved = "release"
+)
+
+// annotationAliases are the deprecated spellings of the canonical helper
+// annotation keys, still accepted for backwards compatibility
+var annotationAliases = map[string][]string{
+	annotationLoadBalancerTerminationProtectionKey: {"/loadbalanacer-termination-protection"},
+	annotationTargetGroupsStickinessKey:            {"/enable-targetgroups-stickness"},
+	annotationTargetGroupsDeregistrationDelayKey:   {"/targetgroups-deregisration-delay"},
+}
+
+// annotationKey returns the key of a helper annotation
+func (r *ServiceReconciler) annotationKey(key string) string {
+	return r.AnnotationPrefix + key
+}
+
+// statusAnnotationKey returns the key of an annotation written by the helper
+func (r *ServiceReconciler) statusAnnotationKey(key string) string {
+	return annotationStatusPrefix + r.AnnotationPrefix + key
+}
+
+// annotation returns the value of a helper annotation of the Service
+func (r *ServiceReconciler) annotation(svc *corev1.Service, key string) string {
+	value, _ := r.lookupAnnotation(svc.GetAnnotations(), key)
+	return value
+}
+
+// lookupAnnotation returns the value of a helper annotation, using its
+// canonical key or any of its aliases, and whether it was found. The canonical
+// key takes precedence.
+func (r *ServiceReconciler) lookupAnnotation(annotations map[string]string, key string) (string, bool) {
+	for _, k := range append([]string{key}, annotationAliases[key]...) {
+		if value, ok := annotations[r.annotationKey(k)]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// deprecatedAnnotations returns the deprecated annotation keys in use, mapped
+// to their canonical key
+func (r *ServiceReconciler) deprecatedAnnotations(annotations map[string]string) map[string]string {
+	deprecated := map[string]string{}
+	for key, aliases := range annotationAliases {
+		for _, alias := range aliases {
+			if _, ok := annotations[r.annotationKey(alias)]; ok {
+				deprecated[r.annotationKey(alias)] = r.annotationKey(key)
+			}
+		}
+	}
+	return deprecated
+}
+
+// annotationConflicts returns the annotations set with different values using
+// both their canonical key and an alias, sorted
+func (r *ServiceReconciler) annotationConflicts(annotations map[string]string) []string {
+	conflicts := []string{}
+	for key, aliases := range annotationAliases {
+		value, ok := annotations[r.annotationKey(key)]
+		if !ok {
+			continue
+		}
+		for _, alias := range aliases {
+			if aliasValue, ok := annotations[r.annotationKey(alias)]; ok && aliasValue != value {
+				conflicts = append(conflicts, fmt.Sprintf("%s=%q conflicts with %s=%q",
+					r.annotationKey(key), value, r.annotationKey(alias), aliasValue,
+				))
+			}
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// checkAnnotations emits a Warning event for each deprecated annotation key
+// used by the Service or its namespace, and returns an error if any of them
+// conflicts with its canonical key. The events are only emitted when the
+// deprecated keys change, they are logged on the other reconciles.
+func (r *ServiceReconciler) checkAnnotations(svc *corev1.Service, ns *corev1.Namespace) error {
+	layers := []attributeLayer{{source: attributeSourceService, annotations: svc.GetAnnotations()}}
+	if ns != nil {
+		layers = append(layers, attributeLayer{source: attributeSourceNamespace, annotations: ns.GetAnnotations()})
+	}
+
+	conflicts := []string{}
+	warnings := []string{}
+	for _, layer := range layers {
+		deprecated := r.deprecatedAnnotations(layer.annotations)
+		aliases := make([]string, 0, len(deprecated))
+		for alias := range deprecated {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			warnings = append(warnings, fmt.Sprintf(
+				"The %s annotation %s is deprecated, use %s instead", layer.source, alias, deprecated[alias],
+			))
+		}
+		for _, conflict := range r.annotationConflicts(layer.annotations) {
+			conflicts = append(conflicts, fmt.Sprintf("%s annotation %s", layer.source, conflict))
+		}
+	}
+
+	service := types.NamespacedName{Namespace: svc.GetNamespace(), Name: svc.GetName()}
+	if r.deprecations.isApplied(service, strings.Join(warnings, "\n")) {
+		for _, warning := range warnings {
+			r.Log.Info(warning, "Namespace", svc.GetNamespace(), "Service", svc.GetName())
+		}
+	} else {
+		for _, warning := range warnings {
+			r.Recorder.Event(svc, corev1.EventTypeWarning, eventReasonDeprecatedKey, warning)
+		}
+		r.deprecations.set(service, strings.Join(warnings, "\n"))
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicting annotations: %v", conflicts)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestServiceReconciler_annotationAliases(t *testing.T) {
+	r := &ServiceReconciler{AnnotationPrefix: config.DefaultAnnotationPrefix}
+
+	tests := []struct {
+		name           string
+		annotations    map[string]string
+		wantValue      string
+		wantDeprecated map[string]string
+		wantConflicts  []string
+	}{
+		{
+			name: "canonical key",
+			annotations: map[string]string{
+				"aws-nlb-helper.3scale.net/targetgroups-deregistration-delay": "60",
+			},
+			wantValue:      "60",
+			wantDeprecated: map[string]string{},
+			wantConflicts:  []string{},
+		},
+		{
+			name: "deprecated alias",
+			annotations: map[string]string{
+				"aws-nlb-helper.3scale.net/targetgroups-deregisration-delay": "30",
+			},
+			wantValue: "30",
+			wantDeprecated: map[string]string{
+				"aws-nlb-helper.3scale.net/targetgroups-deregisration-delay": "aws-nlb-helper.3scale.net/targetgroups-deregistration-delay",
+			},
+			wantConflicts: []string{},
+		},
+		{
+			name: "conflicting alias",
+			annotations: map[string]string{
+				"aws-nlb-helper.3scale.net/targetgroups-deregistration-delay": "60",
+				"aws-nlb-helper.3scale.net/targetgroups-deregisration-delay":  "30",
+			},
+			wantValue: "60",
+			wantDeprecated: map[string]string{
+				"aws-nlb-helper.3scale.net/targetgroups-deregisration-delay": "aws-nlb-helper.3scale.net/targetgroups-deregistration-delay",
+			},
+			wantConflicts: []string{
+				`aws-nlb-helper.3scale.net/targetgroups-deregistration-delay="60" conflicts with ` +
+					`aws-nlb-helper.3scale.net/targetgroups-deregisration-delay="30"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := r.lookupAnnotation(tt.annotations, annotationTargetGroupsDeregistrationDelayKey); got != tt.wantValue {
+				t.Errorf("lookupAnnotation() = %v, want %v", got, tt.wantValue)
+			}
+			if got := r.deprecatedAnnotations(tt.annotations); !reflect.DeepEqual(got, tt.wantDeprecated) {
+				t.Errorf("deprecatedAnnotations() = %v, want %v", got, tt.wantDeprecated)
+			}
+			if got := r.annotationConflicts(tt.annotations); !reflect.DeepEqual(got, tt.wantConflicts) {
+				t.Errorf("annotationConflicts() = %v, want %v", got, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestServiceReconciler_checkAnnotations(t *testing.T) {
+	deprecated := map[string]string{"aws-nlb-helper.3scale.net/targetgroups-deregisration-delay": "30"}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "svc", Annotations: deprecated}}
+	recorder := record.NewFakeRecorder(10)
+	r := &ServiceReconciler{Log: ctrl.Log, Recorder: recorder, AnnotationPrefix: config.DefaultAnnotationPrefix}
+
+	// the events are emitted again only once the deprecated keys change
+	steps := []struct {
+		name       string
+		ns         *corev1.Namespace
+		wantEvents int
+	}{
+		{name: "first reconcile", ns: &corev1.Namespace{}, wantEvents: 1},
+		{name: "unchanged", ns: &corev1.Namespace{}, wantEvents: 0},
+		{
+			name:       "deprecated namespace key",
+			ns:         &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: deprecated}},
+			wantEvents: 2,
+		},
+		{
+			name:       "unchanged namespace",
+			ns:         &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: deprecated}},
+			wantEvents: 0,
+		},
+	}
+	for _, step := range steps {
+		if err := r.checkAnnotations(svc, step.ns); err != nil {
+			t.Fatalf("%s: checkAnnotations() error = %v", step.name, err)
+		}
+		if got := len(recorder.Events); got != step.wantEvents {
+			t.Errorf("%s: checkAnnotations() emitted %d events, want %d", step.name, got, step.wantEvents)
+		}
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The backends whose proxy protocol configuration is checked before changing
+// the proxy protocol of the target groups
+const (
+	backendIngressNginx      = "ingress-nginx"
+	backendIngressController = "ingresscontroller"
+	backendAuto              = "auto"
+	backendNone              = "none"
+
+	// ingressNginxNameLabel is set on the ingress-nginx controller Services,
+	// their ConfigMap being named after the Service
+	ingressNginxNameLabel        = "app.kubernetes.io/name"
+	ingressNginxProxyProtocolKey = "use-proxy-protocol"
+	ingressNginxInternalSuffix   = "-internal"
+
+	// ingressControllerOwnerLabel is set by the OpenShift ingress operator on
+	// the router Services
+	ingressControllerOwnerLabel = "ingresscontroller.operator.openshift.io/owning-ingresscontroller"
+	ingressControllerNamespace  = "openshift-ingress-operator"
+	ingressControllerProxy      = "PROXY"
+)
+
+// proxyProtocolBackendPollInterval is the interval between the checks of the
+// backend configuration while a proxy protocol change is held
+const proxyProtocolBackendPollInterval = 15 * time.Second
+
+// ingressControllerGVK is the OpenShift IngressController kind, read as an
+// unstructured object so the helper doesn't depend on the OpenShift API
+var ingressControllerGVK = schema.GroupVersionKind{
+	Group: "operator.openshift.io", Version: "v1", Kind: "IngressController",
+}
+
+// proxyProtocolBackend is the proxy serving the targets of a Service load
+// balancer, whose proxy protocol configuration must match the target groups
+type proxyProtocolBackend struct {
+	Kind string
+	types.NamespacedName
+}
+
+// String returns a human readable representation of the backend, like
+// `ingress-nginx ConfigMap ingress-nginx/ingress-nginx-controller`
+func (b proxyProtocolBackend) String() string {
+	if b.Kind == backendIngressController {
+		return fmt.Sprintf("IngressController %s", b.NamespacedName)
+	}
+	return fmt.Sprintf("%s ConfigMap %s", b.Kind, b.NamespacedName)
+}
+
+// parseProxyProtocolBackend parses the proxy protocol backend annotation of
+// the Service, either `ingress-nginx/<namespace>/<configmap>`,
+// `ingress-nginx/<configmap>` for a ConfigMap of the Service namespace,
+// `ingresscontroller/<name>`, `auto` to detect it or `none` to skip the check.
+func parseProxyProtocolBackend(svc *corev1.Service, value string) (*proxyProtocolBackend, error) {
+	switch value {
+	case backendAuto:
+		return detectProxyProtocolBackend(svc), nil
+	case backendNone:
+		return nil, nil
+	}
+
+	parts := strings.Split(value, "/")
+	switch {
+	case parts[0] == backendIngressNginx && len(parts) == 2 && parts[1] != "":
+		return &proxyProtocolBackend{Kind: backendIngressNginx,
+			NamespacedName: types.NamespacedName{Namespace: svc.GetNamespace(), Name: parts[1]}}, nil
+	case parts[0] == backendIngressNginx && len(parts) == 3 && parts[1] != "" && parts[2] != "":
+		return &proxyProtocolBackend{Kind: backendIngressNginx,
+			NamespacedName: types.NamespacedName{Namespace: parts[1], Name: parts[2]}}, nil
+	case parts[0] == backendIngressController && len(parts) == 2 && parts[1] != "":
+		return &proxyProtocolBackend{Kind: backendIngressController,
+			NamespacedName: types.NamespacedName{Namespace: ingressControllerNamespace, Name: parts[1]}}, nil
+	}
+	return nil, fmt.Errorf("invalid proxy protocol backend %q, expected %s/[<namespace>/]<configmap>, %s/<name>, %s or %s",
+		value, backendIngressNginx, backendIngressController, backendAuto, backendNone,
+	)
+}
+
+// detectProxyProtocolBackend returns the backend of the Service detected from
+// its labels, nil if it is neither an ingress-nginx controller nor an
+// OpenShift router Service
+func detectProxyProtocolBackend(svc *corev1.Service) *proxyProtocolBackend {
+	labels := svc.GetLabels()
+	if name, ok := labels[ingressControllerOwnerLabel]; ok {
+		return &proxyProtocolBackend{Kind: backendIngressController,
+			NamespacedName: types.NamespacedName{Namespace: ingressControllerNamespace, Name: name}}
+	}
+	if labels[ingressNginxNameLabel] == backendIngressNginx {
+		return &proxyProtocolBackend{Kind: backendIngressNginx,
+			NamespacedName: types.NamespacedName{
+				Namespace: svc.GetNamespace(),
+				Name:      strings.TrimSuffix(svc.GetName(), ingressNginxInternalSuffix),
+			}}
+	}
+	return nil
+}
+
+// getProxyProtocolBackend returns the proxy protocol backend of the Service,
+// nil if the check is disabled, either globally or with the annotation, or if
+// no backend is detected
+func (r *ServiceReconciler) getProxyProtocolBackend(
+	svc *corev1.Service, settings config.Settings) (*proxyProtocolBackend, error) {
+
+	value, ok := r.lookupAnnotation(svc.GetAnnotations(), annotationProxyProtocolBackendKey)
+	if !ok {
+		if !settings.ProxyProtocolRollout.CheckBackend {
+			return nil, nil
+		}
+		value = backendAuto
+	}
+	return parseProxyProtocolBackend(svc, value)
+}
+
+// readBackendProxyProtocol returns whether the backend expects the proxy
+// protocol. The backend objects are read without the cache, so the helper
+// doesn't watch all the ConfigMaps.
+func (r *ServiceReconciler) readBackendProxyProtocol(
+	ctx context.Context, backend *proxyProtocolBackend) (bool, error) {
+
+	if backend.Kind == backendIngressController {
+		ic := &unstructured.Unstructured{}
+		ic.SetGroupVersionKind(ingressControllerGVK)
+		if err := r.apiReader().Get(ctx, backend.NamespacedName, ic); err != nil {
+			return false, err
+		}
+		return ingressControllerProxyProtocol(ic), nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.apiReader().Get(ctx, backend.NamespacedName, cm); err != nil {
+		return false, err
+	}
+	value, ok := cm.Data[ingressNginxProxyProtocolKey]
+	if !ok {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q", ingressNginxProxyProtocolKey, value)
+	}
+	return enabled, nil
+}
+
+// ingressControllerProxyProtocol returns true if the endpoint publishing
+// strategy of the IngressController, whatever its type, uses the PROXY
+// protocol, like `spec.endpointPublishingStrategy.nodePort.protocol: PROXY`
+func ingressControllerProxyProtocol(ic *unstructured.Unstructured) bool {
+	strategy, _, _ := unstructured.NestedMap(ic.Object, "spec", "endpointPublishingStrategy")
+	for _, parameters := range strategy {
+		if p, ok := parameters.(map[string]interface{}); ok && p["protocol"] == ingressControllerProxy {
+			return true
+		}
+	}
+	return false
+}
+
+// apiReader returns the reader used for the objects not cached by the manager
+func (r *ServiceReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// checkProxyProtocolBackend compares the proxy protocol configuration of the
+// Service backend with the desired proxy protocol of the target groups. When
+// they disagree, a Warning event is emitted and the proxy protocol changes are
+// filtered out of the changes, held until the backend is switched: the backend
+// is switched first, the target groups following it within the poll interval.
+func (r *ServiceReconciler) checkProxyProtocolBackend(ctx context.Context, svc *corev1.Service,
+	desired bool, changes []aws.AttributeChange, settings config.Settings) ([]aws.AttributeChange, bool) {
+
+	rLogger := r.Log.WithValues("Namespace", svc.Namespace, "Service", svc.Name)
+
+	backend, err := r.getProxyProtocolBackend(svc, settings)
+	if err == nil && backend == nil {
+		return changes, false
+	}
+
+	kept := []aws.AttributeChange{}
+	held := false
+	for _, change := range changes {
+		if change.IsProxyProtocolChange() {
+			held = true
+			continue
+		}
+		kept = append(kept, change)
+	}
+	heldMessage := ""
+	if held {
+		heldMessage = ", the proxy protocol change is held"
+	}
+
+	if err == nil {
+		var expected bool
+		expected, err = r.readBackendProxyProtocol(ctx, backend)
+		if err == nil {
+			if expected == desired {
+				return changes, false
+			}
+			rLogger.Info("Proxy protocol backend mismatch", "backend", backend.String(), "expected", expected)
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonBackendMismatch,
+				"The %s has the proxy protocol %s while the target groups must have it %s%s",
+				backend, enabledValue(expected), enabledValue(desired), heldMessage,
+			)
+			return kept, held
+		}
+		err = fmt.Errorf("unable to read the %s: %w", backend, err)
+	}
+
+	rLogger.Error(err, "unable to check the proxy protocol backend")
+	r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonBackendUnknown,
+		"Unable to check the proxy protocol backend%s: %v", heldMessage, err,
+	)
+	return kept, held
+}
+
+// enabledValue returns a human readable boolean value
+func enabledValue(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
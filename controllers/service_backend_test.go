@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_parseProxyProtocolBackend(t *testing.T) {
+	svc := func(name string, labels map[string]string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "ingress", Name: name, Labels: labels}}
+	}
+	tests := []struct {
+		name    string
+		svc     *corev1.Service
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "configmap of the Service namespace",
+			svc:   svc("nginx", nil),
+			value: "ingress-nginx/nginx-config",
+			want:  "ingress-nginx ConfigMap ingress/nginx-config",
+		},
+		{
+			name:  "configmap of another namespace",
+			svc:   svc("nginx", nil),
+			value: "ingress-nginx/nginx/nginx-config",
+			want:  "ingress-nginx ConfigMap nginx/nginx-config",
+		},
+		{
+			name:  "ingresscontroller",
+			svc:   svc("router-default", nil),
+			value: "ingresscontroller/default",
+			want:  "IngressController openshift-ingress-operator/default",
+		},
+		{
+			name:  "detected ingress-nginx",
+			svc:   svc("ingress-nginx-controller-internal", map[string]string{"app.kubernetes.io/name": "ingress-nginx"}),
+			value: "auto",
+			want:  "ingress-nginx ConfigMap ingress/ingress-nginx-controller",
+		},
+		{
+			name: "detected ingresscontroller",
+			svc: svc("router-default", map[string]string{
+				"ingresscontroller.operator.openshift.io/owning-ingresscontroller": "default",
+			}),
+			value: "auto",
+			want:  "IngressController openshift-ingress-operator/default",
+		},
+		{name: "not detected", svc: svc("web", nil), value: "auto"},
+		{name: "none", svc: svc("nginx", nil), value: "none"},
+		{name: "invalid", svc: svc("nginx", nil), value: "ingress-nginx/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := parseProxyProtocolBackend(tt.svc, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProxyProtocolBackend() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := ""
+			if backend != nil {
+				got = backend.String()
+			}
+			if got != tt.want {
+				t.Errorf("parseProxyProtocolBackend() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ingressControllerProxyProtocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy map[string]interface{}
+		want     bool
+	}{
+		{
+			name: "node port proxy",
+			strategy: map[string]interface{}{
+				"type":     "NodePortService",
+				"nodePort": map[string]interface{}{"protocol": "PROXY"},
+			},
+			want: true,
+		},
+		{
+			name: "host network tcp",
+			strategy: map[string]interface{}{
+				"type":        "HostNetwork",
+				"hostNetwork": map[string]interface{}{"protocol": "TCP"},
+			},
+		},
+		{name: "load balancer", strategy: map[string]interface{}{"type": "LoadBalancerService"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ic := &unstructured.Unstructured{Object: map[string]interface{}{
+				"spec": map[string]interface{}{"endpointPublishingStrategy": tt.strategy},
+			}}
+			if got := ingressControllerProxyProtocol(ic); got != tt.want {
+				t.Errorf("ingressControllerProxyProtocol() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
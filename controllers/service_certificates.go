@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	util "github.com/3scale-ops/aws-nlb-helper-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// importSecretCertificates imports the TLS Secrets of the Service namespace
+// into ACM, or re-imports them when their certificate changed, and returns the
+// ARNs of the imported certificates in the order of the Secrets. In dry run
+// mode nothing is imported, and false is returned if a Secret was never
+// imported, its ARN being unknown.
+func (r *ServiceReconciler) importSecretCertificates(ctx context.Context, svc *corev1.Service,
+	secrets []string, dryRun bool) ([]string, bool, error) {
+
+	if len(secrets) == 0 {
+		return nil, true, nil
+	}
+	imported, err := r.AWSClient.GetImportedCertificates(r.InstanceID)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to list the imported ACM certificates: %w", err)
+	}
+
+	arns := []string{}
+	complete := true
+	for _, name := range secrets {
+		key := types.NamespacedName{Namespace: svc.Namespace, Name: name}
+		secret := &corev1.Secret{}
+		if err := r.apiReader().Get(ctx, key, secret); err != nil {
+			return nil, false, fmt.Errorf("unable to read the Secret %s: %w", key, err)
+		}
+		chain, privateKey := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+		if len(chain) == 0 || len(privateKey) == 0 {
+			return nil, false, fmt.Errorf("the Secret %s has no %s or %s key",
+				key, corev1.TLSCertKey, corev1.TLSPrivateKeyKey,
+			)
+		}
+
+		arn := ""
+		if current := importedCertificate(imported, key.String()); current != nil {
+			if current.Hash == aws.CertificateHash(chain) {
+				arns = append(arns, current.ARN)
+				continue
+			}
+			arn = current.ARN
+		}
+
+		if dryRun {
+			r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonPlannedChanges,
+				"Dry run, planned import of the Secret %s into ACM", key,
+			)
+			if arn == "" {
+				complete = false
+			} else {
+				arns = append(arns, arn)
+			}
+			continue
+		}
+		arn, err = r.AWSClient.ImportCertificate(r.InstanceID, key.String(), arn, chain, privateKey)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to import the Secret %s into ACM: %w", key, err)
+		}
+		r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonSecretImported,
+			"Secret %s imported into ACM as %s", key, arn,
+		)
+		arns = append(arns, arn)
+	}
+	return arns, complete, nil
+}
+
+// importedCertificate returns the certificate imported from the Secret, the
+// one with the lowest ARN if it was imported more than once by concurrent
+// reconciles, nil if it was never imported
+func importedCertificate(imported []aws.ImportedCertificate, secret string) *aws.ImportedCertificate {
+	var found *aws.ImportedCertificate
+	for i := range imported {
+		if imported[i].Secret == secret && (found == nil || imported[i].ARN < found.ARN) {
+			found = &imported[i]
+		}
+	}
+	return found
+}
+
+// secretServices maps a Secret to the candidate Services of its namespace
+// importing it, so they are reconciled when the certificate is renewed.
+func (r *ServiceReconciler) secretServices(obj client.Object) []reconcile.Request {
+
+	services := &corev1.ServiceList{}
+	if err := r.List(context.Background(), services, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list the namespace Services", "Namespace", obj.GetNamespace())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if r.isCandidate(svc) && containsString(util.SplitList(r.annotation(svc, annotationTLSSecretsKey)), obj.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(svc)})
+		}
+	}
+	return requests
+}
+
+// CertificateCleaner deletes the ACM certificates imported by the helper
+// instance whose Secret is no longer referenced by any Service. It shares the
+// settings of the ServiceReconciler.
+type CertificateCleaner struct {
+	*ServiceReconciler
+	// Interval between cleanups
+	Interval time.Duration
+}
+
+// Start runs a cleanup every Interval until the context is done, implementing
+// the manager Runnable interface.
+func (c *CertificateCleaner) Start(ctx context.Context) error {
+
+	cLogger := c.Log.WithName("certificates")
+	cLogger.Info("Starting imported certificates cleanups", "interval", c.Interval)
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Clean(ctx); err != nil {
+			cLogger.Error(err, "unable to clean the imported certificates")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes the cleanups run only on the leader instance
+func (c *CertificateCleaner) NeedLeaderElection() bool {
+	return true
+}
+
+// Clean deletes the imported certificates whose Secret is no longer
+// referenced, as well as the duplicates imported by concurrent reconciles.
+// The certificates imported less than an Interval ago are skipped, as the
+// Services referencing them may have been listed before they were imported.
+// The certificates still used by a listener can't be deleted, they are left
+// for the next cleanup.
+func (c *CertificateCleaner) Clean(ctx context.Context) error {
+
+	cLogger := c.Log.WithName("certificates")
+
+	services := &corev1.ServiceList{}
+	if err := c.apiReader().List(ctx, services); err != nil {
+		return err
+	}
+	referenced := map[string]bool{}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		for _, name := range util.SplitList(c.annotation(svc, annotationTLSSecretsKey)) {
+			referenced[types.NamespacedName{Namespace: svc.Namespace, Name: name}.String()] = true
+		}
+	}
+
+	imported, err := c.AWSClient.GetImportedCertificates(c.InstanceID)
+	if err != nil {
+		return err
+	}
+	sort.Slice(imported, func(i, j int) bool { return imported[i].ARN < imported[j].ARN })
+
+	dryRun := c.DryRun || c.Settings.Get().DryRun
+	for _, certificate := range imported {
+		if referenced[certificate.Secret] && importedCertificate(imported, certificate.Secret).ARN == certificate.ARN {
+			continue
+		}
+		if time.Since(certificate.ImportedAt) < c.Interval {
+			cLogger.V(1).Info("Skipping the recently imported certificate",
+				"CertificateARN", certificate.ARN, "Secret", certificate.Secret,
+			)
+			continue
+		}
+		if dryRun {
+			cLogger.Info("Dry run, planned deletion of the imported certificate",
+				"CertificateARN", certificate.ARN, "Secret", certificate.Secret,
+			)
+			continue
+		}
+		if err := c.AWSClient.DeleteCertificate(certificate.ARN); err != nil {
+			cLogger.Info("Unable to delete the imported certificate, it may still be in use",
+				"CertificateARN", certificate.ARN, "Secret", certificate.Secret, "error", err.Error(),
+			)
+			continue
+		}
+		cLogger.Info("Imported certificate deleted", "CertificateARN", certificate.ARN, "Secret", certificate.Secret)
+	}
+	return nil
+}
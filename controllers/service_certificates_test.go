@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+)
+
+func Test_importedCertificate(t *testing.T) {
+	imported := []aws.ImportedCertificate{
+		{ARN: "arn:aws:acm:us-east-1:000000000000:certificate/c", Secret: "ingress/tls"},
+		{ARN: "arn:aws:acm:us-east-1:000000000000:certificate/a", Secret: "ingress/other"},
+		{ARN: "arn:aws:acm:us-east-1:000000000000:certificate/b", Secret: "ingress/tls"},
+	}
+	tests := []struct {
+		name   string
+		secret string
+		want   string
+	}{
+		{name: "imported once", secret: "ingress/other", want: "arn:aws:acm:us-east-1:000000000000:certificate/a"},
+		{name: "imported twice", secret: "ingress/tls", want: "arn:aws:acm:us-east-1:000000000000:certificate/b"},
+		{name: "never imported", secret: "web/tls"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if certificate := importedCertificate(imported, tt.secret); certificate != nil {
+				got = certificate.ARN
+			}
+			if got != tt.want {
+				t.Errorf("importedCertificate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
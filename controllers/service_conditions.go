@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The Service conditions set by the helper, their types are relative to the
+// annotation prefix like the annotation keys
+const (
+	conditionAnnotationsValid = "/AnnotationsValid"
+	conditionTargetsHealthy   = "/TargetsHealthy"
+
+	conditionReasonValid            = "Valid"
+	conditionReasonInvalid          = "InvalidAnnotations"
+	conditionReasonStrictAbort      = "StrictModeAborted"
+	conditionReasonHealthyTargets   = "HealthyTargets"
+	conditionReasonNoHealthyTargets = "NoHealthyTargets"
+)
+
+// conditionType returns the type of a helper Service condition
+func (r *ServiceReconciler) conditionType(condition string) string {
+	return r.AnnotationPrefix + condition
+}
+
+// setAnnotationsCondition sets the condition reporting whether the Service
+// annotations are valid, patching the Service status only if it changed. In
+// strict mode the invalid annotations block any change to the load balancer.
+func (r *ServiceReconciler) setAnnotationsCondition(
+	ctx context.Context, svc *corev1.Service, strict bool, annotationsErr error) error {
+
+	condition := metav1.Condition{
+		Type:               r.conditionType(conditionAnnotationsValid),
+		Status:             metav1.ConditionTrue,
+		Reason:             conditionReasonValid,
+		Message:            "The helper annotations are valid",
+		ObservedGeneration: svc.GetGeneration(),
+	}
+	if annotationsErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = conditionReasonInvalid
+		condition.Message = fmt.Sprintf("The invalid values are ignored: %v", annotationsErr)
+		if strict {
+			condition.Reason = conditionReasonStrictAbort
+			condition.Message = fmt.Sprintf("No change is applied to the load balancer: %v", annotationsErr)
+		}
+	}
+
+	return r.patchConditions(ctx, svc, func(conditions *[]metav1.Condition) {
+		meta.SetStatusCondition(conditions, condition)
+	})
+}
+
+// removeConditions removes the helper conditions from the Service status
+func (r *ServiceReconciler) removeConditions(ctx context.Context, svc *corev1.Service) error {
+	return r.patchConditions(ctx, svc, func(conditions *[]metav1.Condition) {
+		meta.RemoveStatusCondition(conditions, r.conditionType(conditionAnnotationsValid))
+		meta.RemoveStatusCondition(conditions, r.conditionType(conditionTargetsHealthy))
+	})
+}
+
+// patchConditions updates the Service status conditions with the mutate
+// function, patching the Service status only if they changed. The patch
+// replaces the whole list, the optimistic lock prevents overwriting the
+// conditions set concurrently by the other helper controllers.
+func (r *ServiceReconciler) patchConditions(
+	ctx context.Context, svc *corev1.Service, mutate func(*[]metav1.Condition)) error {
+
+	original := svc.DeepCopy()
+	mutate(&svc.Status.Conditions)
+	if reflect.DeepEqual(original.Status.Conditions, svc.Status.Conditions) {
+		return nil
+	}
+	return r.Status().Patch(ctx, svc, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}))
+}
@@ -18,15 +18,22 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/metrics"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -37,12 +44,59 @@ import (
 // ServiceReconciler reconciles a Service object
 type ServiceReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme    *runtime.Scheme
+	Log       logr.Logger
+	Recorder  record.EventRecorder
+	AWSClient *aws.APIClient
+	// DryRun disables any modification of the load balancers, the planned
+	// changes are only logged and reported
+	DryRun bool
+	// Strict refuses to apply any change to the load balancer of a Service
+	// with an invalid annotation
+	Strict bool
+	// Settings holds the operator settings that can change at runtime
+	Settings *config.Store
+	// AnnotationPrefix is the prefix of the annotations handled by the helper
+	AnnotationPrefix string
+	// Concurrency is the number of Services reconciled in parallel
+	Concurrency int
+	// ServiceSelector restricts the managed Services to the ones matching
+	// the selector, it must also be applied to the manager cache
+	ServiceSelector labels.Selector
+	// InstanceID identifies this helper instance in the ownership tags of the
+	// managed load balancers
+	InstanceID string
+	// InheritNamespaceLabels lists the Service namespace labels to set as
+	// tags on the load balancer and its target groups
+	InheritNamespaceLabels []string
+	// ProtectedTagPrefixes lists the tag prefixes that must never be
+	// modified by the helper
+	ProtectedTagPrefixes []string
+	// OnAnnotationsRemoved defines what to do with the load balancer
+	// attributes once all the helper annotations are removed from a Service,
+	// either RestoreOnAnnotationsRemoved or ReleaseOnAnnotationsRemoved
+	OnAnnotationsRemoved string
+
+	// APIReader reads the objects not cached by the manager, like the proxy
+	// protocol backend configuration
+	APIReader client.Reader
+
+	// applied tracks the desired state applied to each Service load
+	// balancer, to detect drift
+	applied appliedStates
+	// deprecations tracks the deprecated annotations reported for each
+	// Service, not to emit their events on every reconcile
+	deprecations appliedStates
+	// loadBalancers caches the load balancers of the Services for the
+	// controllers polling them, not to describe all of them on every poll
+	loadBalancers loadBalancerCache
 }
 
-//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
-//+kubebuilder:rbac:groups=core,resources=services/status,verbs=get
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=core,resources=services/status,verbs=get;patch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get
+//+kubebuilder:rbac:groups=operator.openshift.io,resources=ingresscontrollers,verbs=get
 
 func (r *ServiceReconciler) Reconcile(
 	ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -50,20 +104,41 @@ func (r *ServiceReconciler) Reconcile(
 	rLogger := r.Log.WithValues("Namespace", req.Namespace, "Service", req.Name)
 	rLogger.Info("Reconciling Service")
 
+	outcome, errorClass := reconcileOutcomeSkipped, ""
+	defer func() {
+		metrics.Reconciles.WithLabelValues(outcome, errorClass).Inc()
+	}()
+
 	// Fetch the Service svc
 	svc := &corev1.Service{}
-	err := r.Get(context.TODO(), req.NamespacedName, svc)
+	err := r.Get(ctx, req.NamespacedName, svc)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
+			r.forget(req.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
+		outcome, errorClass = reconcileOutcomeError, reconcileErrorKubernetes
+		return reconcile.Result{}, err
+	}
+
+	// Fetch the Service namespace, for its labels and default annotations
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: req.Namespace}, ns); err != nil {
+		outcome, errorClass = reconcileOutcomeError, reconcileErrorKubernetes
 		return reconcile.Result{}, err
 	}
 
+	settings := r.Settings.Get()
+	if !r.inScope(svc, ns, settings) {
+		rLogger.V(1).Info("Service is out of the operator scope, ignoring")
+		r.forget(req.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+
 	// Get `kubernetes.io/service-name` tag value
 	serviceNameTagValue := req.Namespace + "/" + req.Name
 
@@ -85,6 +160,7 @@ func (r *ServiceReconciler) Reconcile(
 			"serviceNameTagValue", serviceNameTagValue,
 			"loadBalancerNotReadyRetryInterval", awsELBNotReadyRetryInterval,
 		)
+		outcome = reconcileOutcomeNotReady
 		return reconcile.Result{
 			RequeueAfter: awsELBNotReadyRetryInterval * time.Second,
 		}, nil
@@ -97,92 +173,524 @@ func (r *ServiceReconciler) Reconcile(
 
 	if awsELBType == "nlb" {
 
-		updated, err := aws.UpdateNetworkLoadBalancer(
+		nlb, err := r.AWSClient.GetNetworkLoadBalancer(
 			awsELBIngressHostname, serviceNameTagValue,
-			r.getELBAttributesFromAnnotations(svc),
 		)
 		if err != nil {
 			rLogger.Error(
-				err, "unable to update the load balancer",
+				err, "unable to find the load balancer",
+				"awsELBIngressHostname", awsELBIngressHostname,
+			)
+			outcome, errorClass = reconcileOutcomeError, reconcileErrorDiscovery
+			return ctrl.Result{}, nil
+		}
+
+		if owner, conflict := nlb.ManagingInstance(r.InstanceID); conflict {
+			rLogger.Info("load balancer managed by another helper instance, skipping",
+				"instanceID", owner,
+			)
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonManagedByAnother,
+				"Load balancer %s is managed by the helper instance %q, ignoring the %s annotations",
+				awsELBIngressHostname, owner, r.AnnotationPrefix,
+			)
+			r.forget(req.NamespacedName)
+			outcome, errorClass = reconcileOutcomeError, reconcileErrorConflict
+			return ctrl.Result{}, nil
+		}
+
+		if !r.hasHelperAnnotation(svc.GetAnnotations()) {
+			r.forget(req.NamespacedName)
+			if err := r.removeConditions(ctx, svc); err != nil {
+				rLogger.Error(err, "unable to remove the Service conditions")
+			}
+			if err := r.removeStatusAnnotation(ctx, svc, annotationProxyProtocolRolloutKey); err != nil {
+				rLogger.Error(err, "unable to remove the proxy protocol rollout state")
+			}
+			if err := r.removeStatusAnnotation(ctx, svc, annotationIPAddressTypeKey); err != nil {
+				rLogger.Error(err, "unable to remove the IP address type status")
+			}
+			result, err := r.releaseOwnership(ctx, svc, nlb, settings)
+			outcome = reconcileOutcomeReleased
+			if err != nil {
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorKubernetes
+			}
+			return result, err
+		}
+		metrics.SetManagedService(req.NamespacedName, awsELBType)
+
+		if err := r.checkAnnotations(svc, ns); err != nil {
+			rLogger.Error(err, "invalid helper annotations")
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonConflictingKeys,
+				"Unable to resolve the annotations: %v", err,
+			)
+			outcome, errorClass = reconcileOutcomeError, reconcileErrorInvalidAnnotations
+			return ctrl.Result{}, nil
+		}
+
+		dryRun := r.isDryRun(svc, settings)
+		strict := r.isStrict(svc, settings)
+		attributes, effective, invalidErr := r.getELBAttributesFromAnnotations(svc, ns, settings.Defaults)
+		if invalidErr != nil {
+			rLogger.Info("Invalid annotation values", "error", invalidErr.Error(), "strict", strict)
+			if !strict {
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonInvalidAnnotation,
+					"Ignoring the %v", invalidErr,
+				)
+			}
+		}
+
+		resourceTags, tagsErr := r.getResourceTags(svc, ns)
+		if tagsErr != nil {
+			rLogger.Error(tagsErr, "unable to get the load balancer tags")
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonInvalidTags,
+				"Unable to get the load balancer tags: %v", tagsErr,
+			)
+			errorClass = reconcileErrorInvalidAnnotations
+		}
+
+		listeners, listenersErr := r.getListenerSettings(svc)
+		if listenersErr != nil {
+			rLogger.Info("Invalid TLS listener annotations", "error", listenersErr.Error(), "strict", strict)
+			if !strict {
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonInvalidAnnotation,
+					"Ignoring the %v", listenersErr,
+				)
+			}
+		}
+
+		securityGroups, securityGroupsErr := r.getSecurityGroupSettings(svc)
+		if securityGroupsErr != nil {
+			rLogger.Info("Invalid security group annotations", "error", securityGroupsErr.Error(), "strict", strict)
+			if !strict {
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonInvalidAnnotation,
+					"Ignoring the %v", securityGroupsErr,
+				)
+			}
+		}
+
+		ipAddressType, ipAddressTypeErr := r.getIPAddressType(svc)
+		if ipAddressTypeErr != nil {
+			rLogger.Info("Invalid IP address type annotation", "error", ipAddressTypeErr.Error(), "strict", strict)
+			if !strict {
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonInvalidAnnotation,
+					"Ignoring the %v", ipAddressTypeErr,
+				)
+			}
+		}
+
+		annotationsErr := utilerrors.NewAggregate([]error{
+			invalidErr, tagsErr, listenersErr, securityGroupsErr, ipAddressTypeErr,
+		})
+		if err := r.setAnnotationsCondition(ctx, svc, strict, annotationsErr); err != nil {
+			rLogger.Error(err, "unable to update the Service conditions")
+		}
+		if strict && annotationsErr != nil {
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonInvalidAnnotation,
+				"Strict mode, no change applied to the load balancer until the annotations are fixed: %v",
+				annotationsErr,
+			)
+			outcome, errorClass = reconcileOutcomeError, reconcileErrorInvalidAnnotations
+			return ctrl.Result{}, nil
+		}
+
+		// the listener attributes are only discovered when managed, not to
+		// require their permission otherwise
+		if len(attributes.ListenerTCPIdleTimeouts) > 0 {
+			if err := r.AWSClient.GetListenerAttributes(nlb); err != nil {
+				rLogger.Error(err, "unable to get the load balancer listener attributes")
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorDiscovery
+				return ctrl.Result{}, nil
+			}
+		}
+
+		changes := nlb.PlanAttributeChanges(attributes)
+		rLogger.V(1).Info("Effective load balancer attributes", "attributes", effective.String())
+
+		var tagChanges []aws.TagChange
+		if tagsErr == nil {
+			tagChanges = nlb.PlanResourceTags(resourceTags, r.ProtectedTagPrefixes)
+		}
+
+		listenerChanges, err := r.planListenerChanges(ctx, svc, nlb, listeners, dryRun)
+		if err != nil {
+			rLogger.Error(err, "unable to plan the TLS listener changes")
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonCertificates,
+				"Unable to plan the TLS listener changes: %v", err,
+			)
+			errorClass = reconcileErrorAWS
+		}
+
+		var securityGroupChanges aws.SecurityGroupChanges
+		if securityGroupsErr == nil {
+			securityGroupChanges, err = r.planSecurityGroupChanges(svc, nlb, securityGroups)
+			if err != nil {
+				rLogger.Error(err, "unable to plan the security group changes")
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonGroupsInvalid,
+					"Unable to plan the security group changes: %v", err,
+				)
+				errorClass = reconcileErrorAWS
+			}
+		}
+		ipAddressTypeChange, err := r.planIPAddressTypeChange(nlb, ipAddressType)
+		if err != nil {
+			rLogger.Error(err, "unable to plan the IP address type change")
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonSubnetsNoIPv6,
+				"Unable to plan the IP address type change: %v", err,
+			)
+			errorClass = reconcileErrorAWS
+		}
+
+		// the security groups and IP address type are single changes
+		singleChanges := 0
+		if !securityGroupChanges.IsEmpty() {
+			singleChanges++
+		}
+		if ipAddressTypeChange != nil {
+			singleChanges++
+		}
+
+		fingerprint := desiredStateFingerprint(attributes, resourceTags, listeners, securityGroups, ipAddressType)
+		if r.applied.isApplied(req.NamespacedName, fingerprint) {
+			drifted := len(changes) + len(tagChanges) + len(listenerChanges) + singleChanges
+			metrics.DriftedAttributes.WithLabelValues(req.Namespace, req.Name).Set(float64(drifted))
+			if drifted > 0 {
+				rLogger.Info("Load balancer drifted from the desired state", "drifted", drifted)
+			}
+		}
+
+		metrics.PlannedChanges.WithLabelValues(
+			req.Namespace, req.Name, strconv.FormatBool(dryRun),
+		).Set(float64(len(changes) + len(tagChanges) + len(listenerChanges) + singleChanges))
+		metrics.PlannedChanges.DeleteLabelValues(
+			req.Namespace, req.Name, strconv.FormatBool(!dryRun),
+		)
+
+		for _, change := range changes {
+			rLogger.Info("Load balancer attribute change planned",
+				"change", change.String(), "dryRun", dryRun,
+			)
+		}
+		for _, change := range tagChanges {
+			rLogger.Info("Load balancer tag change planned",
+				"change", change.String(), "dryRun", dryRun,
+			)
+		}
+		for _, change := range listenerChanges {
+			rLogger.Info("Load balancer listener change planned",
+				"change", change.String(), "dryRun", dryRun,
+			)
+		}
+		if !securityGroupChanges.IsEmpty() {
+			rLogger.Info("Load balancer security group change planned",
+				"change", securityGroupChanges.String(), "dryRun", dryRun,
+			)
+		}
+		if ipAddressTypeChange != nil {
+			rLogger.Info("Load balancer IP address type change planned",
+				"change", ipAddressTypeChange.String(), "dryRun", dryRun,
+			)
+		}
+
+		if dryRun {
+			if len(changes) > 0 {
+				r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonPlannedChanges,
+					"Dry run, planned changes: %s, effective values: %s",
+					formatAttributeChanges(changes), effective,
+				)
+			}
+			if len(tagChanges) > 0 {
+				r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonPlannedChanges,
+					"Dry run, planned tag changes: %s", formatTagChanges(tagChanges),
+				)
+			}
+			if len(listenerChanges) > 0 {
+				r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonPlannedChanges,
+					"Dry run, planned listener changes: %s", formatListenerChanges(listenerChanges),
+				)
+			}
+			if !securityGroupChanges.IsEmpty() {
+				r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonPlannedChanges,
+					"Dry run, planned security group changes: %s", securityGroupChanges,
+				)
+			}
+			if ipAddressTypeChange != nil {
+				r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonPlannedChanges,
+					"Dry run, planned IP address type change: %s", ipAddressTypeChange,
+				)
+			}
+			outcome = reconcileOutcomeDryRun
+			return ctrl.Result{RequeueAfter: settings.ResyncInterval.Duration}, nil
+		}
+
+		if err := r.takeOwnership(ctx, svc, nlb); err != nil {
+			rLogger.Error(err, "unable to take the ownership of the load balancer")
+			outcome, errorClass = reconcileOutcomeError, reconcileErrorAWS
+			return ctrl.Result{}, err
+		}
+
+		if err := r.setStatusAnnotation(ctx, svc, annotationEffectiveAttributesKey, effective.String()); err != nil {
+			rLogger.Error(err, "unable to report the effective load balancer attributes")
+			outcome, errorClass = reconcileOutcomeError, reconcileErrorKubernetes
+			return ctrl.Result{}, err
+		}
+
+		changes, held := r.checkProxyProtocolBackend(ctx, svc, attributes.TargetGroupProxyProtocol, changes, settings)
+		rollout := rolloutResult{changes: changes, requeueAfter: proxyProtocolBackendPollInterval, pending: true}
+		if !held {
+			rollout, err = r.guardProxyProtocolRollout(ctx, svc, changes, settings)
+			if err != nil {
+				rLogger.Error(err, "unable to roll out the proxy protocol")
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorAWS
+				return ctrl.Result{}, err
+			}
+		}
+		changes = rollout.changes
+		requeueAfter := settings.ResyncInterval.Duration
+		if rollout.requeueAfter > 0 && rollout.requeueAfter < requeueAfter {
+			requeueAfter = rollout.requeueAfter
+		}
+		// the changes that couldn't be planned are retried shortly, the
+		// desired state not being applied until they are
+		if errorClass == reconcileErrorAWS && awsELBNotReadyRetryInterval*time.Second < requeueAfter {
+			requeueAfter = awsELBNotReadyRetryInterval * time.Second
+		}
+
+		// the dualstack load balancers are polled until their AAAA records
+		// are published, the IP address type being reported once changed
+		if ipAddressTypeChange == nil {
+			dnsPending, err := r.reportIPAddressType(ctx, svc, nlb, ipAddressType)
+			if err != nil {
+				rLogger.Error(err, "unable to report the load balancer IP address type")
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorKubernetes
+				return ctrl.Result{}, err
+			}
+			if dnsPending && awsELBNotReadyRetryInterval*time.Second < requeueAfter {
+				requeueAfter = awsELBNotReadyRetryInterval * time.Second
+			}
+		}
+
+		if len(changes) == 0 && len(tagChanges) == 0 && len(listenerChanges) == 0 && singleChanges == 0 {
+			rLogger.V(1).Info("Load balancer is up to date",
 				"awsELBIngressHostname", awsELBIngressHostname,
 			)
+			if !rollout.pending && errorClass == "" {
+				r.applied.set(req.NamespacedName, fingerprint)
+			}
+			if errorClass == "" {
+				outcome = reconcileOutcomeNoop
+			} else {
+				outcome = reconcileOutcomeError
+			}
+			return ctrl.Result{RequeueAfter: requeueAfter}, nil
 		}
-		if updated {
+
+		if len(changes) > 0 {
+			if err := r.AWSClient.ApplyAttributeChanges(changes); err != nil {
+				rLogger.Error(
+					err, "unable to update the load balancer",
+					"awsELBIngressHostname", awsELBIngressHostname,
+				)
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonUpdateFailed,
+					"Unable to update the load balancer: %v", err,
+				)
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorAWS
+				return ctrl.Result{}, nil
+			}
+
 			rLogger.Info("Load balancer updated",
 				"awsELBIngressHostname", awsELBIngressHostname,
 			)
+			r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonAttributesUpdated,
+				"Load balancer updated: %s, effective values: %s",
+				formatAttributeChanges(changes), effective,
+			)
+
+			if err := r.commitProxyProtocolRollout(ctx, svc, rollout); err != nil {
+				rLogger.Error(err, "unable to store the proxy protocol rollout state")
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorKubernetes
+				return ctrl.Result{}, err
+			}
+		}
+
+		if len(tagChanges) > 0 {
+			if err := r.AWSClient.ApplyTagChanges(tagChanges); err != nil {
+				rLogger.Error(
+					err, "unable to update the load balancer tags",
+					"awsELBIngressHostname", awsELBIngressHostname,
+				)
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonUpdateFailed,
+					"Unable to update the load balancer tags: %v", err,
+				)
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorAWS
+				return ctrl.Result{}, nil
+			}
+
+			rLogger.Info("Load balancer tags updated",
+				"awsELBIngressHostname", awsELBIngressHostname,
+			)
+			r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonTagsUpdated,
+				"Load balancer tags updated: %s", formatTagChanges(tagChanges),
+			)
 		}
+
+		if len(listenerChanges) > 0 {
+			if err := r.AWSClient.ApplyListenerChanges(listenerChanges); err != nil {
+				rLogger.Error(
+					err, "unable to update the load balancer listeners",
+					"awsELBIngressHostname", awsELBIngressHostname,
+				)
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonUpdateFailed,
+					"Unable to update the load balancer listeners: %v", err,
+				)
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorAWS
+				return ctrl.Result{}, nil
+			}
+
+			rLogger.Info("Load balancer listeners updated",
+				"awsELBIngressHostname", awsELBIngressHostname,
+			)
+			r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonListenersUpdated,
+				"Load balancer listeners updated: %s", formatListenerChanges(listenerChanges),
+			)
+		}
+
+		if !securityGroupChanges.IsEmpty() {
+			if err := r.AWSClient.ApplySecurityGroupChanges(
+				securityGroupChanges, r.ownership(svc), nlb.VpcID,
+			); err != nil {
+				rLogger.Error(
+					err, "unable to update the load balancer security groups",
+					"awsELBIngressHostname", awsELBIngressHostname,
+				)
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonUpdateFailed,
+					"Unable to update the load balancer security groups: %v", err,
+				)
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorAWS
+				return ctrl.Result{}, nil
+			}
+
+			rLogger.Info("Load balancer security groups updated",
+				"awsELBIngressHostname", awsELBIngressHostname,
+			)
+			r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonSecurityGroups,
+				"Load balancer security groups updated: %s", securityGroupChanges,
+			)
+		}
+
+		if ipAddressTypeChange != nil {
+			if err := r.AWSClient.ApplyIPAddressTypeChange(*ipAddressTypeChange); err != nil {
+				rLogger.Error(
+					err, "unable to update the load balancer IP address type",
+					"awsELBIngressHostname", awsELBIngressHostname,
+				)
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonUpdateFailed,
+					"Unable to update the load balancer IP address type: %v", err,
+				)
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorAWS
+				return ctrl.Result{}, nil
+			}
+
+			rLogger.Info("Load balancer IP address type updated",
+				"awsELBIngressHostname", awsELBIngressHostname,
+			)
+			r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonIPAddressType,
+				"Load balancer IP address type updated: %s", ipAddressTypeChange,
+			)
+
+			nlb.IPAddressType = ipAddressTypeChange.Desired
+			dnsPending, err := r.reportIPAddressType(ctx, svc, nlb, ipAddressType)
+			if err != nil {
+				rLogger.Error(err, "unable to report the load balancer IP address type")
+				outcome, errorClass = reconcileOutcomeError, reconcileErrorKubernetes
+				return ctrl.Result{}, err
+			}
+			if dnsPending && awsELBNotReadyRetryInterval*time.Second < requeueAfter {
+				requeueAfter = awsELBNotReadyRetryInterval * time.Second
+			}
+		}
+
+		if !rollout.pending && errorClass == "" {
+			r.applied.set(req.NamespacedName, fingerprint)
+		}
+		if errorClass == "" {
+			outcome = reconcileOutcomeApplied
+		} else {
+			outcome = reconcileOutcomeError
+		}
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
 	}
 
 	return ctrl.Result{}, nil
 }
 
-// getELBAttributesFromAnnotations generates the AWS network load balancer attributes from the
-// annotations
-func (r *ServiceReconciler) getELBAttributesFromAnnotations(
-	svc *corev1.Service) aws.NetworkLoadBalancerAttributes {
-
-	rLogger := r.Log.WithName("attribute")
+// forget removes the Service from the managed Services metrics, drift and
+// deprecated annotations tracking
+func (r *ServiceReconciler) forget(service types.NamespacedName) {
+	r.applied.delete(service)
+	r.deprecations.delete(service)
+	metrics.DeleteManagedService(service)
+}
 
-	awsELBSettingsTerminationProtection, err := strconv.ParseBool(
-		svc.GetAnnotations()[annotationLoadBalancerTerminationProtectionKey],
-	)
-	if err != nil {
-		rLogger.V(2).Info(
-			"unable to parse Termination Protection value, defaulting",
-			"awsELBSettingsTerminationProtection", annotationLoadBalancerTerminationProtectionDefault,
-		)
-		awsELBSettingsTerminationProtection = annotationLoadBalancerTerminationProtectionDefault
+// isDryRun returns true if the changes to the Service load balancer must only
+// be planned, either because the operator is running in dry run mode or
+// because the Service is annotated to do so.
+func (r *ServiceReconciler) isDryRun(svc *corev1.Service, settings config.Settings) bool {
+	if r.DryRun || settings.DryRun {
+		return true
 	}
+	dryRun, err := parseBool(r.annotation(svc, annotationDryRunKey))
+	return err == nil && dryRun
+}
 
-	awsELBSettingsDeregistrationDelay, err := strconv.Atoi(
-		svc.GetAnnotations()[annotationTargetGroupsDeregistrationDelayKey],
-	)
-	if err != nil {
-		rLogger.V(2).Info(
-			"unable to parse Deregistration Delay value, defaulting",
-			"awsELBSettingsDeregistrationDelay", annotationTargetGroupsDeregistrationDelayDefault,
-		)
-		awsELBSettingsDeregistrationDelay = annotationTargetGroupsDeregistrationDelayDefault
+// isStrict returns true if the changes to the Service load balancer must not
+// be applied when an annotation is invalid, either because the operator is
+// running in strict mode or because the Service is annotated to do so.
+func (r *ServiceReconciler) isStrict(svc *corev1.Service, settings config.Settings) bool {
+	if r.Strict || settings.Strict {
+		return true
 	}
+	strict, err := parseBool(r.annotation(svc, annotationStrictKey))
+	return err == nil && strict
+}
 
-	awsELBSettingsTargetGroupProxyProtocol, err := strconv.ParseBool(
-		svc.GetAnnotations()[annotationTargetGroupsProxyProcotolKey],
-	)
-	if err != nil {
-		rLogger.V(2).Info(
-			"unable to parse Target Group Proxy Protocol value, defaulting",
-			"awsELBSettingsTargetGroupProxyProtocol", annotationTargetGroupsProxyProcotolDefault,
-		)
-		awsELBSettingsTargetGroupProxyProtocol = annotationTargetGroupsProxyProcotolDefault
-	}
+// setStatusAnnotation sets a status annotation of the Service, or Node,
+// patching it only if the value changed.
+func (r *ServiceReconciler) setStatusAnnotation(
+	ctx context.Context, obj client.Object, key string, value string) error {
 
-	awsELBSettingsTargetGroupStickness, err := strconv.ParseBool(
-		svc.GetAnnotations()[annotationTargetGroupsSticknessKey],
-	)
-	if err != nil {
-		rLogger.V(2).Info(
-			"unable to parse Target Group Sticknesss value, defaulting",
-			"awsELBSettingsTargetGroupStickness", annotationTargetGroupsSticknessDefault,
-		)
-		awsELBSettingsTargetGroupStickness = annotationTargetGroupsSticknessDefault
+	annotations := obj.GetAnnotations()
+	if current, ok := annotations[r.statusAnnotationKey(key)]; ok && current == value {
+		return nil
 	}
-
-	return aws.NetworkLoadBalancerAttributes{
-		LoadBalancerTerminationProtection: awsELBSettingsTerminationProtection,
-		TargetGroupDeregistrationDelay:    awsELBSettingsDeregistrationDelay,
-		TargetGroupStickness:              awsELBSettingsTargetGroupStickness,
-		TargetGroupProxyProtocol:          awsELBSettingsTargetGroupProxyProtocol,
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	if annotations == nil {
+		annotations = map[string]string{}
 	}
+	annotations[r.statusAnnotationKey(key)] = value
+	obj.SetAnnotations(annotations)
+	return r.Patch(ctx, obj, patch)
+}
 
+// removeStatusAnnotation removes a status annotation of the Service, or Node,
+// if set
+func (r *ServiceReconciler) removeStatusAnnotation(ctx context.Context, obj client.Object, key string) error {
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations[r.statusAnnotationKey(key)]; !ok {
+		return nil
+	}
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	delete(annotations, r.statusAnnotationKey(key))
+	obj.SetAnnotations(annotations)
+	return r.Patch(ctx, obj, patch)
 }
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{}).
-		WithEventFilter(r.filterAnnotatedServices()).
-		Complete(r)
+// formatAttributeChanges returns a human readable list of attribute changes
+func formatAttributeChanges(changes []aws.AttributeChange) string {
+	formatted := make([]string, 0, len(changes))
+	for _, change := range changes {
+		formatted = append(formatted, change.String())
+	}
+	return fmt.Sprintf("[%s]", strings.Join(formatted, ", "))
 }
 
 // hasHelperAnnotation returns true if the annotations list contains at
@@ -192,9 +700,9 @@ func (r *ServiceReconciler) hasHelperAnnotation(annotations map[string]string) b
 }
 
 // getHelperAnnotations gets a map of strings with all the annotations matching
-// the annotationPrefix prefix using getAnnotationsByPrefix()
+// the AnnotationPrefix prefix using getAnnotationsByPrefix()
 func (r *ServiceReconciler) getHelperAnnotations(annotations map[string]string) map[string]string {
-	return r.getAnnotationsByPrefix(annotations, annotationPrefix)
+	return r.getAnnotationsByPrefix(annotations, r.AnnotationPrefix+"/")
 }
 
 // getAnnotationsByPrefix gets a map of strings with all the annotations matching
@@ -222,18 +730,14 @@ func (r *ServiceReconciler) filterAnnotatedServices() predicate.Funcs {
 		CreateFunc: func(e event.CreateEvent) bool {
 			switch o := e.Object.(type) {
 			case *corev1.Service:
-				if o.Spec.Type == "LoadBalancer" {
-					return r.hasHelperAnnotation(o.GetAnnotations())
-				}
+				return r.isCandidate(o) && r.isCachedInScope(o)
 			}
 			return false
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
 			switch o := e.ObjectNew.(type) {
 			case *corev1.Service:
-				if o.Spec.Type == "LoadBalancer" {
-					return r.hasHelperAnnotation(o.GetAnnotations())
-				}
+				return r.isCandidate(o) && r.isCachedInScope(o)
 			}
 			return false
 		},
@@ -244,3 +748,12 @@ func (r *ServiceReconciler) filterAnnotatedServices() predicate.Funcs {
 	}
 
 }
+
+// isCandidate returns true if the Service is a load balancer Service with a
+// helper annotation. Services owned by the helper are reconciled even
+// without helper annotations, so the original attributes can be restored once
+// the last annotation is removed.
+func (r *ServiceReconciler) isCandidate(svc *corev1.Service) bool {
+	return svc.Spec.Type == corev1.ServiceTypeLoadBalancer &&
+		(r.hasHelperAnnotation(svc.GetAnnotations()) || r.isOwned(svc.GetAnnotations()))
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	util "github.com/3scale-ops/aws-nlb-helper-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The sources of the effective attribute values, by precedence
+const (
+	attributeSourceService   = "service"
+	attributeSourceNamespace = "namespace"
+	attributeSourceConfig    = "config"
+	attributeSourceDefault   = "default"
+)
+
+// attributeLayer is a set of annotations defining attribute values
+type attributeLayer struct {
+	source      string
+	annotations map[string]string
+}
+
+// effectiveValue is the canonical value of an attribute, where it comes from
+// and the annotation value it was normalized from, if different
+type effectiveValue struct {
+	value  string
+	source string
+	raw    string
+}
+
+// effectiveAttributes are the effective values of the attributes, indexed by
+// annotation key
+type effectiveAttributes map[string]effectiveValue
+
+// String returns a human readable list of the effective values, like
+// `targetgroups-deregistration-delay=90 (namespace, from "90s"), ...`
+func (e effectiveAttributes) String() string {
+	values := make([]string, 0, len(e))
+	for key, v := range e {
+		source := v.source
+		if v.raw != "" && v.raw != v.value {
+			source = fmt.Sprintf("%s, from %q", v.source, v.raw)
+		}
+		values = append(values, fmt.Sprintf("%s=%s (%s)", strings.TrimPrefix(key, "/"), v.value, source))
+	}
+	sort.Strings(values)
+	return strings.Join(values, ", ")
+}
+
+// getELBAttributesFromAnnotations generates the AWS network load balancer
+// attributes from the annotations. Each attribute is taken from the Service
+// annotations, then the Namespace annotations using the same keys, then the
+// config file defaults and finally the built-in defaults. Invalid values are
+// skipped, and returned as an error along with the resolved attributes.
+func (r *ServiceReconciler) getELBAttributesFromAnnotations(svc *corev1.Service, ns *corev1.Namespace,
+	defaults config.AttributeDefaults) (aws.NetworkLoadBalancerAttributes, effectiveAttributes, error) {
+
+	layers := []attributeLayer{{source: attributeSourceService, annotations: svc.GetAnnotations()}}
+	if ns != nil {
+		layers = append(layers, attributeLayer{source: attributeSourceNamespace, annotations: ns.GetAnnotations()})
+	}
+	resolver := &attributeResolver{r: r, layers: layers, effective: effectiveAttributes{}}
+
+	attributes := aws.NetworkLoadBalancerAttributes{
+		LoadBalancerTerminationProtection: resolver.resolveBool(annotationLoadBalancerTerminationProtectionKey,
+			defaults.LoadBalancerTerminationProtection, annotationLoadBalancerTerminationProtectionDefault),
+		TargetGroupDeregistrationDelay: resolver.resolveSeconds(annotationTargetGroupsDeregistrationDelayKey,
+			defaults.TargetGroupDeregistrationDelay, annotationTargetGroupsDeregistrationDelayDefault,
+			aws.MinTargetGroupDeregistrationDelay, aws.MaxTargetGroupDeregistrationDelay),
+		TargetGroupStickness: resolver.resolveBool(annotationTargetGroupsStickinessKey,
+			defaults.TargetGroupStickiness, annotationTargetGroupsStickinessDefault),
+		TargetGroupProxyProtocol: resolver.resolveBool(annotationTargetGroupsProxyProcotolKey,
+			defaults.TargetGroupProxyProtocol, annotationTargetGroupsProxyProcotolDefault),
+		ListenerTCPIdleTimeouts: resolver.resolveListenerTCPIdleTimeouts(svc),
+	}
+
+	if len(resolver.invalid) > 0 {
+		sort.Strings(resolver.invalid)
+		return attributes, resolver.effective, fmt.Errorf("invalid annotations: %s", strings.Join(resolver.invalid, "; "))
+	}
+	return attributes, resolver.effective, nil
+}
+
+// attributeResolver resolves the effective attribute values through the
+// annotation layers, collecting the invalid values found
+type attributeResolver struct {
+	r         *ServiceReconciler
+	layers    []attributeLayer
+	effective effectiveAttributes
+	invalid   []string
+}
+
+// lookup returns the source and raw value of the first valid value of the
+// annotation in the layers, using its canonical key or an alias, and whether
+// it was found. Invalid values are recorded and ignored.
+func (ar *attributeResolver) lookup(key string, parse func(string) error) (string, string, bool) {
+
+	for _, layer := range ar.layers {
+		value, ok := ar.r.lookupAnnotation(layer.annotations, key)
+		if !ok {
+			continue
+		}
+		if err := parse(value); err != nil {
+			ar.invalid = append(ar.invalid, fmt.Sprintf("%s %s: %v",
+				layer.source, ar.r.annotationKey(key), err,
+			))
+			continue
+		}
+		return layer.source, value, true
+	}
+	return "", "", false
+}
+
+// resolveBool returns the effective value of a boolean attribute
+func (ar *attributeResolver) resolveBool(key string, configDefault *bool, builtInDefault bool) bool {
+
+	var value bool
+	source, raw, found := ar.lookup(key, func(s string) (err error) {
+		value, err = parseBool(s)
+		return err
+	})
+	if !found {
+		value, source = builtInDefault, attributeSourceDefault
+		if configDefault != nil {
+			value, source = *configDefault, attributeSourceConfig
+		}
+	}
+	ar.effective[key] = effectiveValue{value: strconv.FormatBool(value), source: source, raw: raw}
+	return value
+}
+
+// resolveSeconds returns the effective value of a time based attribute, in
+// seconds within the [min, max] range
+func (ar *attributeResolver) resolveSeconds(key string, configDefault *int, builtInDefault int, min, max int) int {
+
+	var value int
+	source, raw, found := ar.lookup(key, func(s string) (err error) {
+		value, err = parseSeconds(s, min, max)
+		return err
+	})
+	if !found {
+		value, source = builtInDefault, attributeSourceDefault
+		if configDefault != nil {
+			value, source = *configDefault, attributeSourceConfig
+		}
+	}
+	ar.effective[key] = effectiveValue{value: strconv.Itoa(value), source: source, raw: raw}
+	return value
+}
+
+// resolveListenerTCPIdleTimeouts returns the idle timeouts of the TCP
+// listeners indexed by port, nil if unset. The Service annotation is either a
+// duration applied to all the TCP Service ports or a `<port>=<duration>` list,
+// the ports given by number or name.
+func (ar *attributeResolver) resolveListenerTCPIdleTimeouts(svc *corev1.Service) map[int64]int {
+
+	raw := strings.TrimSpace(ar.r.annotation(svc, annotationTCPIdleTimeoutKey))
+	if raw == "" {
+		return nil
+	}
+	invalid := func(err error) map[int64]int {
+		ar.invalid = append(ar.invalid, fmt.Sprintf("%s %s: %v",
+			attributeSourceService, ar.r.annotationKey(annotationTCPIdleTimeoutKey), err,
+		))
+		return nil
+	}
+
+	timeouts := map[int64]int{}
+	if !strings.Contains(raw, "=") {
+		seconds, err := parseSeconds(raw, aws.MinListenerTCPIdleTimeout, aws.MaxListenerTCPIdleTimeout)
+		if err != nil {
+			return invalid(err)
+		}
+		for _, p := range svc.Spec.Ports {
+			if p.Protocol == corev1.ProtocolTCP || p.Protocol == "" {
+				timeouts[int64(p.Port)] = seconds
+			}
+		}
+		ar.effective[annotationTCPIdleTimeoutKey] = effectiveValue{
+			value: strconv.Itoa(seconds), source: attributeSourceService, raw: raw,
+		}
+		return timeouts
+	}
+
+	for _, item := range util.SplitList(raw) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return invalid(fmt.Errorf("invalid item %q, expected <port>=<duration>", item))
+		}
+		port, ok := servicePortNumber(svc, strings.TrimSpace(parts[0]))
+		if !ok {
+			return invalid(fmt.Errorf("unknown Service port %q", parts[0]))
+		}
+		seconds, err := parseSeconds(parts[1], aws.MinListenerTCPIdleTimeout, aws.MaxListenerTCPIdleTimeout)
+		if err != nil {
+			return invalid(err)
+		}
+		timeouts[port] = seconds
+	}
+
+	normalized := make([]string, 0, len(timeouts))
+	for port, seconds := range timeouts {
+		normalized = append(normalized, fmt.Sprintf("%d=%d", port, seconds))
+	}
+	sort.Strings(normalized)
+	ar.effective[annotationTCPIdleTimeoutKey] = effectiveValue{
+		value: strings.Join(normalized, ","), source: attributeSourceService, raw: raw,
+	}
+	return timeouts
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestServiceReconciler_getELBAttributesFromAnnotations(t *testing.T) {
+	delay := 30
+	protection := true
+	r := &ServiceReconciler{Log: ctrl.Log, AnnotationPrefix: config.DefaultAnnotationPrefix}
+
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		"aws-nlb-helper.3scale.net/enable-targetgroups-proxy-protocol": "on",
+		"aws-nlb-helper.3scale.net/targetgroups-deregistration-delay":  "invalid",
+	}}}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		"aws-nlb-helper.3scale.net/enable-targetgroups-proxy-protocol": "false",
+		"aws-nlb-helper.3scale.net/targetgroups-deregisration-delay":   "1m",
+	}}}
+	defaults := config.AttributeDefaults{
+		TargetGroupDeregistrationDelay:    &delay,
+		LoadBalancerTerminationProtection: &protection,
+	}
+
+	got, effective, err := r.getELBAttributesFromAnnotations(svc, ns, defaults)
+	want := aws.NetworkLoadBalancerAttributes{
+		LoadBalancerTerminationProtection: true,
+		TargetGroupDeregistrationDelay:    60,
+		TargetGroupStickness:              false,
+		TargetGroupProxyProtocol:          true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getELBAttributesFromAnnotations() = %+v, want %+v", got, want)
+	}
+
+	wantErr := "invalid annotations: service aws-nlb-helper.3scale.net/targetgroups-deregistration-delay: " +
+		`invalid duration "invalid", use a number of seconds or a duration like 90s or 5m`
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("getELBAttributesFromAnnotations() error = %v, want %v", err, wantErr)
+	}
+
+	wantEffective := `enable-targetgroups-proxy-protocol=true (service, from "on"), ` +
+		"enable-targetgroups-stickiness=false (default), " +
+		"load-balancer-termination-protection=true (config), " +
+		`targetgroups-deregistration-delay=60 (namespace, from "1m")`
+	if effective.String() != wantEffective {
+		t.Errorf("getELBAttributesFromAnnotations() effective = %v, want %v", effective, wantEffective)
+	}
+}
+
+func Test_attributeResolver_resolveListenerTCPIdleTimeouts(t *testing.T) {
+	r := &ServiceReconciler{Log: ctrl.Log, AnnotationPrefix: config.DefaultAnnotationPrefix}
+	tests := []struct {
+		name          string
+		value         string
+		want          map[int64]int
+		wantEffective string
+		wantInvalid   bool
+	}{
+		{name: "unset"},
+		{
+			name:          "all TCP ports",
+			value:         "1h",
+			want:          map[int64]int{443: 3600, 5432: 3600},
+			wantEffective: `tcp-idle-timeout=3600 (service, from "1h")`,
+		},
+		{
+			name:          "per port",
+			value:         "postgres=6000, 443=120",
+			want:          map[int64]int{443: 120, 5432: 6000},
+			wantEffective: `tcp-idle-timeout=443=120,5432=6000 (service, from "postgres=6000, 443=120")`,
+		},
+		{name: "unknown port", value: "8080=1h", wantInvalid: true},
+		{name: "out of range", value: "30s", wantInvalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					"aws-nlb-helper.3scale.net/tcp-idle-timeout": tt.value,
+				}},
+				Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+					{Name: "https", Port: 443, Protocol: corev1.ProtocolTCP},
+					{Name: "postgres", Port: 5432, Protocol: corev1.ProtocolTCP},
+					{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+				}},
+			}
+			ar := &attributeResolver{r: r, effective: effectiveAttributes{}}
+			got := ar.resolveListenerTCPIdleTimeouts(svc)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveListenerTCPIdleTimeouts() = %v, want %v", got, tt.want)
+			}
+			if (len(ar.invalid) > 0) != tt.wantInvalid {
+				t.Errorf("resolveListenerTCPIdleTimeouts() invalid = %v, wantInvalid %v", ar.invalid, tt.wantInvalid)
+			}
+			if ar.effective.String() != tt.wantEffective {
+				t.Errorf("resolveListenerTCPIdleTimeouts() effective = %v, want %v", ar.effective, tt.wantEffective)
+			}
+		})
+	}
+}
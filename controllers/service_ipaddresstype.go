@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// getIPAddressType returns the IP address type of the Service annotation,
+// empty if the Service doesn't manage it
+func (r *ServiceReconciler) getIPAddressType(svc *corev1.Service) (string, error) {
+	ipAddressType := strings.ToLower(strings.TrimSpace(r.annotation(svc, annotationIPAddressTypeKey)))
+	if ipAddressType != "" && !containsString(aws.IPAddressTypes, ipAddressType) {
+		return "", fmt.Errorf("%s: invalid IP address type %q, expected one of %s",
+			r.annotationKey(annotationIPAddressTypeKey), ipAddressType, strings.Join(aws.IPAddressTypes, ", "),
+		)
+	}
+	return ipAddressType, nil
+}
+
+// planIPAddressTypeChange returns the change needed to apply the IP address
+// type to the load balancer, checking that all its subnets have an IPv6 CIDR
+// block before switching to dualstack
+func (r *ServiceReconciler) planIPAddressTypeChange(
+	nlb *aws.NetworkLoadBalancer, ipAddressType string) (*aws.IPAddressTypeChange, error) {
+
+	change := nlb.PlanIPAddressTypeChange(ipAddressType)
+	if change == nil || change.Desired != aws.IPAddressTypeDualstack {
+		return change, nil
+	}
+	if err := r.AWSClient.ValidateDualstackSubnets(nlb.Subnets); err != nil {
+		return nil, fmt.Errorf("unable to switch the load balancer to dualstack: %w", err)
+	}
+	return change, nil
+}
+
+// reportIPAddressType reports in a Service status annotation the IP address
+// type of the load balancer, along with the DNS records published for its
+// hostname while the load balancer is dualstack, like `dualstack (A, AAAA)`.
+// The hostname is only resolved until the AAAA records are published, and
+// true is returned meanwhile.
+func (r *ServiceReconciler) reportIPAddressType(ctx context.Context, svc *corev1.Service,
+	nlb *aws.NetworkLoadBalancer, ipAddressType string) (bool, error) {
+
+	if ipAddressType == "" {
+		return false, r.removeStatusAnnotation(ctx, svc, annotationIPAddressTypeKey)
+	}
+	if nlb.IPAddressType != aws.IPAddressTypeDualstack {
+		return false, r.setStatusAnnotation(ctx, svc, annotationIPAddressTypeKey, nlb.IPAddressType)
+	}
+	current := svc.GetAnnotations()[r.statusAnnotationKey(annotationIPAddressTypeKey)]
+	if dualstackPublished(current) {
+		return false, nil
+	}
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, nlb.DNSName)
+	if err != nil {
+		r.Log.V(1).Info("Unable to resolve the load balancer hostname",
+			"hostname", nlb.DNSName, "error", err.Error(),
+		)
+	}
+	status, aaaa := ipAddressTypeStatus(nlb.IPAddressType, addresses)
+	return !aaaa, r.setStatusAnnotation(ctx, svc, annotationIPAddressTypeKey, status)
+}
+
+// dualstackPublished returns true if the IP address type status reports the
+// AAAA records of a dualstack load balancer
+func dualstackPublished(status string) bool {
+	return strings.HasPrefix(status, aws.IPAddressTypeDualstack+" (") && strings.Contains(status, "AAAA")
+}
+
+// ipAddressTypeStatus returns the IP address type status of a load balancer
+// from its type and the addresses its hostname resolves to, and whether AAAA
+// records are published
+func ipAddressTypeStatus(ipAddressType string, addresses []net.IPAddr) (string, bool) {
+	a, aaaa := false, false
+	for _, address := range addresses {
+		if address.IP.To4() != nil {
+			a = true
+		} else {
+			aaaa = true
+		}
+	}
+
+	records := []string{}
+	if a {
+		records = append(records, "A")
+	}
+	if aaaa {
+		records = append(records, "AAAA")
+	}
+	if len(records) == 0 {
+		return fmt.Sprintf("%s (no DNS records)", ipAddressType), false
+	}
+	return fmt.Sprintf("%s (%s)", ipAddressType, strings.Join(records, ", ")), aaaa
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_ipAddressTypeStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		ipAddressType string
+		addresses     []string
+		want          string
+		wantAAAA      bool
+	}{
+		{
+			name:          "ipv4",
+			ipAddressType: "ipv4",
+			addresses:     []string{"192.0.2.10", "192.0.2.11"},
+			want:          "ipv4 (A)",
+		},
+		{
+			name:          "dualstack published",
+			ipAddressType: "dualstack",
+			addresses:     []string{"192.0.2.10", "2001:db8::10"},
+			want:          "dualstack (A, AAAA)",
+			wantAAAA:      true,
+		},
+		{
+			name:          "dualstack pending",
+			ipAddressType: "dualstack",
+			addresses:     []string{"192.0.2.10"},
+			want:          "dualstack (A)",
+		},
+		{
+			name:          "not resolved",
+			ipAddressType: "dualstack",
+			want:          "dualstack (no DNS records)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addresses := []net.IPAddr{}
+			for _, address := range tt.addresses {
+				addresses = append(addresses, net.IPAddr{IP: net.ParseIP(address)})
+			}
+			got, aaaa := ipAddressTypeStatus(tt.ipAddressType, addresses)
+			if got != tt.want || aaaa != tt.wantAAAA {
+				t.Errorf("ipAddressTypeStatus() = %q, %t, want %q, %t", got, aaaa, tt.want, tt.wantAAAA)
+			}
+		})
+	}
+}
+
+func Test_dualstackPublished(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{status: "", want: false},
+		{status: "ipv4", want: false},
+		{status: "dualstack (A)", want: false},
+		{status: "dualstack (no DNS records)", want: false},
+		{status: "dualstack (A, AAAA)", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			if got := dualstackPublished(tt.status); got != tt.want {
+				t.Errorf("dualstackPublished(%q) = %t, want %t", tt.status, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	util "github.com/3scale-ops/aws-nlb-helper-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// listenerSettings are the TLS listener settings of a Service, the
+// certificates being references to resolve in ACM, followed by the
+// certificates imported from the TLS Secrets
+type listenerSettings struct {
+	certificates []string
+	secrets      []string
+	sslPolicy    string
+	alpnPolicy   string
+	// ports are the Service ports whose TLS listeners are managed, all of
+	// them if nil
+	ports map[int64]bool
+}
+
+// String returns a human readable representation of the settings, used in
+// the desired state fingerprint
+func (s *listenerSettings) String() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("certificates=%v secrets=%v ssl-policy=%s alpn-policy=%s ports=%v",
+		s.certificates, s.secrets, s.sslPolicy, s.alpnPolicy, s.ports,
+	)
+}
+
+// getListenerSettings returns the TLS listener settings of the Service, nil
+// if the Service manages none of them
+func (r *ServiceReconciler) getListenerSettings(svc *corev1.Service) (*listenerSettings, error) {
+
+	certificates := util.SplitList(r.annotation(svc, annotationTLSCertificatesKey))
+	secrets := util.SplitList(r.annotation(svc, annotationTLSSecretsKey))
+	sslPolicy := strings.TrimSpace(r.annotation(svc, annotationTLSSSLPolicyKey))
+	alpnPolicy := strings.TrimSpace(r.annotation(svc, annotationTLSALPNPolicyKey))
+	if len(certificates) == 0 && len(secrets) == 0 && sslPolicy == "" && alpnPolicy == "" {
+		return nil, nil
+	}
+
+	invalid := []string{}
+	for _, ref := range certificates {
+		if err := aws.ValidateCertificateReference(ref); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %v", r.annotationKey(annotationTLSCertificatesKey), err))
+		}
+	}
+	for _, name := range secrets {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			invalid = append(invalid, fmt.Sprintf("%s: invalid Secret name %q: %s",
+				r.annotationKey(annotationTLSSecretsKey), name, strings.Join(errs, ", "),
+			))
+		}
+	}
+	if alpnPolicy != "" && !containsString(aws.AlpnPolicies, alpnPolicy) {
+		invalid = append(invalid, fmt.Sprintf("%s: invalid ALPN policy %q, expected one of %s",
+			r.annotationKey(annotationTLSALPNPolicyKey), alpnPolicy, strings.Join(aws.AlpnPolicies, ", "),
+		))
+	}
+
+	settings := &listenerSettings{
+		certificates: certificates, secrets: secrets, sslPolicy: sslPolicy, alpnPolicy: alpnPolicy,
+	}
+	if ports := util.SplitList(r.annotation(svc, annotationTLSPortsKey)); len(ports) > 0 {
+		settings.ports = map[int64]bool{}
+		for _, port := range ports {
+			number, ok := servicePortNumber(svc, port)
+			if !ok {
+				invalid = append(invalid, fmt.Sprintf("%s: unknown Service port %q",
+					r.annotationKey(annotationTLSPortsKey), port,
+				))
+				continue
+			}
+			settings.ports[number] = true
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("invalid TLS listener annotations: %s", strings.Join(invalid, "; "))
+	}
+	return settings, nil
+}
+
+// planListenerChanges resolves the ACM certificates of the TLS listener
+// settings, importing the TLS Secrets, and returns the changes needed to apply
+// them to the load balancer. In dry run mode, the certificates are left as they
+// are until all the Secrets were imported.
+func (r *ServiceReconciler) planListenerChanges(ctx context.Context, svc *corev1.Service,
+	nlb *aws.NetworkLoadBalancer, settings *listenerSettings, dryRun bool) ([]aws.ListenerChange, error) {
+
+	if settings == nil {
+		return nil, nil
+	}
+	certificates, err := r.AWSClient.ResolveCertificates(settings.certificates)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the ACM certificates: %w", err)
+	}
+	imported, complete, err := r.importSecretCertificates(ctx, svc, settings.secrets, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	certificates = append(certificates, imported...)
+	if !complete {
+		certificates = nil
+	}
+	return nlb.PlanListenerChanges(settings.ports, aws.ListenerSettings{
+		Certificates: certificates,
+		SslPolicy:    settings.sslPolicy,
+		AlpnPolicy:   settings.alpnPolicy,
+	}), nil
+}
+
+// servicePortNumber returns the number of a Service port given by number or
+// by name
+func servicePortNumber(svc *corev1.Service, port string) (int64, bool) {
+	for _, p := range svc.Spec.Ports {
+		if p.Name == port || strconv.Itoa(int(p.Port)) == port {
+			return int64(p.Port), true
+		}
+	}
+	return 0, false
+}
+
+// formatListenerChanges returns a human readable list of listener changes
+func formatListenerChanges(changes []aws.ListenerChange) string {
+	formatted := make([]string, 0, len(changes))
+	for _, change := range changes {
+		formatted = append(formatted, change.String())
+	}
+	return fmt.Sprintf("[%s]", strings.Join(formatted, ", "))
+}
+
+// containsString returns true if the list contains the string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	reconcileOutcomeApplied  = "applied"
+	reconcileOutcomeNoop     = "noop"
+	reconcileOutcomeNotReady = "not_ready"
+	reconcileOutcomeDryRun   = "dry_run"
+	reconcileOutcomeReleased = "released"
+	reconcileOutcomeSkipped  = "skipped"
+	reconcileOutcomeError    = "error"
+
+	reconcileErrorDiscovery          = "discovery"
+	reconcileErrorAWS                = "aws"
+	reconcileErrorKubernetes         = "kubernetes"
+	reconcileErrorInvalidAnnotations = "invalid_annotations"
+	reconcileErrorConflict           = "conflict"
+)
+
+// appliedStates keeps a fingerprint of the last desired state successfully
+// applied to each Service load balancer. Changes planned for an unchanged
+// desired state are drift, made outside of the helper.
+type appliedStates struct {
+	sync.Mutex
+	states map[types.NamespacedName]string
+}
+
+// desiredStateFingerprint returns a comparable representation of the desired
+// attributes and tags of a load balancer
+func desiredStateFingerprint(attributes aws.NetworkLoadBalancerAttributes,
+	tags map[string]string, listeners *listenerSettings, securityGroups *securityGroupSettings,
+	ipAddressType string) string {
+	// maps are printed sorted by key
+	return fmt.Sprintf("%+v %v %s %s %s", attributes, tags, listeners, securityGroups, ipAddressType)
+}
+
+// isApplied returns true if the desired state was already applied to the
+// Service load balancer
+func (s *appliedStates) isApplied(service types.NamespacedName, fingerprint string) bool {
+	s.Lock()
+	defer s.Unlock()
+	applied, ok := s.states[service]
+	return ok && applied == fingerprint
+}
+
+// set stores the desired state applied to the Service load balancer
+func (s *appliedStates) set(service types.NamespacedName, fingerprint string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.states == nil {
+		s.states = map[types.NamespacedName]string{}
+	}
+	s.states[service] = fingerprint
+}
+
+// delete forgets the desired state applied to the Service load balancer
+func (s *appliedStates) delete(service types.NamespacedName) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.states, service)
+}
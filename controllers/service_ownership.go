@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getOriginalAttributes returns the snapshot of the load balancer attributes
+// stored in the Service, and whether the Service load balancer is owned by the
+// helper.
+func (r *ServiceReconciler) getOriginalAttributes(svc *corev1.Service) (aws.AttributeSnapshot, bool, error) {
+	snapshot := aws.AttributeSnapshot{}
+	value, owned := svc.GetAnnotations()[r.statusAnnotationKey(annotationOriginalAttributesKey)]
+	if !owned {
+		return snapshot, false, nil
+	}
+	if err := json.Unmarshal([]byte(value), &snapshot); err != nil {
+		return snapshot, true, fmt.Errorf(
+			"unable to parse %s annotation: %w", r.statusAnnotationKey(annotationOriginalAttributesKey), err,
+		)
+	}
+	return snapshot, true, nil
+}
+
+// isOwned returns true if the helper has taken the ownership of the Service
+// load balancer attributes.
+func (r *ServiceReconciler) isOwned(annotations map[string]string) bool {
+	_, owned := annotations[r.statusAnnotationKey(annotationOriginalAttributesKey)]
+	return owned
+}
+
+// ownership returns the ownership tags values for the Service load balancer
+func (r *ServiceReconciler) ownership(svc *corev1.Service) aws.Ownership {
+	return aws.Ownership{
+		InstanceID: r.InstanceID,
+		Service:    svc.Namespace + "/" + svc.Name,
+	}
+}
+
+// takeOwnership stores in the Service the original values of the load
+// balancer attributes before the helper modifies them for the first time, and
+// tags the load balancer and its target groups as managed by the helper.
+// Target groups attached to the load balancer after the ownership was taken
+// are added to the stored snapshot.
+func (r *ServiceReconciler) takeOwnership(
+	ctx context.Context, svc *corev1.Service, nlb *aws.NetworkLoadBalancer) error {
+
+	snapshot, owned, err := r.getOriginalAttributes(svc)
+	if err != nil {
+		return err
+	}
+	if !snapshot.Merge(nlb.Snapshot()) {
+		return r.AWSClient.ApplyTagChanges(nlb.PlanOwnershipTags(r.ownership(svc)))
+	}
+
+	value, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(svc.DeepCopy())
+	annotations := svc.GetAnnotations()
+	annotations[r.statusAnnotationKey(annotationOriginalAttributesKey)] = string(value)
+	svc.SetAnnotations(annotations)
+	if err := r.Patch(ctx, svc, patch); err != nil {
+		return err
+	}
+
+	if !owned {
+		r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonOwnershipTaken,
+			"Original load balancer attributes stored in the %s annotation",
+			r.statusAnnotationKey(annotationOriginalAttributesKey),
+		)
+	}
+	return r.AWSClient.ApplyTagChanges(nlb.PlanOwnershipTags(r.ownership(svc)))
+}
+
+// releaseOwnership is called once all the helper annotations have been
+// removed from a Service owning a load balancer. Depending on the
+// OnAnnotationsRemoved setting, the original attributes are restored before
+// removing the stored snapshot from the Service.
+func (r *ServiceReconciler) releaseOwnership(ctx context.Context, svc *corev1.Service,
+	nlb *aws.NetworkLoadBalancer, settings config.Settings) (ctrl.Result, error) {
+
+	rLogger := r.Log.WithValues("Namespace", svc.Namespace, "Service", svc.Name)
+
+	snapshot, owned, err := r.getOriginalAttributes(svc)
+	if !owned {
+		return ctrl.Result{}, nil
+	}
+	dryRun := r.isDryRun(svc, settings)
+
+	message := "load balancer attributes left as they are"
+	if r.OnAnnotationsRemoved != ReleaseOnAnnotationsRemoved {
+		message = "original load balancer attributes restored"
+		if err != nil {
+			rLogger.Error(err, "unable to restore the original load balancer attributes")
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonRestoreFailed,
+				"Unable to restore the original load balancer attributes: %v", err,
+			)
+			return ctrl.Result{}, nil
+		}
+		if len(snapshot.Listeners) > 0 {
+			if err := r.AWSClient.GetListenerAttributes(nlb); err != nil {
+				rLogger.Error(err, "unable to get the load balancer listener attributes")
+				r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonRestoreFailed,
+					"Unable to restore the original load balancer attributes: %v", err,
+				)
+				return ctrl.Result{}, nil
+			}
+		}
+
+		changes := nlb.PlanRestoreChanges(snapshot)
+		tagChanges := nlb.PlanResourceTagsRemoval(r.ProtectedTagPrefixes)
+		if dryRun {
+			if len(changes) > 0 {
+				r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonPlannedChanges,
+					"Dry run, planned restore changes: %s", formatAttributeChanges(changes),
+				)
+			}
+			if len(tagChanges) > 0 {
+				r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonPlannedChanges,
+					"Dry run, planned tag changes: %s", formatTagChanges(tagChanges),
+				)
+			}
+			return ctrl.Result{}, nil
+		}
+		if err := r.AWSClient.ApplyAttributeChanges(changes); err != nil {
+			rLogger.Error(err, "unable to restore the original load balancer attributes")
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonRestoreFailed,
+				"Unable to restore the original load balancer attributes: %v", err,
+			)
+			return ctrl.Result{}, nil
+		}
+		if len(changes) > 0 {
+			rLogger.Info("Original load balancer attributes restored",
+				"changes", formatAttributeChanges(changes),
+			)
+		}
+		if err := r.AWSClient.ApplyTagChanges(tagChanges); err != nil {
+			rLogger.Error(err, "unable to remove the load balancer tags")
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonRestoreFailed,
+				"Unable to remove the load balancer tags: %v", err,
+			)
+			return ctrl.Result{}, nil
+		}
+	}
+
+	if dryRun {
+		return ctrl.Result{}, nil
+	}
+
+	// the managed security group is detached and deleted whatever the release
+	// mode, it was created by the helper. Its deletion usually fails right
+	// after it is detached, while the load balancer network interfaces still
+	// use it, so the ownership is kept until it is deleted on a later retry.
+	securityGroupChanges, err := r.planSecurityGroupChanges(svc, nlb, nil)
+	if err == nil && !securityGroupChanges.IsEmpty() {
+		err = r.AWSClient.ApplySecurityGroupChanges(securityGroupChanges, r.ownership(svc), nlb.VpcID)
+	}
+	if err != nil {
+		rLogger.Error(err, "unable to delete the managed security group")
+		r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonRestoreFailed,
+			"Unable to delete the managed security group: %v", err,
+		)
+		return ctrl.Result{}, err
+	}
+
+	if err := r.AWSClient.ApplyTagChanges(nlb.PlanOwnershipTagsRemoval()); err != nil {
+		rLogger.Error(err, "unable to remove the load balancer ownership tags")
+		return ctrl.Result{}, err
+	}
+
+	patch := client.MergeFrom(svc.DeepCopy())
+	annotations := svc.GetAnnotations()
+	delete(annotations, r.statusAnnotationKey(annotationOriginalAttributesKey))
+	delete(annotations, r.statusAnnotationKey(annotationEffectiveAttributesKey))
+	delete(annotations, r.statusAnnotationKey(annotationIPAddressTypeKey))
+	svc.SetAnnotations(annotations)
+	if err := r.Patch(ctx, svc, patch); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonOwnershipReleased,
+		"Load balancer ownership released, %s", message,
+	)
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// proxyProtocolRolloutPollInterval is the interval between the health checks
+// of a target group during its soak period
+const proxyProtocolRolloutPollInterval = 15 * time.Second
+
+// errorSignalClient polls the rollout error signal URL
+var errorSignalClient = &http.Client{Timeout: 5 * time.Second}
+
+// proxyProtocolRollout is the state of a guarded proxy protocol rollout,
+// stored in a Service status annotation so it survives the operator restarts
+type proxyProtocolRollout struct {
+	// Desired is the proxy protocol value being rolled out
+	Desired string `json:"desired"`
+	// Changed are the target group changes applied, the last one soaking
+	Changed []aws.AttributeChange `json:"changed,omitempty"`
+	// Since is the start of the current soak period
+	Since metav1.Time `json:"since,omitempty"`
+	// RolledBack is set once the rollout has been rolled back, it is held
+	// until the desired value changes
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// rolloutResult are the changes to apply after the guarded rollout step,
+// and when to check the rollout again
+type rolloutResult struct {
+	changes      []aws.AttributeChange
+	requeueAfter time.Duration
+	// pending is true while the desired proxy protocol is not applied to all
+	// the target groups
+	pending bool
+	// step is the rollout state to store once the changes are applied, nil
+	// if the rollout doesn't change any target group
+	step *proxyProtocolRollout
+	// message describes the rollout step, reported once it is stored
+	message string
+}
+
+// isGuardedRollout returns true if the proxy protocol changes of the Service
+// load balancer must be rolled out one target group at a time, either because
+// it is enabled in the settings or because the Service is annotated to do so.
+func (r *ServiceReconciler) isGuardedRollout(svc *corev1.Service, settings config.Settings) bool {
+	if settings.ProxyProtocolRollout.Guarded {
+		return true
+	}
+	guarded, err := parseBool(r.annotation(svc, annotationGuardedRolloutKey))
+	return err == nil && guarded
+}
+
+// guardProxyProtocolRollout filters the proxy protocol changes out of the
+// planned changes, keeping only the next step of the guarded rollout: the
+// target groups are changed one at a time, each one soaking before changing
+// the next one. If the health of the soaking target group drops below the
+// threshold, or the error signal fails, all the changed target groups are
+// reverted and the rollout is held until the desired value changes.
+func (r *ServiceReconciler) guardProxyProtocolRollout(ctx context.Context, svc *corev1.Service,
+	changes []aws.AttributeChange, settings config.Settings) (rolloutResult, error) {
+
+	if !r.isGuardedRollout(svc, settings) {
+		return rolloutResult{changes: changes}, r.removeStatusAnnotation(ctx, svc, annotationProxyProtocolRolloutKey)
+	}
+
+	result := rolloutResult{}
+	proxyProtocolChanges := []aws.AttributeChange{}
+	for _, change := range changes {
+		if change.IsProxyProtocolChange() {
+			proxyProtocolChanges = append(proxyProtocolChanges, change)
+			continue
+		}
+		result.changes = append(result.changes, change)
+	}
+
+	state, err := r.getProxyProtocolRollout(svc)
+	if err != nil {
+		r.Log.Info("Ignoring the invalid proxy protocol rollout state", "error", err.Error())
+	}
+	if state != nil && len(proxyProtocolChanges) > 0 && proxyProtocolChanges[0].Desired != state.Desired {
+		// the desired value changed, start over
+		state = nil
+	}
+
+	if state != nil && state.RolledBack {
+		if len(proxyProtocolChanges) == 0 {
+			return result, r.removeStatusAnnotation(ctx, svc, annotationProxyProtocolRolloutKey)
+		}
+		result.pending = true
+		return result, nil
+	}
+
+	if state == nil {
+		if len(proxyProtocolChanges) == 0 {
+			return result, r.removeStatusAnnotation(ctx, svc, annotationProxyProtocolRolloutKey)
+		}
+		state = &proxyProtocolRollout{Desired: proxyProtocolChanges[0].Desired}
+	}
+	result.pending = true
+
+	if len(state.Changed) > 0 {
+		soaking := state.Changed[len(state.Changed)-1]
+		failure, err := r.checkRolloutHealth(soaking.ResourceARN, settings.ProxyProtocolRollout)
+		if err != nil {
+			return result, err
+		}
+		if failure != "" {
+			return result, r.rollbackProxyProtocol(ctx, svc, state, failure)
+		}
+		soakPeriod := settings.ProxyProtocolRollout.SoakPeriod.Duration
+		if remaining := soakPeriod - time.Since(state.Since.Time); remaining > 0 {
+			result.requeueAfter = proxyProtocolRolloutPollInterval
+			if remaining < result.requeueAfter {
+				result.requeueAfter = remaining
+			}
+			return result, nil
+		}
+	}
+
+	if len(proxyProtocolChanges) == 0 {
+		r.Recorder.Eventf(svc, corev1.EventTypeNormal, eventReasonRolloutDone,
+			"Proxy protocol %s rolled out to the %d target groups",
+			proxyProtocolValue(state.Desired), len(state.Changed),
+		)
+		result.pending = false
+		return result, r.removeStatusAnnotation(ctx, svc, annotationProxyProtocolRolloutKey)
+	}
+
+	// the next target group is only recorded as changed, and soaking, once
+	// the change is applied, with commitProxyProtocolRollout
+	next := proxyProtocolChanges[0]
+	state.Changed = append(state.Changed, next)
+	state.Since = metav1.Now()
+	result.step = state
+	result.message = fmt.Sprintf("Proxy protocol %s on target group %d of %d, soaking for %s: %s",
+		proxyProtocolValue(state.Desired), len(state.Changed), len(state.Changed)+len(proxyProtocolChanges)-1,
+		settings.ProxyProtocolRollout.SoakPeriod.Duration, next,
+	)
+	result.changes = append(result.changes, next)
+	result.requeueAfter = proxyProtocolRolloutPollInterval
+	return result, nil
+}
+
+// commitProxyProtocolRollout stores the rollout state of the step once its
+// target group change is applied, and reports the step
+func (r *ServiceReconciler) commitProxyProtocolRollout(ctx context.Context, svc *corev1.Service,
+	result rolloutResult) error {
+
+	if result.step == nil {
+		return nil
+	}
+	if err := r.setProxyProtocolRollout(ctx, svc, result.step); err != nil {
+		return err
+	}
+	r.Recorder.Event(svc, corev1.EventTypeNormal, eventReasonRolloutStep, result.message)
+	return nil
+}
+
+// checkRolloutHealth returns the reason to roll back the rollout, if the
+// percentage of healthy targets of the target group is below the threshold
+// or the error signal fails. The target health can't be checked on error.
+func (r *ServiceReconciler) checkRolloutHealth(
+	targetGroupARN string, rollout config.ProxyProtocolRollout) (string, error) {
+
+	targets, err := r.AWSClient.GetTargetHealth(targetGroupARN)
+	if err != nil {
+		return "", fmt.Errorf("unable to get the target group health: %w", err)
+	}
+	summary := aws.SummarizeTargetHealth(targets)
+	if total := summary.Healthy + summary.Unhealthy + summary.Initial; total > 0 {
+		if percent := summary.Healthy * 100 / total; percent < *rollout.MinHealthyPercent {
+			return fmt.Sprintf("%d%% healthy targets, below %d%%: %s", percent, *rollout.MinHealthyPercent, summary), nil
+		}
+	}
+
+	if rollout.ErrorSignalURL == "" {
+		return "", nil
+	}
+	resp, err := errorSignalClient.Get(rollout.ErrorSignalURL)
+	if err != nil {
+		return fmt.Sprintf("error signal failed: %v", err), nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Sprintf("error signal failed: %s answered %s", rollout.ErrorSignalURL, resp.Status), nil
+	}
+	return "", nil
+}
+
+// rollbackProxyProtocol reverts the proxy protocol of all the target groups
+// changed by the rollout, and holds it
+func (r *ServiceReconciler) rollbackProxyProtocol(ctx context.Context, svc *corev1.Service,
+	state *proxyProtocolRollout, reason string) error {
+
+	reverts := make([]aws.AttributeChange, 0, len(state.Changed))
+	for _, change := range state.Changed {
+		reverts = append(reverts, change.Revert())
+	}
+	if err := r.AWSClient.ApplyAttributeChanges(reverts); err != nil {
+		r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonUpdateFailed,
+			"Unable to roll back the proxy protocol: %v", err,
+		)
+		return err
+	}
+
+	r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonRolledBack,
+		"Proxy protocol %s rolled back on %d target groups, held until the desired value changes: %s",
+		proxyProtocolValue(state.Desired), len(reverts), reason,
+	)
+	state.RolledBack = true
+	return r.setProxyProtocolRollout(ctx, svc, state)
+}
+
+// getProxyProtocolRollout returns the rollout state of the Service, nil if no
+// rollout is in progress
+func (r *ServiceReconciler) getProxyProtocolRollout(svc *corev1.Service) (*proxyProtocolRollout, error) {
+	value, ok := svc.GetAnnotations()[r.statusAnnotationKey(annotationProxyProtocolRolloutKey)]
+	if !ok {
+		return nil, nil
+	}
+	state := &proxyProtocolRollout{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return nil, fmt.Errorf("unable to parse %s annotation: %w",
+			r.statusAnnotationKey(annotationProxyProtocolRolloutKey), err,
+		)
+	}
+	return state, nil
+}
+
+// setProxyProtocolRollout stores the rollout state of the Service
+func (r *ServiceReconciler) setProxyProtocolRollout(
+	ctx context.Context, svc *corev1.Service, state *proxyProtocolRollout) error {
+
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return r.setStatusAnnotation(ctx, svc, annotationProxyProtocolRolloutKey, string(value))
+}
+
+// proxyProtocolValue returns a human readable proxy protocol value
+func proxyProtocolValue(desired string) string {
+	if desired == "true" {
+		return "enablement"
+	}
+	return "disablement"
+}
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// targetHealthResponse is a DescribeTargetHealth result with a target per
+// state
+func targetHealthResponse(states ...string) string {
+	members := ""
+	for i, state := range states {
+		members += `<member><Target><Id>i-` + strconv.Itoa(i) + `</Id><Port>80</Port></Target>` +
+			`<TargetHealth><State>` + state + `</State></TargetHealth></member>`
+	}
+	return `<TargetHealthDescriptions>` + members + `</TargetHealthDescriptions>`
+}
+
+// proxyProtocolChange returns the proxy protocol enablement of a target group
+func proxyProtocolChange(tg string) aws.AttributeChange {
+	return aws.AttributeChange{
+		ResourceARN:  "arn:aws:elasticloadbalancing:us-east-1:000000000000:targetgroup/" + tg + "/1",
+		ResourceType: "targetgroup",
+		Key:          aws.TargetGroupProxyProtocolKey,
+		Current:      "false",
+		Desired:      "true",
+	}
+}
+
+// newRolloutReconciler returns a reconciler for the Service, with the AWS
+// API stubbed
+func newRolloutReconciler(t *testing.T, svc *corev1.Service,
+	responses, errors map[string]string) (*ServiceReconciler, *awsStub) {
+
+	awsClient, stub := newAWSStub(t, responses, errors)
+	return &ServiceReconciler{
+		Client:           fake.NewClientBuilder().WithObjects(svc).Build(),
+		Log:              ctrl.Log,
+		Recorder:         record.NewFakeRecorder(10),
+		AWSClient:        awsClient,
+		AnnotationPrefix: config.DefaultAnnotationPrefix,
+	}, stub
+}
+
+// storedRollout returns the rollout state stored in the Service, nil if none
+func storedRollout(t *testing.T, r *ServiceReconciler, svc *corev1.Service) *proxyProtocolRollout {
+	current := &corev1.Service{}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(svc), current); err != nil {
+		t.Fatal(err)
+	}
+	state, err := r.getProxyProtocolRollout(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestServiceReconciler_guardProxyProtocolRollout(t *testing.T) {
+	tg1, tg2 := proxyProtocolChange("tg1"), proxyProtocolChange("tg2")
+	deletionProtection := aws.AttributeChange{
+		ResourceARN:  "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/lb/1",
+		ResourceType: "loadbalancer",
+		Key:          aws.LoadBalancerDeletionProtectionKey,
+		Current:      "false",
+		Desired:      "true",
+	}
+	minHealthy := 50
+	guarded := config.Settings{ProxyProtocolRollout: config.ProxyProtocolRollout{
+		Guarded:           true,
+		SoakPeriod:        &metav1.Duration{Duration: time.Minute},
+		MinHealthyPercent: &minHealthy,
+	}}
+
+	tests := []struct {
+		name     string
+		settings config.Settings
+		state    *proxyProtocolRollout
+		changes  []aws.AttributeChange
+		health   []string
+		// wantChanges are the changes to apply
+		wantChanges []aws.AttributeChange
+		wantPending bool
+		// wantStep are the target groups of the step to commit, nil if none
+		wantStep []aws.AttributeChange
+		// wantStored is the stored rollout state after the step, before any
+		// commit
+		wantStored *proxyProtocolRollout
+	}{
+		{
+			name:        "not guarded",
+			changes:     []aws.AttributeChange{deletionProtection, tg1, tg2},
+			wantChanges: []aws.AttributeChange{deletionProtection, tg1, tg2},
+		},
+		{
+			name:        "first step",
+			settings:    guarded,
+			changes:     []aws.AttributeChange{deletionProtection, tg1, tg2},
+			wantChanges: []aws.AttributeChange{deletionProtection, tg1},
+			wantPending: true,
+			wantStep:    []aws.AttributeChange{tg1},
+		},
+		{
+			name:        "soaking",
+			settings:    guarded,
+			state:       &proxyProtocolRollout{Desired: "true", Changed: []aws.AttributeChange{tg1}, Since: metav1.Now()},
+			changes:     []aws.AttributeChange{tg2},
+			health:      []string{"healthy", "healthy"},
+			wantPending: true,
+			wantStored:  &proxyProtocolRollout{Desired: "true", Changed: []aws.AttributeChange{tg1}},
+		},
+		{
+			name:     "next step",
+			settings: guarded,
+			state: &proxyProtocolRollout{
+				Desired: "true", Changed: []aws.AttributeChange{tg1}, Since: metav1.NewTime(time.Now().Add(-2 * time.Minute)),
+			},
+			changes:     []aws.AttributeChange{tg2},
+			health:      []string{"healthy", "unhealthy"},
+			wantChanges: []aws.AttributeChange{tg2},
+			wantPending: true,
+			wantStep:    []aws.AttributeChange{tg1, tg2},
+			wantStored:  &proxyProtocolRollout{Desired: "true", Changed: []aws.AttributeChange{tg1}},
+		},
+		{
+			name:        "unhealthy",
+			settings:    guarded,
+			state:       &proxyProtocolRollout{Desired: "true", Changed: []aws.AttributeChange{tg1}, Since: metav1.Now()},
+			changes:     []aws.AttributeChange{tg2},
+			health:      []string{"healthy", "unhealthy", "unhealthy"},
+			wantPending: true,
+			wantStored:  &proxyProtocolRollout{Desired: "true", Changed: []aws.AttributeChange{tg1}, RolledBack: true},
+		},
+		{
+			name:     "rolled out",
+			settings: guarded,
+			state: &proxyProtocolRollout{
+				Desired: "true", Changed: []aws.AttributeChange{tg1, tg2}, Since: metav1.NewTime(time.Now().Add(-2 * time.Minute)),
+			},
+			health: []string{"healthy"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "svc"}}
+			r, _ := newRolloutReconciler(t, svc, map[string]string{
+				"DescribeTargetHealth":        targetHealthResponse(tt.health...),
+				"ModifyTargetGroupAttributes": `<Attributes></Attributes>`,
+			}, nil)
+			if tt.state != nil {
+				if err := r.setProxyProtocolRollout(context.Background(), svc, tt.state); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := r.guardProxyProtocolRollout(context.Background(), svc, tt.changes, tt.settings)
+			if err != nil {
+				t.Fatalf("guardProxyProtocolRollout() error = %v", err)
+			}
+			if formatAttributeChanges(got.changes) != formatAttributeChanges(tt.wantChanges) {
+				t.Errorf("guardProxyProtocolRollout() changes = %s, want %s",
+					formatAttributeChanges(got.changes), formatAttributeChanges(tt.wantChanges))
+			}
+			if got.pending != tt.wantPending {
+				t.Errorf("guardProxyProtocolRollout() pending = %t, want %t", got.pending, tt.wantPending)
+			}
+			if got.step == nil && tt.wantStep != nil || got.step != nil &&
+				formatAttributeChanges(got.step.Changed) != formatAttributeChanges(tt.wantStep) {
+				t.Errorf("guardProxyProtocolRollout() step = %+v, want %s", got.step, formatAttributeChanges(tt.wantStep))
+			}
+
+			// the step is only stored once committed, after the change is applied
+			stored := storedRollout(t, r, svc)
+			if (stored == nil) != (tt.wantStored == nil) || stored != nil && (stored.RolledBack != tt.wantStored.RolledBack ||
+				formatAttributeChanges(stored.Changed) != formatAttributeChanges(tt.wantStored.Changed)) {
+				t.Errorf("guardProxyProtocolRollout() stored state = %+v, want %+v", stored, tt.wantStored)
+			}
+			if err := r.commitProxyProtocolRollout(context.Background(), svc, got); err != nil {
+				t.Fatalf("commitProxyProtocolRollout() error = %v", err)
+			}
+			if stored := storedRollout(t, r, svc); tt.wantStep != nil &&
+				(stored == nil || formatAttributeChanges(stored.Changed) != formatAttributeChanges(tt.wantStep)) {
+				t.Errorf("commitProxyProtocolRollout() stored state = %+v, want %s", stored, formatAttributeChanges(tt.wantStep))
+			}
+		})
+	}
+}
+
+func TestServiceReconciler_checkRolloutHealth(t *testing.T) {
+	signal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/failing" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer signal.Close()
+
+	minHealthy := 50
+	tests := []struct {
+		name        string
+		health      []string
+		errors      map[string]string
+		signalURL   string
+		wantFailure string
+		wantErr     bool
+	}{
+		{name: "healthy", health: []string{"healthy", "unhealthy"}},
+		{name: "no targets"},
+		{name: "draining targets ignored", health: []string{"healthy", "draining", "draining"}},
+		{
+			name:        "below threshold",
+			health:      []string{"healthy", "unhealthy", "initial"},
+			wantFailure: "33% healthy targets, below 50%",
+		},
+		{name: "error signal", health: []string{"healthy"}, signalURL: signal.URL + "/ok"},
+		{
+			name:        "failing error signal",
+			health:      []string{"healthy"},
+			signalURL:   signal.URL + "/failing",
+			wantFailure: "error signal failed",
+		},
+		{
+			name:    "target health not described",
+			errors:  map[string]string{"DescribeTargetHealth": "TargetGroupNotFound"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "svc"}}
+			r, _ := newRolloutReconciler(t, svc, map[string]string{
+				"DescribeTargetHealth": targetHealthResponse(tt.health...),
+			}, tt.errors)
+
+			failure, err := r.checkRolloutHealth(proxyProtocolChange("tg1").ResourceARN, config.ProxyProtocolRollout{
+				MinHealthyPercent: &minHealthy,
+				ErrorSignalURL:    tt.signalURL,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkRolloutHealth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (failure == "") != (tt.wantFailure == "") || !strings.HasPrefix(failure, tt.wantFailure) {
+				t.Errorf("checkRolloutHealth() = %q, want %q", failure, tt.wantFailure)
+			}
+		})
+	}
+}
+
+func TestServiceReconciler_rollbackProxyProtocol(t *testing.T) {
+	tg1, tg2 := proxyProtocolChange("tg1"), proxyProtocolChange("tg2")
+	tests := []struct {
+		name           string
+		errors         map[string]string
+		wantErr        bool
+		wantRolledBack bool
+	}{
+		{name: "rolled back", wantRolledBack: true},
+		{
+			name:    "not rolled back",
+			errors:  map[string]string{"ModifyTargetGroupAttributes": "ValidationError"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "svc"}}
+			r, stub := newRolloutReconciler(t, svc, map[string]string{
+				"ModifyTargetGroupAttributes": `<Attributes></Attributes>`,
+			}, tt.errors)
+			state := &proxyProtocolRollout{Desired: "true", Changed: []aws.AttributeChange{tg1, tg2}}
+
+			err := r.rollbackProxyProtocol(context.Background(), svc, state, "unhealthy")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rollbackProxyProtocol() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			reverted := []string{}
+			for _, params := range stub.called("ModifyTargetGroupAttributes") {
+				reverted = append(reverted, params.Get("TargetGroupArn")+"="+params.Get("Attributes.member.1.Value"))
+			}
+			if !tt.wantErr && strings.Join(reverted, " ") != tg1.ResourceARN+"=false "+tg2.ResourceARN+"=false" {
+				t.Errorf("rollbackProxyProtocol() reverted %v, want both target groups to false", reverted)
+			}
+
+			stored := storedRollout(t, r, svc)
+			if rolledBack := stored != nil && stored.RolledBack; rolledBack != tt.wantRolledBack {
+				value, _ := json.Marshal(stored)
+				t.Errorf("rollbackProxyProtocol() stored state = %s, want rolled back %t", value, tt.wantRolledBack)
+			}
+		})
+	}
+}
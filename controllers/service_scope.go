@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// SetupWithManager sets up the controller with the Manager. Besides the
+// Services, the Namespaces are watched so the Services are rescoped when
+// their namespace labels change, as well as the settings so the Services are
+// rescoped when the namespace selector changes. The Secrets metadata is
+// watched so their certificates are re-imported when they are renewed.
+func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+
+	rescope := make(chan event.GenericEvent)
+	r.Settings.OnChange(func(old, new config.Settings) {
+		if !reflect.DeepEqual(old.NamespaceSelector, new.NamespaceSelector) {
+			go r.rescopeServices(rescope)
+		}
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Service{}, builder.WithPredicates(r.filterAnnotatedServices())).
+		Watches(
+			&source.Kind{Type: &corev1.Namespace{}},
+			handler.EnqueueRequestsFromMapFunc(r.namespaceServices),
+			builder.WithPredicates(predicate.LabelChangedPredicate{}),
+		).
+		Watches(&source.Channel{Source: rescope}, &handler.EnqueueRequestForObject{}).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.secretServices),
+			builder.OnlyMetadata,
+		).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.Concurrency}).
+		Complete(r)
+}
+
+// inScope returns true if the Service and its namespace match the configured
+// label selectors.
+func (r *ServiceReconciler) inScope(svc *corev1.Service, ns *corev1.Namespace, settings config.Settings) bool {
+	return r.serviceSelector().Matches(labels.Set(svc.GetLabels())) &&
+		settings.NamespaceLabelSelector().Matches(labels.Set(ns.GetLabels()))
+}
+
+// isCachedInScope returns true if the Service and its cached namespace match
+// the configured label selectors. The Service is considered in scope if its
+// namespace can't be fetched, the reconcile will retry.
+func (r *ServiceReconciler) isCachedInScope(svc *corev1.Service) bool {
+	ns := &corev1.Namespace{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: svc.Namespace}, ns); err != nil {
+		return true
+	}
+	return r.inScope(svc, ns, r.Settings.Get())
+}
+
+// serviceSelector returns the Services selector, matching everything if unset
+func (r *ServiceReconciler) serviceSelector() labels.Selector {
+	if r.ServiceSelector == nil {
+		return labels.Everything()
+	}
+	return r.ServiceSelector
+}
+
+// namespaceServices maps a Namespace to its candidate Services, so they are
+// rescoped when the namespace labels change.
+func (r *ServiceReconciler) namespaceServices(obj client.Object) []reconcile.Request {
+
+	services := &corev1.ServiceList{}
+	if err := r.List(context.Background(), services, client.InNamespace(obj.GetName())); err != nil {
+		r.Log.Error(err, "unable to list the namespace Services", "Namespace", obj.GetName())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for i := range services.Items {
+		if r.isCandidate(&services.Items[i]) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(&services.Items[i]),
+			})
+		}
+	}
+	return requests
+}
+
+// rescopeServices enqueues all the candidate Services, so they are rescoped
+// after a namespace selector change.
+func (r *ServiceReconciler) rescopeServices(rescope chan<- event.GenericEvent) {
+
+	services := &corev1.ServiceList{}
+	if err := r.List(context.Background(), services); err != nil {
+		r.Log.Error(err, "unable to list the Services")
+		return
+	}
+
+	r.Log.Info("Namespace selector changed, rescoping the Services")
+	for i := range services.Items {
+		if r.isCandidate(&services.Items[i]) {
+			rescope <- event.GenericEvent{Object: &services.Items[i]}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	util "github.com/3scale-ops/aws-nlb-helper-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// defaultSourceRange is the source range of the managed security group rules
+// when the Service doesn't restrict them
+const defaultSourceRange = "0.0.0.0/0"
+
+// securityGroupSettings are the security group settings of a Service, the
+// security groups being references to resolve in the load balancer VPC
+type securityGroupSettings struct {
+	securityGroups     []string
+	managed            bool
+	rules              []aws.SecurityGroupRule
+	enforcePrivateLink string
+}
+
+// String returns a human readable representation of the settings, used in
+// the desired state fingerprint
+func (s *securityGroupSettings) String() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("security-groups=%v managed=%t rules=%v enforce-private-link=%s",
+		s.securityGroups, s.managed, s.rules, s.enforcePrivateLink,
+	)
+}
+
+// getSecurityGroupSettings returns the security group settings of the
+// Service, nil if the Service manages none of them
+func (r *ServiceReconciler) getSecurityGroupSettings(svc *corev1.Service) (*securityGroupSettings, error) {
+
+	securityGroups := util.SplitList(r.annotation(svc, annotationSecurityGroupsKey))
+	managedValue, managedSet := r.lookupAnnotation(svc.GetAnnotations(), annotationManagedSecurityGroupKey)
+	privateLinkValue, privateLinkSet := r.lookupAnnotation(svc.GetAnnotations(), annotationSecurityGroupsPrivateLinkKey)
+	if len(securityGroups) == 0 && !managedSet && !privateLinkSet {
+		return nil, nil
+	}
+
+	invalid := []string{}
+	for _, ref := range securityGroups {
+		if err := aws.ValidateSecurityGroupReference(ref); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %v", r.annotationKey(annotationSecurityGroupsKey), err))
+		}
+	}
+
+	settings := &securityGroupSettings{securityGroups: securityGroups}
+	if managedSet {
+		managed, err := parseBool(managedValue)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %v", r.annotationKey(annotationManagedSecurityGroupKey), err))
+		}
+		settings.managed = managed
+	}
+	if settings.managed {
+		rules, err := securityGroupRules(svc)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+		}
+		settings.rules = rules
+	}
+	if privateLinkSet {
+		enforce, err := parseBool(privateLinkValue)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %v", r.annotationKey(annotationSecurityGroupsPrivateLinkKey), err))
+		}
+		settings.enforcePrivateLink = "off"
+		if enforce {
+			settings.enforcePrivateLink = "on"
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("invalid security group annotations: %s", strings.Join(invalid, "; "))
+	}
+	return settings, nil
+}
+
+// securityGroupRules returns the inbound rules of the managed security group,
+// allowing the TCP and UDP ports of the Service from its load balancer source
+// ranges, or from anywhere if the Service doesn't restrict them
+func securityGroupRules(svc *corev1.Service) ([]aws.SecurityGroupRule, error) {
+
+	ranges := svc.Spec.LoadBalancerSourceRanges
+	if len(ranges) == 0 {
+		ranges = util.SplitList(svc.GetAnnotations()[awsSourceRangesAnnotationKey])
+	}
+	if len(ranges) == 0 {
+		ranges = []string{defaultSourceRange}
+	}
+
+	cidrs := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid load balancer source range %q: %w", r, err)
+		}
+		cidrs = append(cidrs, network.String())
+	}
+
+	seen := map[aws.SecurityGroupRule]bool{}
+	rules := []aws.SecurityGroupRule{}
+	for _, port := range svc.Spec.Ports {
+		if port.Protocol != corev1.ProtocolTCP && port.Protocol != corev1.ProtocolUDP {
+			continue
+		}
+		for _, cidr := range cidrs {
+			rule := aws.SecurityGroupRule{
+				Protocol: strings.ToLower(string(port.Protocol)),
+				FromPort: int64(port.Port),
+				ToPort:   int64(port.Port),
+				CIDR:     cidr,
+			}
+			if !seen[rule] {
+				seen[rule] = true
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// planSecurityGroupChanges resolves the security groups of the settings and
+// returns the changes needed to apply them to the load balancer. The managed
+// security group is looked up even without settings, to detach and delete it
+// once the Service no longer asks for it.
+func (r *ServiceReconciler) planSecurityGroupChanges(svc *corev1.Service,
+	nlb *aws.NetworkLoadBalancer, settings *securityGroupSettings) (aws.SecurityGroupChanges, error) {
+
+	if settings == nil && len(nlb.SecurityGroups) == 0 {
+		return aws.SecurityGroupChanges{}, nil
+	}
+	managed, err := r.AWSClient.GetManagedSecurityGroup(r.ownership(svc), nlb.VpcID)
+	if err != nil {
+		return aws.SecurityGroupChanges{}, fmt.Errorf("unable to get the managed security group: %w", err)
+	}
+	if settings == nil {
+		return nlb.PlanSecurityGroupChanges(aws.SecurityGroupSettings{}, managed)
+	}
+
+	securityGroups, err := r.AWSClient.ResolveSecurityGroups(nlb.VpcID, settings.securityGroups)
+	if err != nil {
+		return aws.SecurityGroupChanges{}, fmt.Errorf("unable to resolve the security groups: %w", err)
+	}
+	return nlb.PlanSecurityGroupChanges(aws.SecurityGroupSettings{
+		SecurityGroups:     securityGroups,
+		Managed:            settings.managed,
+		Rules:              settings.rules,
+		EnforcePrivateLink: settings.enforcePrivateLink,
+	}, managed)
+}
+
+// SecurityGroupCleaner deletes the managed security groups of the helper
+// instance whose Service no longer exists, as the deleted Services are not
+// reconciled. It shares the settings of the ServiceReconciler.
+type SecurityGroupCleaner struct {
+	*ServiceReconciler
+	// Interval between cleanups
+	Interval time.Duration
+}
+
+// Start runs a cleanup every Interval until the context is done, implementing
+// the manager Runnable interface.
+func (c *SecurityGroupCleaner) Start(ctx context.Context) error {
+
+	cLogger := c.Log.WithName("securitygroups")
+	cLogger.Info("Starting managed security groups cleanups", "interval", c.Interval)
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Clean(ctx); err != nil {
+			cLogger.Error(err, "unable to clean the managed security groups")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes the cleanups run only on the leader instance
+func (c *SecurityGroupCleaner) NeedLeaderElection() bool {
+	return true
+}
+
+// Clean deletes the managed security groups whose Service no longer exists.
+// The security groups are listed before the Services, so that the Service of
+// a security group created while the cleanup runs is always found. The
+// security groups still used by a load balancer, like the one of a deleted
+// Service until the cloud provider deletes it, can't be deleted and are left
+// for the next cleanup.
+func (c *SecurityGroupCleaner) Clean(ctx context.Context) error {
+
+	cLogger := c.Log.WithName("securitygroups")
+
+	groups, err := c.AWSClient.ListManagedSecurityGroups(c.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	services := &corev1.ServiceList{}
+	if err := c.apiReader().List(ctx, services); err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, svc := range services.Items {
+		existing[types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}.String()] = true
+	}
+
+	dryRun := c.DryRun || c.Settings.Get().DryRun
+	for _, group := range groups {
+		if existing[group.Service] {
+			continue
+		}
+		if dryRun {
+			cLogger.Info("Dry run, planned deletion of the managed security group",
+				"SecurityGroupID", group.ID, "Service", group.Service,
+			)
+			continue
+		}
+		if err := c.AWSClient.DeleteSecurityGroup(group.ID); err != nil {
+			cLogger.Info("Unable to delete the managed security group, it may still be in use",
+				"SecurityGroupID", group.ID, "Service", group.Service, "error", err.Error(),
+			)
+			continue
+		}
+		cLogger.Info("Managed security group deleted", "SecurityGroupID", group.ID, "Service", group.Service)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_securityGroupRules(t *testing.T) {
+	ports := []corev1.ServicePort{
+		{Name: "https", Port: 443, Protocol: corev1.ProtocolTCP},
+		{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+		{Name: "sctp", Port: 9000, Protocol: corev1.ProtocolSCTP},
+	}
+	tests := []struct {
+		name        string
+		ranges      []string
+		annotations map[string]string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name: "anywhere",
+			want: []string{"tcp/443 from 0.0.0.0/0", "udp/53 from 0.0.0.0/0"},
+		},
+		{
+			name:   "source ranges",
+			ranges: []string{"10.0.0.0/8", "2001:db8::/32"},
+			want: []string{
+				"tcp/443 from 10.0.0.0/8", "tcp/443 from 2001:db8::/32",
+				"udp/53 from 10.0.0.0/8", "udp/53 from 2001:db8::/32",
+			},
+		},
+		{
+			name:        "source ranges annotation",
+			annotations: map[string]string{awsSourceRangesAnnotationKey: "192.168.1.10/24, 10.0.0.0/8"},
+			want: []string{
+				"tcp/443 from 192.168.1.0/24", "tcp/443 from 10.0.0.0/8",
+				"udp/53 from 192.168.1.0/24", "udp/53 from 10.0.0.0/8",
+			},
+		},
+		{
+			name:        "spec over annotation",
+			ranges:      []string{"10.0.0.0/8"},
+			annotations: map[string]string{awsSourceRangesAnnotationKey: "192.168.1.0/24"},
+			want:        []string{"tcp/443 from 10.0.0.0/8", "udp/53 from 10.0.0.0/8"},
+		},
+		{
+			name:    "invalid range",
+			ranges:  []string{"10.0.0.0"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+				Spec:       corev1.ServiceSpec{Ports: ports, LoadBalancerSourceRanges: tt.ranges},
+			}
+			rules, err := securityGroupRules(svc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("securityGroupRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := []string{}
+			for _, rule := range rules {
+				got = append(got, rule.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("securityGroupRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// managedSecurityGroupsResponse is a DescribeSecurityGroups response listing
+// the managed security groups of the helper instance, by Service
+func managedSecurityGroupsResponse(groups map[string]string) string {
+	items := ""
+	for id, service := range groups {
+		items += `<item><groupId>` + id + `</groupId><tagSet>` +
+			`<item><key>aws-nlb-helper.3scale.net/managed-by</key><value>aws-nlb-helper-operator</value></item>` +
+			`<item><key>aws-nlb-helper.3scale.net/instance-id</key><value>instance</value></item>` +
+			`<item><key>aws-nlb-helper.3scale.net/service</key><value>` + service + `</value></item>` +
+			`</tagSet></item>`
+	}
+	return `<DescribeSecurityGroupsResponse><securityGroupInfo>` + items +
+		`</securityGroupInfo></DescribeSecurityGroupsResponse>`
+}
+
+func Test_SecurityGroupCleaner_Clean(t *testing.T) {
+	responses := map[string]string{
+		"DescribeSecurityGroups": managedSecurityGroupsResponse(map[string]string{
+			"sg-present": "apps/present", "sg-deleted": "apps/deleted",
+		}),
+		"DeleteSecurityGroup": `<DeleteSecurityGroupResponse><return>true</return></DeleteSecurityGroupResponse>`,
+	}
+	tests := []struct {
+		name       string
+		dryRun     bool
+		errors     map[string]string
+		wantDelete []string
+		wantErr    bool
+	}{
+		{name: "deleted Service", wantDelete: []string{"sg-deleted"}},
+		{name: "dry run", dryRun: true, wantDelete: []string{}},
+		{
+			name:       "security group in use",
+			errors:     map[string]string{"DeleteSecurityGroup": "DependencyViolation"},
+			wantDelete: []string{"sg-deleted"},
+		},
+		{
+			name:       "security groups not listed",
+			errors:     map[string]string{"DescribeSecurityGroups": "UnauthorizedOperation"},
+			wantDelete: []string{},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			awsClient, stub := newAWSStub(t, responses, tt.errors)
+			c := &SecurityGroupCleaner{ServiceReconciler: &ServiceReconciler{
+				Client: fake.NewClientBuilder().WithObjects(&corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "present"},
+				}).Build(),
+				Log:        ctrl.Log,
+				AWSClient:  awsClient,
+				DryRun:     tt.dryRun,
+				Settings:   config.NewStore(config.Settings{}),
+				InstanceID: "instance",
+			}}
+
+			if err := c.Clean(context.Background()); (err != nil) != tt.wantErr {
+				t.Fatalf("Clean() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			deleted := []string{}
+			for _, params := range stub.called("DeleteSecurityGroup") {
+				deleted = append(deleted, params.Get("GroupId"))
+			}
+			if !reflect.DeepEqual(deleted, tt.wantDelete) {
+				t.Errorf("Clean() deleted = %v, want %v", deleted, tt.wantDelete)
+			}
+		})
+	}
+}
+
+func Test_ServiceReconciler_releaseOwnership_managedSecurityGroup(t *testing.T) {
+	responses := map[string]string{
+		"DescribeSecurityGroups": managedSecurityGroupsResponse(map[string]string{"sg-managed": "apps/svc"}),
+		"DeleteSecurityGroup":    `<DeleteSecurityGroupResponse><return>true</return></DeleteSecurityGroupResponse>`,
+	}
+	tests := []struct {
+		name      string
+		errors    map[string]string
+		wantErr   bool
+		wantOwned bool
+	}{
+		{name: "deleted"},
+		{
+			name:      "still in use",
+			errors:    map[string]string{"DeleteSecurityGroup": "DependencyViolation"},
+			wantErr:   true,
+			wantOwned: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			awsClient, stub := newAWSStub(t, responses, tt.errors)
+			r := &ServiceReconciler{
+				Log:                  ctrl.Log,
+				Recorder:             record.NewFakeRecorder(10),
+				AWSClient:            awsClient,
+				AnnotationPrefix:     config.DefaultAnnotationPrefix,
+				InstanceID:           "instance",
+				OnAnnotationsRemoved: ReleaseOnAnnotationsRemoved,
+			}
+			svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "apps",
+				Name:        "svc",
+				Annotations: map[string]string{r.statusAnnotationKey(annotationOriginalAttributesKey): "{}"},
+			}}
+			r.Client = fake.NewClientBuilder().WithObjects(svc).Build()
+			nlb := &aws.NetworkLoadBalancer{
+				ARN:            "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/lb/1",
+				VpcID:          "vpc-1",
+				SecurityGroups: []string{"sg-user", "sg-managed"},
+			}
+
+			_, err := r.releaseOwnership(context.Background(), svc, nlb, config.Settings{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("releaseOwnership() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := stub.called("SetSecurityGroups"); len(got) != 1 || got[0].Get("SecurityGroups.member.1") != "sg-user" {
+				t.Errorf("releaseOwnership() set the security groups %v, want [sg-user]", got)
+			}
+			current := &corev1.Service{}
+			if err := r.Get(context.Background(), client.ObjectKeyFromObject(svc), current); err != nil {
+				t.Fatal(err)
+			}
+			if owned := r.isOwned(current.GetAnnotations()); owned != tt.wantOwned {
+				t.Errorf("releaseOwnership() kept the ownership = %t, want %t", owned, tt.wantOwned)
+			}
+		})
+	}
+}
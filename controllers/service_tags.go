@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	corev1 "k8s.io/api/core/v1"
+)
+
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// parseResourceTags parses a comma separated list of `key=value` tags, as
+// used by the `service.beta.kubernetes.io/aws-load-balancer-additional-resource-tags`
+// annotation.
+func parseResourceTags(value string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, tag := range strings.Split(value, ",") {
+		if strings.TrimSpace(tag) == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", strings.TrimSpace(tag))
+		}
+		tags[key] = strings.TrimSpace(kv[1])
+	}
+	return tags, nil
+}
+
+// getResourceTags returns the user defined tags for the Service load
+// balancer: the labels of the Service namespace listed in
+// InheritNamespaceLabels, overridden by the tags defined in the Service
+// annotation.
+func (r *ServiceReconciler) getResourceTags(
+	svc *corev1.Service, ns *corev1.Namespace) (map[string]string, error) {
+
+	tags := map[string]string{}
+
+	for _, label := range r.InheritNamespaceLabels {
+		if value, ok := ns.GetLabels()[label]; ok {
+			tags[label] = value
+		}
+	}
+
+	annotationTags, err := parseResourceTags(r.annotation(svc, annotationResourceTagsKey))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s annotation: %w", r.annotationKey(annotationResourceTagsKey), err)
+	}
+	for key, value := range annotationTags {
+		tags[key] = value
+	}
+
+	return tags, nil
+}
+
+// formatTagChanges returns a human readable list of tag changes
+func formatTagChanges(changes []aws.TagChange) string {
+	formatted := make([]string, 0, len(changes))
+	for _, change := range changes {
+		formatted = append(formatted, change.String())
+	}
+	return fmt.Sprintf("[%s]", strings.Join(formatted, ", "))
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseBool parses a boolean annotation value. Besides the values accepted by
+// strconv.ParseBool, it accepts `enabled`/`disabled` and `on`/`off`, in any
+// case.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "enabled", "on":
+		return true, nil
+	case "disabled", "off":
+		return false, nil
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q, use true/false, enabled/disabled or on/off", value)
+	}
+	return b, nil
+}
+
+// parseSeconds parses a time based annotation value, either a number of
+// seconds or a duration like `90s` or `5m`, and checks it is a whole number of
+// seconds within the [min, max] range.
+func parseSeconds(value string, min, max int) (int, error) {
+	value = strings.TrimSpace(value)
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		d, derr := time.ParseDuration(value)
+		if derr != nil {
+			return 0, fmt.Errorf("invalid duration %q, use a number of seconds or a duration like 90s or 5m", value)
+		}
+		if d%time.Second != 0 {
+			return 0, fmt.Errorf("invalid duration %q, must be a whole number of seconds", value)
+		}
+		seconds = int(d / time.Second)
+	}
+
+	if seconds < min || seconds > max {
+		return 0, fmt.Errorf("duration %q out of range, must be between %ds and %ds", value, min, max)
+	}
+	return seconds, nil
+}
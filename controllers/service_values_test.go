@@ -0,0 +1,58 @@
+package controllers
+
+import "testing"
+
+func Test_parseBool(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{value: "true", want: true},
+		{value: "False", want: false},
+		{value: "Enabled", want: true},
+		{value: "disabled", want: false},
+		{value: " on ", want: true},
+		{value: "off", want: false},
+		{value: "yes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseBool(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBool() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseBool() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseSeconds(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "300", want: 300},
+		{value: "90s", want: 90},
+		{value: "5m", want: 300},
+		{value: "1h", want: 3600},
+		{value: "1.5s", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "2h", wantErr: true},
+		{value: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseSeconds(tt.value, 0, 3600)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSeconds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSeconds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// targetHealthPendingInterval is the interval between target health checks
+// while a pod readiness gate is pending
+const targetHealthPendingInterval = 10 * time.Second
+
+// TargetHealthReconciler reconciles the target health of the load balancers
+// of the managed Services, driving the readiness gates of their pods. It
+// shares the settings and scope of the ServiceReconciler.
+type TargetHealthReconciler struct {
+	*ServiceReconciler
+}
+
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;patch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+
+func (r *TargetHealthReconciler) Reconcile(
+	ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+
+	rLogger := r.Log.WithName("targethealth").WithValues("Namespace", req.Namespace, "Service", req.Name)
+
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		if errors.IsNotFound(err) {
+			metrics.DeleteTargetHealth(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: req.Namespace}, ns); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	settings := r.Settings.Get()
+	if !r.inScope(svc, ns, settings) || !r.hasHelperAnnotation(svc.GetAnnotations()) {
+		return ctrl.Result{}, r.clearTargetHealth(ctx, svc)
+	}
+	if svc.GetAnnotations()[awsELBTypeAnnotationKey] != awsELBTypeNLBAnnotationValue ||
+		len(svc.Status.LoadBalancer.Ingress) < 1 {
+		return ctrl.Result{}, nil
+	}
+
+	nlb, err := r.getLoadBalancer(svc)
+	if err != nil {
+		rLogger.Error(err, "unable to find the load balancer")
+		return ctrl.Result{RequeueAfter: settings.ResyncInterval.Duration}, nil
+	}
+	if _, conflict := nlb.ManagingInstance(r.InstanceID); conflict {
+		return ctrl.Result{}, r.clearTargetHealth(ctx, svc)
+	}
+
+	health := map[string][]aws.TargetHealth{}
+	for _, tg := range nlb.TargetGroups {
+		targets, err := r.AWSClient.GetTargetHealth(tg.ARN)
+		if err != nil {
+			rLogger.Error(err, "unable to get the target group health", "TargetGroupARN", tg.ARN)
+			return ctrl.Result{RequeueAfter: settings.ResyncInterval.Duration}, nil
+		}
+		health[tg.ARN] = targets
+	}
+
+	if err := r.reportTargetHealth(ctx, svc, nlb, health); err != nil {
+		rLogger.Error(err, "unable to report the target health")
+	}
+
+	pending, err := r.reconcileReadinessGates(ctx, svc, nlb, health)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pending {
+		rLogger.V(1).Info("Pod readiness gates pending, polling the target health",
+			"interval", targetHealthPendingInterval,
+		)
+		return ctrl.Result{RequeueAfter: targetHealthPendingInterval}, nil
+	}
+	return ctrl.Result{RequeueAfter: settings.ResyncInterval.Duration}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. Besides the
+// Services, the pods with a target health readiness gate are watched, so
+// their gates are reconciled as soon as they are created.
+func (r *TargetHealthReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("targethealth").
+		For(&corev1.Service{}, builder.WithPredicates(r.filterAnnotatedServices())).
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(r.podServices),
+			builder.WithPredicates(predicate.NewPredicateFuncs(r.hasPendingReadinessGate)),
+		).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.Concurrency}).
+		Complete(r)
+}
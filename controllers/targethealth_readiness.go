@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// readinessGatePrefix is prepended to the annotation prefix to build the
+	// pod readiness gate condition types, like
+	// `target-health.aws-nlb-helper.3scale.net/<service>`
+	readinessGatePrefix = "target-health."
+
+	readinessGateReasonHealthy       = "TargetHealthy"
+	readinessGateReasonNotRegistered = "TargetNotRegistered"
+	readinessGateReasonUnhealthy     = "TargetNotHealthy"
+	readinessGateReasonRegistered    = "TargetRegistered"
+)
+
+// readinessGatesPrefix returns the prefix of the readiness gate condition
+// types handled by the helper
+func (r *ServiceReconciler) readinessGatesPrefix() string {
+	return readinessGatePrefix + r.AnnotationPrefix + "/"
+}
+
+// readinessGateType returns the pod readiness gate condition type driven by
+// the target health of the Service load balancer
+func (r *ServiceReconciler) readinessGateType(svc *corev1.Service) corev1.PodConditionType {
+	return corev1.PodConditionType(r.readinessGatesPrefix() + svc.GetName())
+}
+
+// gatedServices returns the names of the Services gating the pod readiness,
+// whose readiness gate condition is not true yet
+func (r *ServiceReconciler) gatedServices(pod *corev1.Pod) []string {
+	services := []string{}
+	for _, gate := range pod.Spec.ReadinessGates {
+		if !strings.HasPrefix(string(gate.ConditionType), r.readinessGatesPrefix()) {
+			continue
+		}
+		if podConditionTrue(pod, gate.ConditionType) {
+			continue
+		}
+		services = append(services, strings.TrimPrefix(string(gate.ConditionType), r.readinessGatesPrefix()))
+	}
+	return services
+}
+
+// hasPendingReadinessGate returns true if the object is a pod with a pending
+// helper readiness gate
+func (r *ServiceReconciler) hasPendingReadinessGate(obj client.Object) bool {
+	pod, ok := obj.(*corev1.Pod)
+	return ok && len(r.gatedServices(pod)) > 0
+}
+
+// podServices maps a pod to the Services gating its readiness
+func (r *ServiceReconciler) podServices(obj client.Object) []reconcile.Request {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, name := range r.gatedServices(pod) {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: pod.GetNamespace(), Name: name},
+		})
+	}
+	return requests
+}
+
+// reconcileReadinessGates sets the readiness gate condition of the Service
+// pods whose targets, the pod IP in the ip target groups and the instance of
+// the pod node in the instance ones, are healthy in all the target groups of
+// the load balancer. Once true, the condition is left as it is, the readiness
+// gates only hold the pods until the load balancer sends them traffic. It
+// returns true if some readiness gates are pending.
+func (r *ServiceReconciler) reconcileReadinessGates(ctx context.Context, svc *corev1.Service,
+	nlb *aws.NetworkLoadBalancer, health map[string][]aws.TargetHealth) (bool, error) {
+
+	if len(svc.Spec.Selector) == 0 {
+		return false, nil
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(svc.GetNamespace()),
+		client.MatchingLabelsSelector{Selector: labels.SelectorFromSet(svc.Spec.Selector)},
+	); err != nil {
+		return false, err
+	}
+
+	gate := r.readinessGateType(svc)
+	local := svc.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal
+	instances := map[string]string{}
+	pending := false
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !hasReadinessGate(pod, gate) || podConditionTrue(pod, gate) || pod.GetDeletionTimestamp() != nil {
+			continue
+		}
+		pending = true
+		if pod.Status.PodIP == "" || pod.Spec.NodeName == "" {
+			continue
+		}
+		instanceID, found := instances[pod.Spec.NodeName]
+		if !found {
+			node := &corev1.Node{}
+			if err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+				if !errors.IsNotFound(err) {
+					return pending, err
+				}
+			}
+			instanceID = instanceIDFromProviderID(node.Spec.ProviderID)
+			instances[pod.Spec.NodeName] = instanceID
+		}
+
+		status, reason, message := podTargetHealth(nlb, health, pod.Status.PodIP, instanceID, local)
+		if err := r.setPodCondition(ctx, pod, gate, status, reason, message); err != nil {
+			return pending, err
+		}
+		if status == corev1.ConditionTrue {
+			r.Log.Info("Pod target healthy, readiness gate set",
+				"Namespace", pod.GetNamespace(), "Pod", pod.GetName(), "gate", gate,
+			)
+		}
+	}
+	return pending, nil
+}
+
+// podTargetHealth returns the readiness gate status of a pod, given its IP
+// and the instance ID of its node, with the reason and message explaining it.
+// The pod IP must be healthy in the ip target groups. In the instance target
+// groups the node instance must be healthy with the Cluster external traffic
+// policy, the traffic reaching the pod through any node. With the Local one,
+// the node is only healthy once it has a ready pod, so the first pod of each
+// node would never become ready: the node instance only has to be registered.
+func podTargetHealth(nlb *aws.NetworkLoadBalancer, health map[string][]aws.TargetHealth,
+	podIP, instanceID string, local bool) (corev1.ConditionStatus, string, string) {
+
+	if len(nlb.TargetGroups) == 0 {
+		return corev1.ConditionFalse, readinessGateReasonNotRegistered, "The load balancer has no target group"
+	}
+
+	registeredOnly := false
+	for _, tg := range nlb.TargetGroups {
+		targetID := podIP
+		if tg.TargetType == aws.TargetTypeInstance {
+			if instanceID == "" {
+				return corev1.ConditionFalse, readinessGateReasonNotRegistered,
+					fmt.Sprintf("The instance of the pod node is unknown, it can't be found in %s", tg.ARN)
+			}
+			targetID = instanceID
+		}
+
+		var target *aws.TargetHealth
+		for i := range health[tg.ARN] {
+			if health[tg.ARN][i].ID == targetID {
+				target = &health[tg.ARN][i]
+				break
+			}
+		}
+		if target == nil {
+			return corev1.ConditionFalse, readinessGateReasonNotRegistered,
+				fmt.Sprintf("Target %q not registered in %s", targetID, tg.ARN)
+		}
+		if tg.TargetType == aws.TargetTypeInstance && local {
+			if target.State == aws.TargetHealthDraining {
+				return corev1.ConditionFalse, readinessGateReasonNotRegistered,
+					fmt.Sprintf("Target %q is %s in %s", targetID, target.State, tg.ARN)
+			}
+			registeredOnly = true
+			continue
+		}
+		if !target.IsHealthy() {
+			return corev1.ConditionFalse, readinessGateReasonUnhealthy,
+				fmt.Sprintf("Target %q is %s in %s: %s", targetID, target.State, tg.ARN, target.Reason)
+		}
+	}
+	if registeredOnly {
+		return corev1.ConditionTrue, readinessGateReasonRegistered,
+			"Node target registered in the instance target groups, whose health needs a ready pod " +
+				"on the node with the Local external traffic policy"
+	}
+	return corev1.ConditionTrue, readinessGateReasonHealthy, "Target healthy in all the load balancer target groups"
+}
+
+// setPodCondition sets a pod status condition, patching the pod status only
+// if it changed
+func (r *ServiceReconciler) setPodCondition(ctx context.Context, pod *corev1.Pod,
+	conditionType corev1.PodConditionType, status corev1.ConditionStatus, reason, message string) error {
+
+	original := pod.DeepCopy()
+	condition := corev1.PodCondition{
+		Type: conditionType, Status: status, Reason: reason, Message: message,
+		LastTransitionTime: metav1.Now(),
+	}
+
+	found := false
+	for i, c := range pod.Status.Conditions {
+		if c.Type != conditionType {
+			continue
+		}
+		found = true
+		if c.Status == status && c.Reason == reason && c.Message == message {
+			return nil
+		}
+		if c.Status == status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		pod.Status.Conditions[i] = condition
+	}
+	if !found {
+		pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	}
+	// the conditions are merged by type, not to race with the kubelet
+	return r.Status().Patch(ctx, pod, client.StrategicMergeFrom(original))
+}
+
+// hasReadinessGate returns true if the pod declares the readiness gate
+func hasReadinessGate(pod *corev1.Pod, conditionType corev1.PodConditionType) bool {
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
+// podConditionTrue returns true if the pod condition is set to true
+func podConditionTrue(pod *corev1.Pod, conditionType corev1.PodConditionType) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == conditionType {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// instanceIDFromProviderID returns the EC2 instance ID of a node provider ID,
+// like `aws:///us-east-1a/i-0123456789abcdef0`
+func instanceIDFromProviderID(providerID string) string {
+	if !strings.HasPrefix(providerID, "aws://") {
+		return ""
+	}
+	id := providerID[strings.LastIndex(providerID, "/")+1:]
+	if !strings.HasPrefix(id, "i-") {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_podTargetHealth(t *testing.T) {
+	mixed := &aws.NetworkLoadBalancer{TargetGroups: []aws.TargetGroup{
+		{ARN: "ip", TargetType: aws.TargetTypeIP},
+		{ARN: "instance", TargetType: aws.TargetTypeInstance},
+	}}
+	// the in-tree provider NLBs, with instance targets whose health, with the
+	// Local external traffic policy, waits for a ready pod on the node
+	instance := &aws.NetworkLoadBalancer{TargetGroups: []aws.TargetGroup{
+		{ARN: "instance", TargetType: aws.TargetTypeInstance},
+	}}
+
+	tests := []struct {
+		name       string
+		nlb        *aws.NetworkLoadBalancer
+		health     map[string][]aws.TargetHealth
+		instanceID string
+		local      bool
+		wantStatus corev1.ConditionStatus
+		wantReason string
+	}{
+		{
+			name: "healthy",
+			nlb:  mixed,
+			health: map[string][]aws.TargetHealth{
+				"ip":       {{ID: "10.0.0.1", State: "healthy"}},
+				"instance": {{ID: "i-1", State: "healthy"}},
+			},
+			instanceID: "i-1",
+			wantStatus: corev1.ConditionTrue,
+			wantReason: readinessGateReasonHealthy,
+		},
+		{
+			name: "initial",
+			nlb:  mixed,
+			health: map[string][]aws.TargetHealth{
+				"ip":       {{ID: "10.0.0.1", State: "initial"}},
+				"instance": {{ID: "i-1", State: "healthy"}},
+			},
+			instanceID: "i-1",
+			wantStatus: corev1.ConditionFalse,
+			wantReason: readinessGateReasonUnhealthy,
+		},
+		{
+			name: "not registered",
+			nlb:  mixed,
+			health: map[string][]aws.TargetHealth{
+				"ip":       {{ID: "10.0.0.2", State: "healthy"}},
+				"instance": {{ID: "i-1", State: "healthy"}},
+			},
+			instanceID: "i-1",
+			wantStatus: corev1.ConditionFalse,
+			wantReason: readinessGateReasonNotRegistered,
+		},
+		{
+			name: "instance targets with the Cluster policy",
+			nlb:  instance,
+			health: map[string][]aws.TargetHealth{
+				"instance": {{ID: "i-1", State: "initial"}, {ID: "i-2", State: "healthy"}},
+			},
+			instanceID: "i-1",
+			wantStatus: corev1.ConditionFalse,
+			wantReason: readinessGateReasonUnhealthy,
+		},
+		{
+			name: "healthy instance target with the Cluster policy",
+			nlb:  instance,
+			health: map[string][]aws.TargetHealth{
+				"instance": {{ID: "i-1", State: "healthy"}},
+			},
+			instanceID: "i-1",
+			wantStatus: corev1.ConditionTrue,
+			wantReason: readinessGateReasonHealthy,
+		},
+		{
+			name: "instance targets with the Local policy",
+			nlb:  instance,
+			health: map[string][]aws.TargetHealth{
+				// no ready local endpoint yet, the node health check fails
+				"instance": {{ID: "i-1", State: "unhealthy"}},
+			},
+			instanceID: "i-1",
+			local:      true,
+			wantStatus: corev1.ConditionTrue,
+			wantReason: readinessGateReasonRegistered,
+		},
+		{
+			name: "draining instance target with the Local policy",
+			nlb:  instance,
+			health: map[string][]aws.TargetHealth{
+				"instance": {{ID: "i-1", State: "draining"}},
+			},
+			instanceID: "i-1",
+			local:      true,
+			wantStatus: corev1.ConditionFalse,
+			wantReason: readinessGateReasonNotRegistered,
+		},
+		{
+			name: "unknown node instance",
+			nlb:  instance,
+			health: map[string][]aws.TargetHealth{
+				"instance": {{ID: "i-1", State: "healthy"}},
+			},
+			wantStatus: corev1.ConditionFalse,
+			wantReason: readinessGateReasonNotRegistered,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reason, _ := podTargetHealth(tt.nlb, tt.health, "10.0.0.1", tt.instanceID, tt.local)
+			if status != tt.wantStatus || reason != tt.wantReason {
+				t.Errorf("podTargetHealth() = %v, %v, want %v, %v", status, reason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_instanceIDFromProviderID(t *testing.T) {
+	tests := map[string]string{
+		"aws:///us-east-1a/i-0123456789abcdef0": "i-0123456789abcdef0",
+		"aws:///us-east-1a/fargate-10.0.0.1":    "",
+		"gce://project/zone/node":               "",
+		"":                                      "",
+	}
+	for providerID, want := range tests {
+		if got := instanceIDFromProviderID(providerID); got != want {
+			t.Errorf("instanceIDFromProviderID(%q) = %v, want %v", providerID, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// servicePort returns the Service port served by a target group, the Service
+// port whose node port, for the instance targets, or target port, for the ip
+// targets, is the target group port. It defaults to the target group port.
+func servicePort(svc *corev1.Service, tg aws.TargetGroup) string {
+	for _, port := range svc.Spec.Ports {
+		if tg.TargetType == aws.TargetTypeInstance && int64(port.NodePort) == tg.Port {
+			return strconv.Itoa(int(port.Port))
+		}
+		if tg.TargetType == aws.TargetTypeIP && int64(port.TargetPort.IntValue()) == tg.Port {
+			return strconv.Itoa(int(port.Port))
+		}
+	}
+	return strconv.FormatInt(tg.Port, 10)
+}
+
+// summarizeTargetHealth returns the target health summaries of the load
+// balancer target groups, by Service port
+func summarizeTargetHealth(svc *corev1.Service, nlb *aws.NetworkLoadBalancer,
+	health map[string][]aws.TargetHealth) map[string]*aws.TargetHealthSummary {
+
+	summaries := map[string]*aws.TargetHealthSummary{}
+	for _, tg := range nlb.TargetGroups {
+		port := servicePort(svc, tg)
+		if _, ok := summaries[port]; !ok {
+			summaries[port] = &aws.TargetHealthSummary{}
+		}
+		summaries[port].Add(health[tg.ARN])
+	}
+	return summaries
+}
+
+// formatTargetHealth returns a human readable list of the target health
+// summaries, like `80: healthy=2 unhealthy=0 ...; 443: healthy=2 ...`,
+// sorted by port, and the ports with no healthy target
+func formatTargetHealth(summaries map[string]*aws.TargetHealthSummary) (string, []string) {
+	ports := make([]string, 0, len(summaries))
+	for port := range summaries {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		a, _ := strconv.Atoi(ports[i])
+		b, _ := strconv.Atoi(ports[j])
+		return a < b
+	})
+
+	formatted := make([]string, 0, len(ports))
+	unhealthy := []string{}
+	for _, port := range ports {
+		formatted = append(formatted, fmt.Sprintf("%s: %s", port, summaries[port]))
+		if summaries[port].Healthy == 0 {
+			unhealthy = append(unhealthy, port)
+		}
+	}
+	return strings.Join(formatted, "; "), unhealthy
+}
+
+// reportTargetHealth reports the target health of the load balancer target
+// groups with the Targets metric, the target health status annotation and the
+// TargetsHealthy condition. A Warning event is emitted when a port has no
+// healthy target anymore.
+func (r *ServiceReconciler) reportTargetHealth(ctx context.Context, svc *corev1.Service,
+	nlb *aws.NetworkLoadBalancer, health map[string][]aws.TargetHealth) error {
+
+	summaries := summarizeTargetHealth(svc, nlb, health)
+	counts := map[string]map[string]int{}
+	for port, summary := range summaries {
+		counts[port] = summary.Counts()
+	}
+	metrics.SetTargetHealth(types.NamespacedName{Namespace: svc.GetNamespace(), Name: svc.GetName()}, counts)
+
+	formatted, unhealthy := formatTargetHealth(summaries)
+	condition := metav1.Condition{
+		Type:               r.conditionType(conditionTargetsHealthy),
+		Status:             metav1.ConditionTrue,
+		Reason:             conditionReasonHealthyTargets,
+		Message:            "All the Service ports have healthy targets",
+		ObservedGeneration: svc.GetGeneration(),
+	}
+	if len(unhealthy) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = conditionReasonNoHealthyTargets
+		condition.Message = fmt.Sprintf("No healthy target for the ports %s", strings.Join(unhealthy, ", "))
+
+		previous := meta.FindStatusCondition(svc.Status.Conditions, condition.Type)
+		if previous == nil || previous.Status != condition.Status || previous.Message != condition.Message {
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, eventReasonNoHealthyTargets,
+				"No healthy load balancer target for the ports %s: %s", strings.Join(unhealthy, ", "), formatted,
+			)
+		}
+	}
+
+	if err := r.patchConditions(ctx, svc, func(conditions *[]metav1.Condition) {
+		meta.SetStatusCondition(conditions, condition)
+	}); err != nil {
+		return err
+	}
+	return r.setStatusAnnotation(ctx, svc, annotationTargetHealthKey, formatted)
+}
+
+// clearTargetHealth removes the target health report of a Service no longer
+// managed by the helper
+func (r *ServiceReconciler) clearTargetHealth(ctx context.Context, svc *corev1.Service) error {
+	metrics.DeleteTargetHealth(types.NamespacedName{Namespace: svc.GetNamespace(), Name: svc.GetName()})
+	return r.removeStatusAnnotation(ctx, svc, annotationTargetHealthKey)
+}
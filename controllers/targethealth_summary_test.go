@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func Test_summarizeTargetHealth(t *testing.T) {
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+		{Port: 80, NodePort: 30080, TargetPort: intstr.FromInt(8080)},
+		{Port: 443, NodePort: 30443, TargetPort: intstr.FromInt(8443)},
+	}}}
+	nlb := &aws.NetworkLoadBalancer{TargetGroups: []aws.TargetGroup{
+		{ARN: "http", TargetType: aws.TargetTypeInstance, Port: 30080},
+		{ARN: "https", TargetType: aws.TargetTypeIP, Port: 8443},
+		{ARN: "other", TargetType: aws.TargetTypeIP, Port: 9000},
+	}}
+	health := map[string][]aws.TargetHealth{
+		"http": {
+			{ID: "i-1", State: "healthy"},
+			{ID: "i-2", State: "unhealthy", Reason: "Target.FailedHealthChecks"},
+		},
+		"https": {
+			{ID: "10.0.0.1", State: "draining", Reason: "Target.DeregistrationInProgress"},
+		},
+	}
+
+	formatted, unhealthy := formatTargetHealth(summarizeTargetHealth(svc, nlb, health))
+	wantFormatted := "80: healthy=1 unhealthy=1 initial=0 draining=0 unused=0 (Target.FailedHealthChecks=1); " +
+		"443: healthy=0 unhealthy=0 initial=0 draining=1 unused=0 (Target.DeregistrationInProgress=1); " +
+		"9000: healthy=0 unhealthy=0 initial=0 draining=0 unused=0"
+	if formatted != wantFormatted {
+		t.Errorf("formatTargetHealth() = %v, want %v", formatted, wantFormatted)
+	}
+	if want := []string{"443", "9000"}; !reflect.DeepEqual(unhealthy, want) {
+		t.Errorf("formatTargetHealth() unhealthy = %v, want %v", unhealthy, want)
+	}
+}
@@ -17,24 +17,33 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	goruntime "runtime"
 	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 
 	"github.com/3scale-ops/aws-nlb-helper-operator/controllers"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/aws"
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/config"
 	util "github.com/3scale-ops/aws-nlb-helper-operator/pkg/utils"
 	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/version"
 	//+kubebuilder:scaffold:imports
@@ -45,6 +54,15 @@ const (
 	// which specifies the Namespace to watch.
 	// An empty value means the operator is running with cluster scope.
 	watchNamespaceEnvVar string = "WATCH_NAMESPACE"
+	// podNamespaceEnvVar is the constant for env variable POD_NAMESPACE
+	// which specifies the Namespace the operator is running in.
+	podNamespaceEnvVar string = "POD_NAMESPACE"
+	// orphansCommand is the subcommand running a one-shot orphaned load
+	// balancers scan
+	orphansCommand string = "orphans"
+	// iamPolicyCommand is the subcommand printing the minimal IAM policy
+	// needed by the enabled features
+	iamPolicyCommand string = "iam-policy"
 )
 
 var (
@@ -59,30 +77,160 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == orphansCommand {
+		os.Exit(runOrphansCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == iamPolicyCommand {
+		os.Exit(runIAMPolicyCommand(os.Args[2:]))
+	}
+
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var dryRun bool
+	var strict bool
+	var onAnnotationsRemoved string
+	var instanceID string
+	var annotationPrefix string
+	var leaderElectionID string
+	var clusterID string
+	var orphansScanInterval time.Duration
+	var orphansRemoveDeletionProtection bool
+	var inheritNamespaceLabels string
+	var protectedTagPrefixes string
+	var awsCheckInterval time.Duration
+	var iamPreflight bool
+	var deregisterDrainingNodes bool
+	var certificatesCleanupInterval time.Duration
+	var securityGroupsCleanupInterval time.Duration
+	flag.StringVar(&configFile, "config", "",
+		"The operator config file. The flags explicitly set take precedence over the config file settings.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Plan the load balancer changes without applying them. "+
+			"The planned changes are logged and reported as events and metrics.")
+	flag.BoolVar(&strict, "strict", false,
+		"Refuse to apply any change to the load balancer of a Service with an invalid annotation, "+
+			"instead of ignoring the invalid values.")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "",
+		"The name of the leader election lock, derived from the annotation prefix if unset.")
+	flag.StringVar(&instanceID, "instance-id", "default",
+		"The identifier of this operator instance, set in the ownership tags of the managed load balancers. "+
+			"It defaults to the annotation prefix if it is not the default one.")
+	flag.StringVar(&annotationPrefix, "annotation-prefix", config.DefaultAnnotationPrefix,
+		"The prefix of the annotations handled by this operator instance.")
+	flag.StringVar(&onAnnotationsRemoved, "on-annotations-removed", controllers.RestoreOnAnnotationsRemoved,
+		"What to do with the load balancer attributes once all the helper annotations are removed from a Service. "+
+			"Either \"restore\" the original attributes or \"release\" them as they are.")
+	flag.StringVar(&clusterID, "cluster-id", "",
+		"The cluster ID set in the kubernetes.io/cluster/<cluster-id> tag of the cluster load balancers. "+
+			"The orphaned load balancers scans need it.")
+	flag.DurationVar(&orphansScanInterval, "orphans-scan-interval", time.Hour,
+		"The interval between orphaned load balancers scans. A zero value disables the scans.")
+	flag.BoolVar(&orphansRemoveDeletionProtection, "orphans-remove-deletion-protection", false,
+		"Disable the deletion protection of the orphaned load balancers.")
+	flag.StringVar(&inheritNamespaceLabels, "inherit-namespace-labels", "",
+		"Comma separated list of Service namespace labels to set as tags on the load balancers.")
+	flag.StringVar(&protectedTagPrefixes, "protected-tag-prefixes", "kubernetes.io/",
+		"Comma separated list of tag prefixes that must never be modified by the operator.")
+	flag.DurationVar(&awsCheckInterval, "aws-check-interval", time.Minute,
+		"The interval between AWS connectivity checks reported by the readiness probe.")
+	flag.BoolVar(&iamPreflight, "iam-preflight", false,
+		"Check the IAM permissions needed by the enabled features at startup using iam:SimulatePrincipalPolicy.")
+	flag.BoolVar(&deregisterDrainingNodes, "deregister-draining-nodes", false,
+		"Deregister the cordoned, terminating or excluded nodes from the instance target groups "+
+			"of the managed load balancers.")
+	flag.DurationVar(&certificatesCleanupInterval, "certificates-cleanup-interval", 10*time.Minute,
+		"The interval between deletions of the ACM certificates imported from TLS Secrets no longer referenced. "+
+			"A zero value disables the deletions.")
+	flag.DurationVar(&securityGroupsCleanupInterval, "security-groups-cleanup-interval", 10*time.Minute,
+		"The interval between deletions of the managed security groups whose Service no longer exists. "+
+			"A zero value disables the deletions.")
 	flag.Parse()
 
 	ctrl.SetLogger((util.Logger{}).New())
 
 	printVersion()
 
-	mgrOpts := ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "804187e3.aws-nlb-helper.3scale.net",
+	if onAnnotationsRemoved != controllers.RestoreOnAnnotationsRemoved &&
+		onAnnotationsRemoved != controllers.ReleaseOnAnnotationsRemoved {
+		setupLog.Error(
+			fmt.Errorf("invalid value %q", onAnnotationsRemoved),
+			"unable to parse the on-annotations-removed flag",
+		)
+		os.Exit(1)
+	}
+
+	opCfg, err := loadConfig(configFile)
+	if err != nil {
+		setupLog.Error(err, "unable to load the config file", "path", configFile)
+		os.Exit(1)
+	}
+	flagsSet := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { flagsSet[f.Name] = true })
+	if !flagsSet["cluster-id"] {
+		clusterID = opCfg.ClusterID
+	}
+	if orphansScanInterval > 0 && clusterID == "" {
+		setupLog.Info("No cluster ID set, the orphaned load balancers scans are disabled")
+		orphansScanInterval = 0
+	}
+	if flagsSet["annotation-prefix"] {
+		opCfg.AnnotationPrefix = annotationPrefix
+		if err := opCfg.Validate(); err != nil {
+			setupLog.Error(err, "invalid annotation-prefix flag")
+			os.Exit(1)
+		}
+	}
+	if !flagsSet["instance-id"] && opCfg.AnnotationPrefix != config.DefaultAnnotationPrefix {
+		instanceID = opCfg.AnnotationPrefix
+	}
+
+	// The flags explicitly set take precedence over the config file, the
+	// flag defaults are used for the settings missing in both
+	mgrOpts := ctrl.Options{Scheme: scheme}
+	if flagsSet["metrics-bind-address"] {
+		mgrOpts.MetricsBindAddress = metricsAddr
 	}
+	if flagsSet["health-probe-bind-address"] {
+		mgrOpts.HealthProbeBindAddress = probeAddr
+	}
+	mgrOpts.LeaderElection = enableLeaderElection
+	mgrOpts.LeaderElectionID = leaderElectionID
+	if mgrOpts, err = mgrOpts.AndFrom(opCfg); err != nil {
+		setupLog.Error(err, "unable to load the manager settings from the config file")
+		os.Exit(1)
+	}
+	if mgrOpts.MetricsBindAddress == "" {
+		mgrOpts.MetricsBindAddress = metricsAddr
+	}
+	if mgrOpts.HealthProbeBindAddress == "" {
+		mgrOpts.HealthProbeBindAddress = probeAddr
+	}
+	if mgrOpts.Port == 0 {
+		mgrOpts.Port = 9443
+	}
+	if mgrOpts.LeaderElectionID == "" {
+		mgrOpts.LeaderElectionID = opCfg.LeaderElectionID()
+	}
+	setupLog.Info("Operator instance settings",
+		"annotationPrefix", opCfg.AnnotationPrefix, "instanceID", instanceID,
+		"leaderElectionID", mgrOpts.LeaderElectionID,
+	)
 
-	SetOperatorScope(&mgrOpts)
+	watchNamespaces := getWatchNamespaces(opCfg.Namespaces)
+	SetOperatorScope(&mgrOpts, watchNamespaces)
+	if opCfg.ServiceSelector != nil {
+		setupLog.Info("The manager will only watch the Services matching the selector",
+			"selector", opCfg.ServiceLabelSelector().String(),
+		)
+		mgrOpts.NewCache = withServiceSelector(mgrOpts.NewCache, opCfg.ServiceLabelSelector())
+	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), mgrOpts)
 	if err != nil {
@@ -90,24 +238,130 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.ServiceReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Service"),
-	}).SetupWithManager(mgr); err != nil {
+	awsClient, err := aws.NewAPIClient(opCfg.Region)
+	if err != nil {
+		setupLog.Error(err, "unable to initialize the AWS client")
+		os.Exit(1)
+	}
+
+	if iamPreflight {
+		checkPermissions(awsClient, aws.Features{
+			DryRun:                   dryRun || opCfg.DryRun,
+			RemoveDeletionProtection: orphansScanInterval > 0 && orphansRemoveDeletionProtection,
+			Preflight:                true,
+			DeregisterDrainingNodes:  deregisterDrainingNodes,
+		})
+	}
+
+	if dryRun || opCfg.DryRun {
+		setupLog.Info("The manager is running in dry run mode, load balancers won't be modified")
+	}
+
+	settings := config.NewStore(opCfg.Settings)
+	if configFile != "" {
+		if err := mgr.Add(config.NewWatcher(configFile, opCfg, settings, ctrl.Log.WithName("config"))); err != nil {
+			setupLog.Error(err, "unable to set up the config file watcher")
+			os.Exit(1)
+		}
+	}
+
+	serviceReconciler := &controllers.ServiceReconciler{
+		Client:     mgr.GetClient(),
+		APIReader:  mgr.GetAPIReader(),
+		Scheme:     mgr.GetScheme(),
+		Log:        ctrl.Log.WithName("controllers").WithName("Service"),
+		Recorder:   mgr.GetEventRecorderFor("aws-nlb-helper"),
+		AWSClient:  awsClient,
+		DryRun:     dryRun,
+		Strict:     strict,
+		InstanceID: instanceID,
+		Settings:   settings,
+
+		AnnotationPrefix: opCfg.AnnotationPrefix,
+		Concurrency:      opCfg.Concurrency,
+		ServiceSelector:  opCfg.ServiceLabelSelector(),
+
+		InheritNamespaceLabels: util.SplitList(inheritNamespaceLabels),
+		ProtectedTagPrefixes:   util.SplitList(protectedTagPrefixes),
+		OnAnnotationsRemoved:   onAnnotationsRemoved,
+	}
+	if err = serviceReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Service")
 		os.Exit(1)
 	}
+	if err = (&controllers.TargetHealthReconciler{
+		ServiceReconciler: serviceReconciler,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TargetHealth")
+		os.Exit(1)
+	}
+	if deregisterDrainingNodes {
+		if err = (&controllers.NodeDrainReconciler{
+			ServiceReconciler: serviceReconciler,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "NodeDrain")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
+	if certificatesCleanupInterval > 0 {
+		if err := mgr.Add(&controllers.CertificateCleaner{
+			ServiceReconciler: serviceReconciler,
+			Interval:          certificatesCleanupInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up the imported certificates cleanups")
+			os.Exit(1)
+		}
+	}
+
+	if securityGroupsCleanupInterval > 0 {
+		if err := mgr.Add(&controllers.SecurityGroupCleaner{
+			ServiceReconciler: serviceReconciler,
+			Interval:          securityGroupsCleanupInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up the managed security groups cleanups")
+			os.Exit(1)
+		}
+	}
+
+	if orphansScanInterval > 0 {
+		if err := mgr.Add(&controllers.OrphanReporter{
+			Reader:          mgr.GetAPIReader(),
+			Client:          mgr.GetClient(),
+			Recorder:        mgr.GetEventRecorderFor("aws-nlb-helper"),
+			AWSClient:       awsClient,
+			Log:             ctrl.Log.WithName("orphans"),
+			ClusterID:       clusterID,
+			Namespaces:      watchNamespaces,
+			ReportNamespace: os.Getenv(podNamespaceEnvVar),
+			Interval:        orphansScanInterval,
+
+			RemoveDeletionProtection:      orphansRemoveDeletionProtection && !(dryRun || opCfg.DryRun),
+			PlanDeletionProtectionRemoval: orphansRemoveDeletionProtection && (dryRun || opCfg.DryRun),
+		}); err != nil {
+			setupLog.Error(err, "unable to set up the orphaned load balancers scans")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
+	awsChecker := &aws.ReadinessChecker{AWSClient: awsClient, Interval: awsCheckInterval}
+	if err := mgr.Add(awsChecker); err != nil {
+		setupLog.Error(err, "unable to set up the AWS connectivity checks")
+		os.Exit(1)
+	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("aws", awsChecker.Check); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -116,35 +370,212 @@ func main() {
 	}
 }
 
-func SetOperatorScope(o *ctrl.Options) {
-
-	watchNamespace, found := os.LookupEnv(watchNamespaceEnvVar)
+func SetOperatorScope(o *ctrl.Options, watchNamespaces []string) {
 
-	if !found {
+	if len(watchNamespaces) == 0 {
 		setupLog.Info("The manager will watch services from all Namespaces")
 		return
 	}
 
-	if strings.Contains(watchNamespace, ",") {
+	if len(watchNamespaces) > 1 {
 		setupLog.Info(
 			fmt.Sprintf(
 				"Manager will be watching services from %q namespaces.",
-				watchNamespace,
+				strings.Join(watchNamespaces, ","),
 			),
 		)
-		o.NewCache = cache.MultiNamespacedCacheBuilder(strings.Split(watchNamespace, ","))
+		o.NewCache = cache.MultiNamespacedCacheBuilder(watchNamespaces)
 		return
 	}
 
 	setupLog.Info(fmt.Sprintf(
-		"Manager will be watching the namespace %q", watchNamespace))
-	o.Namespace = watchNamespace
+		"Manager will be watching the namespace %q", watchNamespaces[0]))
+	o.Namespace = watchNamespaces[0]
+
+}
 
+// withServiceSelector restricts the Services cached by the manager to the
+// ones matching the selector.
+func withServiceSelector(newCache cache.NewCacheFunc, selector labels.Selector) cache.NewCacheFunc {
+	if newCache == nil {
+		newCache = cache.New
+	}
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.SelectorsByObject = cache.SelectorsByObject{
+			&corev1.Service{}: {Label: selector},
+		}
+		return newCache(config, opts)
+	}
+}
+
+// getWatchNamespaces returns the Namespaces the operator should be watching
+// for changes, from the WATCH_NAMESPACE environment variable or else the
+// config file. An empty list means all the Namespaces.
+func getWatchNamespaces(configured []string) []string {
+	watchNamespace, found := os.LookupEnv(watchNamespaceEnvVar)
+	if !found {
+		return configured
+	}
+	if watchNamespace == "" {
+		return nil
+	}
+	return strings.Split(watchNamespace, ",")
+}
+
+// loadConfig loads the operator config file, or returns the built-in
+// defaults if no file is set.
+func loadConfig(path string) (*config.OperatorConfig, error) {
+	if path == "" {
+		return config.New(), nil
+	}
+	return config.Load(path)
 }
 
-// getWatchNamespace returns the Namespace the operator should be watching for changes
 func printVersion() {
 	setupLog.Info(fmt.Sprintf("AWS NLB Helper Operator Version: %s", version.Current()))
 	setupLog.Info(fmt.Sprintf("Go Version: %s", goruntime.Version()))
 	setupLog.Info(fmt.Sprintf("Go OS/Arch: %s/%s", goruntime.GOOS, goruntime.GOARCH))
 }
+
+// runOrphansCommand runs a one-shot orphaned load balancers scan, printing
+// the report to the standard output. It returns the process exit code.
+func runOrphansCommand(args []string) int {
+
+	fs := flag.NewFlagSet(orphansCommand, flag.ExitOnError)
+	configFile := fs.String("config", "",
+		"The path to the operator config file, providing the AWS region and the cluster ID.")
+	clusterID := fs.String("cluster-id", "",
+		"The cluster ID set in the kubernetes.io/cluster/<cluster-id> tag of the cluster load balancers, "+
+			"required unless set in the config file.")
+	removeDeletionProtection := fs.Bool("remove-deletion-protection", false,
+		"Disable the deletion protection of the orphaned load balancers.")
+	dryRun := fs.Bool("dry-run", false,
+		"Only report the deletion protection removals as planned, also enabled by the config file dryRun.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\n", os.Args[0], orphansCommand)
+		fmt.Fprintf(fs.Output(), "List the load balancers whose Service no longer exists.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctrl.SetLogger((util.Logger{}).New())
+	log := ctrl.Log.WithName(orphansCommand)
+
+	opCfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Error(err, "unable to load the config file", "path", *configFile)
+		return 1
+	}
+	if *clusterID == "" {
+		*clusterID = opCfg.ClusterID
+	}
+	if *clusterID == "" {
+		fmt.Fprintf(fs.Output(), "A cluster ID is required, with --cluster-id or the config file.\n\n")
+		fs.Usage()
+		return 2
+	}
+
+	k8sClient, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		log.Error(err, "unable to initialize the Kubernetes client")
+		return 1
+	}
+
+	awsClient, err := aws.NewAPIClient(opCfg.Region)
+	if err != nil {
+		log.Error(err, "unable to initialize the AWS client")
+		return 1
+	}
+
+	reporter := &controllers.OrphanReporter{
+		Reader:     k8sClient,
+		Client:     k8sClient,
+		AWSClient:  awsClient,
+		Log:        log,
+		ClusterID:  *clusterID,
+		Namespaces: getWatchNamespaces(nil),
+
+		RemoveDeletionProtection:      *removeDeletionProtection && !(*dryRun || opCfg.DryRun),
+		PlanDeletionProtectionRemoval: *removeDeletionProtection && (*dryRun || opCfg.DryRun),
+	}
+
+	report, err := reporter.Scan(context.Background())
+	if err != nil {
+		log.Error(err, "unable to scan for orphaned load balancers")
+		return 1
+	}
+
+	output, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Error(err, "unable to encode the orphaned load balancers report")
+		return 1
+	}
+	fmt.Println(string(output))
+
+	return 0
+}
+
+// checkPermissions warns about the IAM actions needed by the enabled features
+// that are not allowed to the AWS principal of the operator.
+func checkPermissions(awsClient *aws.APIClient, features aws.Features) {
+	missing, err := awsClient.MissingActions(aws.RequiredActions(features))
+	if err != nil {
+		setupLog.Error(err, "unable to check the IAM permissions")
+		return
+	}
+	if len(missing) > 0 {
+		setupLog.Error(fmt.Errorf("missing IAM permissions: %s", strings.Join(missing, ", ")),
+			"the operator won't be able to manage the load balancers, "+
+				"run the iam-policy subcommand to get the required policy",
+		)
+		return
+	}
+	setupLog.Info("IAM permissions preflight succeeded")
+}
+
+// runIAMPolicyCommand prints the minimal IAM policy needed by the enabled
+// features. It returns the process exit code.
+func runIAMPolicyCommand(args []string) int {
+
+	fs := flag.NewFlagSet(iamPolicyCommand, flag.ExitOnError)
+	configFile := fs.String("config", "",
+		"The operator config file, its dry run setting is taken into account.")
+	dryRun := fs.Bool("dry-run", false,
+		"The operator runs in dry run mode, no modify permission is needed.")
+	removeDeletionProtection := fs.Bool("orphans-remove-deletion-protection", false,
+		"The operator disables the deletion protection of the orphaned load balancers.")
+	preflight := fs.Bool("iam-preflight", false,
+		"The operator checks the IAM permissions at startup.")
+	deregisterDrainingNodes := fs.Bool("deregister-draining-nodes", false,
+		"The operator deregisters the draining nodes from the target groups.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\n", os.Args[0], iamPolicyCommand)
+		fmt.Fprintf(fs.Output(), "Print the minimal IAM policy needed by the enabled features.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	opCfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to load the config file: %v\n", err)
+		return 1
+	}
+
+	policy, err := aws.PolicyDocument(aws.RequiredActions(aws.Features{
+		DryRun:                   *dryRun || opCfg.DryRun,
+		RemoveDeletionProtection: *removeDeletionProtection,
+		Preflight:                *preflight,
+		DeregisterDrainingNodes:  *deregisterDrainingNodes,
+	}))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to generate the IAM policy: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(policy))
+
+	return 0
+}
@@ -0,0 +1,298 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const (
+	awsNetworkLoadBalancerStickness = "source_ip"
+
+	// Load balancer attribute keys
+	LoadBalancerDeletionProtectionKey = "deletion_protection.enabled"
+
+	// Target group attribute keys
+	TargetGroupStickinessEnabledKey      = "stickiness.enabled"
+	TargetGroupStickinessTypeKey         = "stickiness.type"
+	TargetGroupProxyProtocolKey          = "proxy_protocol_v2.enabled"
+	TargetGroupDeregistrationDelayKey    = "deregistration_delay.timeout_seconds"
+	loadBalancerResourceType             = "loadbalancer"
+	targetGroupResourceType              = "targetgroup"
+	attributeChangeFormat                = "%s %s: %s -> %s"
+	attributeChangeUnknownAttributeValue = "<unset>"
+
+	// MinTargetGroupDeregistrationDelay and MaxTargetGroupDeregistrationDelay
+	// are the AWS limits of the target group deregistration delay, in seconds
+	MinTargetGroupDeregistrationDelay = 0
+	MaxTargetGroupDeregistrationDelay = 3600
+)
+
+// NetworkLoadBalancerAttributes struct
+type NetworkLoadBalancerAttributes struct {
+	LoadBalancerTerminationProtection bool
+	TargetGroupDeregistrationDelay    int
+	TargetGroupStickness              bool
+	TargetGroupProxyProtocol          bool
+	// ListenerTCPIdleTimeouts are the idle timeouts of the TCP listeners,
+	// indexed by port, the listeners of the other ports being left as they are
+	ListenerTCPIdleTimeouts map[int64]int
+}
+
+// AttributeChange describes the change of a load balancer, target group or
+// listener attribute from its current value to the desired one.
+type AttributeChange struct {
+	ResourceARN  string
+	ResourceType string
+	Key          string
+	Current      string
+	Desired      string
+}
+
+// String returns a human readable representation of the change, like
+// `loadbalancer/net/name/id deletion_protection.enabled: false -> true`.
+func (c AttributeChange) String() string {
+	current := c.Current
+	if current == "" {
+		current = attributeChangeUnknownAttributeValue
+	}
+	return fmt.Sprintf(attributeChangeFormat,
+		resourceName(c.ResourceARN), c.Key, current, c.Desired,
+	)
+}
+
+// IsProxyProtocolChange returns true if the change enables or disables the
+// proxy protocol of a target group
+func (c AttributeChange) IsProxyProtocolChange() bool {
+	return c.ResourceType == targetGroupResourceType && c.Key == TargetGroupProxyProtocolKey
+}
+
+// Revert returns the change reverting this one
+func (c AttributeChange) Revert() AttributeChange {
+	c.Current, c.Desired = c.Desired, c.Current
+	return c
+}
+
+// loadBalancerAttributes returns the desired load balancer attributes
+func (a NetworkLoadBalancerAttributes) loadBalancerAttributes() map[string]string {
+	return map[string]string{
+		LoadBalancerDeletionProtectionKey: strconv.FormatBool(a.LoadBalancerTerminationProtection),
+	}
+}
+
+// targetGroupAttributes returns the desired target group attributes
+func (a NetworkLoadBalancerAttributes) targetGroupAttributes() map[string]string {
+	return map[string]string{
+		TargetGroupStickinessEnabledKey:   strconv.FormatBool(a.TargetGroupStickness),
+		TargetGroupStickinessTypeKey:      awsNetworkLoadBalancerStickness,
+		TargetGroupProxyProtocolKey:       strconv.FormatBool(a.TargetGroupProxyProtocol),
+		TargetGroupDeregistrationDelayKey: strconv.Itoa(a.TargetGroupDeregistrationDelay),
+	}
+}
+
+// PlanAttributeChanges compares the current attributes of the network load
+// balancer, its target groups and its TCP listeners with the desired ones,
+// returning the list of changes needed to reconcile them.
+func (nlb *NetworkLoadBalancer) PlanAttributeChanges(
+	nlbAttributes NetworkLoadBalancerAttributes) []AttributeChange {
+
+	changes := diffAttributes(
+		nlb.ARN, loadBalancerResourceType,
+		nlb.Attributes, nlbAttributes.loadBalancerAttributes(),
+	)
+	for _, tg := range nlb.TargetGroups {
+		changes = append(changes, diffAttributes(
+			tg.ARN, targetGroupResourceType,
+			tg.Attributes, nlbAttributes.targetGroupAttributes(),
+		)...)
+	}
+	for _, listener := range nlb.Listeners {
+		if listener.Protocol != ListenerProtocolTCP {
+			continue
+		}
+		changes = append(changes, diffAttributes(
+			listener.ARN, listenerResourceType,
+			listener.Attributes, nlbAttributes.listenerAttributes(listener.Port),
+		)...)
+	}
+	return changes
+}
+
+// diffAttributes returns the list of changes needed to go from the current
+// to the desired attributes of a resource, sorted by attribute key.
+func diffAttributes(arn, resourceType string,
+	current, desired map[string]string) []AttributeChange {
+
+	changes := []AttributeChange{}
+	for key, value := range desired {
+		if current[key] != value {
+			changes = append(changes, AttributeChange{
+				ResourceARN:  arn,
+				ResourceType: resourceType,
+				Key:          key,
+				Current:      current[key],
+				Desired:      value,
+			})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// ApplyAttributeChanges modifies the load balancer, target group and listener
+// attributes as described by the changes list.
+func (awsc *APIClient) ApplyAttributeChanges(changes []AttributeChange) error {
+
+	loadBalancers := map[string][]*elbv2.LoadBalancerAttribute{}
+	targetGroups := map[string][]*elbv2.TargetGroupAttribute{}
+	listeners := map[string][]elbv2types.ListenerAttribute{}
+	arns := []string{}
+
+	for _, c := range changes {
+		switch c.ResourceType {
+		case loadBalancerResourceType:
+			if _, ok := loadBalancers[c.ResourceARN]; !ok {
+				arns = append(arns, c.ResourceARN)
+			}
+			loadBalancers[c.ResourceARN] = append(loadBalancers[c.ResourceARN],
+				&elbv2.LoadBalancerAttribute{Key: aws.String(c.Key), Value: aws.String(c.Desired)},
+			)
+		case targetGroupResourceType:
+			if _, ok := targetGroups[c.ResourceARN]; !ok {
+				arns = append(arns, c.ResourceARN)
+			}
+			targetGroups[c.ResourceARN] = append(targetGroups[c.ResourceARN],
+				&elbv2.TargetGroupAttribute{Key: aws.String(c.Key), Value: aws.String(c.Desired)},
+			)
+		case listenerResourceType:
+			if _, ok := listeners[c.ResourceARN]; !ok {
+				arns = append(arns, c.ResourceARN)
+			}
+			listeners[c.ResourceARN] = append(listeners[c.ResourceARN],
+				elbv2types.ListenerAttribute{Key: aws.String(c.Key), Value: aws.String(c.Desired)},
+			)
+		}
+	}
+
+	for _, arn := range arns {
+		if attributes, ok := loadBalancers[arn]; ok {
+			if err := awsc.updateNetworkLoadBalancerAttributes(arn, attributes); err != nil {
+				return err
+			}
+			continue
+		}
+		if attributes, ok := listeners[arn]; ok {
+			if err := awsc.updateListenerAttributes(arn, attributes); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := awsc.updateNetworkTargetGroupAttributes(arn, targetGroups[arn]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getLoadBalancerAttributes returns the current attributes of a load balancer
+func (awsc *APIClient) getLoadBalancerAttributes(nlbARN string) (map[string]string, error) {
+
+	dlbao, err := awsc.elbv2.DescribeLoadBalancerAttributes(
+		&elbv2.DescribeLoadBalancerAttributesInput{LoadBalancerArn: aws.String(nlbARN)},
+	)
+	if err != nil {
+		log.Error(err, "unable to describe load balancer attributes",
+			"NetworkLoadBalancerARN", nlbARN,
+		)
+		return nil, err
+	}
+
+	attributes := map[string]string{}
+	for _, a := range dlbao.Attributes {
+		attributes[aws.StringValue(a.Key)] = aws.StringValue(a.Value)
+	}
+	return attributes, nil
+}
+
+// getTargetGroupAttributes returns the current attributes of a target group
+func (awsc *APIClient) getTargetGroupAttributes(targetGroupARN string) (map[string]string, error) {
+
+	dtgao, err := awsc.elbv2.DescribeTargetGroupAttributes(
+		&elbv2.DescribeTargetGroupAttributesInput{TargetGroupArn: aws.String(targetGroupARN)},
+	)
+	if err != nil {
+		log.Error(err, "unable to describe target group attributes",
+			"TargetGroupARN", targetGroupARN,
+		)
+		return nil, err
+	}
+
+	attributes := map[string]string{}
+	for _, a := range dtgao.Attributes {
+		attributes[aws.StringValue(a.Key)] = aws.StringValue(a.Value)
+	}
+	return attributes, nil
+}
+
+// updateNetworkLoadBalancerAttributes modifies the attributes of a network
+// load balancer
+func (awsc *APIClient) updateNetworkLoadBalancerAttributes(
+	nlbARN string, attributes []*elbv2.LoadBalancerAttribute) error {
+
+	mlbai := elbv2.ModifyLoadBalancerAttributesInput{
+		LoadBalancerArn: aws.String(nlbARN),
+		Attributes:      attributes,
+	}
+
+	mlbao, err := awsc.elbv2.ModifyLoadBalancerAttributes(&mlbai)
+	log.V(2).Info("Modify load balancer aws command output",
+		"ModifyLoadBalancerAttributesOutput", &mlbao,
+	)
+
+	if err != nil {
+		log.Error(
+			err, "unable to modify the network load balancer",
+			"NetworkLoadBalancerARN", nlbARN,
+		)
+		return err
+	}
+
+	log.Info("Network load balancer updated", "NetworkLoadBalancerARN", nlbARN)
+	return nil
+}
+
+// updateNetworkTargetGroupAttributes modifies the attributes of a target group
+func (awsc *APIClient) updateNetworkTargetGroupAttributes(
+	targetGroupARN string, attributes []*elbv2.TargetGroupAttribute) error {
+
+	log.V(2).Info("Updating target group", "targetGroupARN", targetGroupARN)
+
+	mtgai := elbv2.ModifyTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Attributes:     attributes,
+	}
+
+	mtgao, err := awsc.elbv2.ModifyTargetGroupAttributes(&mtgai)
+	log.V(2).Info("Modify target group aws command output",
+		"ModifyTargetGroupAttributesOutput", &mtgao,
+	)
+
+	if err != nil {
+		log.Error(
+			err, "unable to update the target groups",
+			"TargetGroupARN", targetGroupARN,
+		)
+		return err
+	}
+
+	log.Info("Target groups succesfully updated",
+		"TargetGroupARN", targetGroupARN,
+	)
+	return nil
+
+}
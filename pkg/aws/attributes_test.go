@@ -0,0 +1,106 @@
+package aws
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNetworkLoadBalancer_PlanAttributeChanges(t *testing.T) {
+	tests := []struct {
+		name          string
+		nlb           *NetworkLoadBalancer
+		nlbAttributes NetworkLoadBalancerAttributes
+		want          []string
+	}{
+		{
+			name: "up to date",
+			nlb: &NetworkLoadBalancer{
+				ARN:        "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/lb/1",
+				Attributes: map[string]string{"deletion_protection.enabled": "true"},
+				TargetGroups: []TargetGroup{{
+					ARN: "arn:aws:elasticloadbalancing:us-east-1:000000000000:targetgroup/tg/1",
+					Attributes: map[string]string{
+						"stickiness.enabled":                   "false",
+						"stickiness.type":                      "source_ip",
+						"proxy_protocol_v2.enabled":            "false",
+						"deregistration_delay.timeout_seconds": "300",
+					},
+				}},
+			},
+			nlbAttributes: NetworkLoadBalancerAttributes{
+				LoadBalancerTerminationProtection: true,
+				TargetGroupDeregistrationDelay:    300,
+			},
+			want: []string{},
+		},
+		{
+			name: "drifted",
+			nlb: &NetworkLoadBalancer{
+				ARN:        "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/lb/1",
+				Attributes: map[string]string{"deletion_protection.enabled": "false"},
+				TargetGroups: []TargetGroup{{
+					ARN: "arn:aws:elasticloadbalancing:us-east-1:000000000000:targetgroup/tg/1",
+					Attributes: map[string]string{
+						"stickiness.enabled":                   "false",
+						"stickiness.type":                      "source_ip",
+						"proxy_protocol_v2.enabled":            "false",
+						"deregistration_delay.timeout_seconds": "300",
+					},
+				}},
+			},
+			nlbAttributes: NetworkLoadBalancerAttributes{
+				LoadBalancerTerminationProtection: true,
+				TargetGroupProxyProtocol:          true,
+				TargetGroupDeregistrationDelay:    30,
+			},
+			want: []string{
+				"loadbalancer/net/lb/1 deletion_protection.enabled: false -> true",
+				"targetgroup/tg/1 deregistration_delay.timeout_seconds: 300 -> 30",
+				"targetgroup/tg/1 proxy_protocol_v2.enabled: false -> true",
+			},
+		},
+		{
+			name: "listener idle timeout",
+			nlb: &NetworkLoadBalancer{
+				ARN:        "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/lb/1",
+				Attributes: map[string]string{"deletion_protection.enabled": "false"},
+				Listeners: []Listener{
+					{
+						ARN:        "arn:aws:elasticloadbalancing:us-east-1:000000000000:listener/net/lb/1/5432",
+						Port:       5432,
+						Protocol:   "TCP",
+						Attributes: map[string]string{"tcp.idle_timeout.seconds": "350"},
+					},
+					{
+						ARN:        "arn:aws:elasticloadbalancing:us-east-1:000000000000:listener/net/lb/1/80",
+						Port:       80,
+						Protocol:   "TCP",
+						Attributes: map[string]string{"tcp.idle_timeout.seconds": "350"},
+					},
+					{
+						ARN:      "arn:aws:elasticloadbalancing:us-east-1:000000000000:listener/net/lb/1/443",
+						Port:     443,
+						Protocol: "TLS",
+					},
+				},
+			},
+			nlbAttributes: NetworkLoadBalancerAttributes{
+				ListenerTCPIdleTimeouts: map[int64]int{5432: 3600, 443: 3600},
+			},
+			want: []string{
+				"listener/net/lb/1/5432 tcp.idle_timeout.seconds: 350 -> 3600",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, change := range tt.nlb.PlanAttributeChanges(tt.nlbAttributes) {
+				got = append(got, change.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanAttributeChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package aws
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+)
+
+const (
+	// SecretTagKey is the tag identifying the Secret an ACM certificate was
+	// imported from, as `<namespace>/<name>`
+	SecretTagKey = "aws-nlb-helper.3scale.net/secret"
+	// CertificateHashTagKey is the tag holding the SHA-256 of the imported
+	// certificate chain, so it is only re-imported when the Secret changes
+	CertificateHashTagKey = "aws-nlb-helper.3scale.net/certificate-sha256"
+	// ImportedAtTagKey is the tag holding the RFC 3339 time of the last
+	// import of the certificate, so the cleanups skip the fresh imports
+	ImportedAtTagKey = "aws-nlb-helper.3scale.net/imported-at"
+
+	awsCertificateResourceTypeFilter = "acm:certificate"
+	pemCertificateType               = "CERTIFICATE"
+)
+
+// ImportedCertificate is an ACM certificate imported by the helper from a
+// Kubernetes TLS Secret
+type ImportedCertificate struct {
+	ARN    string
+	Secret string
+	Hash   string
+	// ImportedAt is zero for the certificates imported without the tag
+	ImportedAt time.Time
+}
+
+// CertificateHash returns the hash of a PEM encoded certificate chain, as
+// stored in the CertificateHashTagKey tag
+func CertificateHash(chain []byte) string {
+	sum := sha256.Sum256(chain)
+	return hex.EncodeToString(sum[:])
+}
+
+// SplitCertificateChain splits a PEM encoded certificate chain, as found in
+// the `tls.crt` key of the TLS Secrets, into the leaf certificate and the
+// intermediate certificates, empty if there are none.
+func SplitCertificateChain(data []byte) ([]byte, []byte, error) {
+	var certificate []byte
+	chain := &bytes.Buffer{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != pemCertificateType {
+			return nil, nil, fmt.Errorf("unexpected PEM block %q in the certificate chain", block.Type)
+		}
+		if certificate == nil {
+			certificate = pem.EncodeToMemory(block)
+			continue
+		}
+		if err := pem.Encode(chain, block); err != nil {
+			return nil, nil, err
+		}
+	}
+	if certificate == nil {
+		return nil, nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return certificate, chain.Bytes(), nil
+}
+
+// GetImportedCertificates returns the ACM certificates imported by the helper
+// instance, found by their ownership tags
+func (awsc *APIClient) GetImportedCertificates(instanceID string) ([]ImportedCertificate, error) {
+
+	gri := resourcegroupstaggingapi.GetResourcesInput{
+		TagFilters: generateTagFilters(map[string]string{
+			ManagedByTagKey:  ManagedByTagValue,
+			InstanceIDTagKey: instanceID,
+		}),
+		ResourceTypeFilters: []*string{aws.String(awsCertificateResourceTypeFilter)},
+	}
+
+	certificates := []ImportedCertificate{}
+	err := awsc.rgtapi.GetResourcesPages(&gri,
+		func(page *resourcegroupstaggingapi.GetResourcesOutput, lastPage bool) bool {
+			for _, resource := range page.ResourceTagMappingList {
+				certificate := ImportedCertificate{ARN: aws.StringValue(resource.ResourceARN)}
+				for _, t := range resource.Tags {
+					switch aws.StringValue(t.Key) {
+					case SecretTagKey:
+						certificate.Secret = aws.StringValue(t.Value)
+					case CertificateHashTagKey:
+						certificate.Hash = aws.StringValue(t.Value)
+					case ImportedAtTagKey:
+						certificate.ImportedAt, _ = time.Parse(time.RFC3339, aws.StringValue(t.Value))
+					}
+				}
+				certificates = append(certificates, certificate)
+			}
+			return true
+		})
+	if err != nil {
+		log.Error(err, "unable to list the imported ACM certificates", "InstanceID", instanceID)
+		return nil, err
+	}
+	return certificates, nil
+}
+
+// ImportCertificate imports the certificate of the Secret into ACM, tagged
+// with the ownership tags of the helper instance. If arn is not empty, the
+// existing certificate is re-imported, keeping its ARN, and its hash and
+// import time tags are updated. The ARN of the certificate is returned.
+func (awsc *APIClient) ImportCertificate(
+	instanceID, secret, arn string, chain, privateKey []byte) (string, error) {
+
+	certificate, intermediates, err := SplitCertificateChain(chain)
+	if err != nil {
+		return "", err
+	}
+	hash := CertificateHash(chain)
+	importedAt := time.Now().UTC().Format(time.RFC3339)
+
+	ici := &acm.ImportCertificateInput{
+		Certificate: certificate,
+		PrivateKey:  privateKey,
+	}
+	if len(intermediates) > 0 {
+		ici.CertificateChain = intermediates
+	}
+	if arn == "" {
+		ici.Tags = []*acm.Tag{
+			{Key: aws.String(ManagedByTagKey), Value: aws.String(ManagedByTagValue)},
+			{Key: aws.String(InstanceIDTagKey), Value: aws.String(instanceID)},
+			{Key: aws.String(SecretTagKey), Value: aws.String(secret)},
+			{Key: aws.String(CertificateHashTagKey), Value: aws.String(hash)},
+			{Key: aws.String(ImportedAtTagKey), Value: aws.String(importedAt)},
+		}
+	} else {
+		ici.CertificateArn = aws.String(arn)
+	}
+
+	ico, err := awsc.acm.ImportCertificate(ici)
+	if err != nil {
+		log.Error(err, "unable to import the certificate into ACM", "Secret", secret, "CertificateARN", arn)
+		return "", err
+	}
+	if arn == "" {
+		return aws.StringValue(ico.CertificateArn), nil
+	}
+
+	// the tags can't be set when re-importing a certificate
+	if _, err := awsc.acm.AddTagsToCertificate(&acm.AddTagsToCertificateInput{
+		CertificateArn: aws.String(arn),
+		Tags: []*acm.Tag{
+			{Key: aws.String(CertificateHashTagKey), Value: aws.String(hash)},
+			{Key: aws.String(ImportedAtTagKey), Value: aws.String(importedAt)},
+		},
+	}); err != nil {
+		log.Error(err, "unable to tag the re-imported ACM certificate", "Secret", secret, "CertificateARN", arn)
+		return "", err
+	}
+	return arn, nil
+}
+
+// DeleteCertificate deletes an imported ACM certificate, failing while it is
+// still in use by a listener
+func (awsc *APIClient) DeleteCertificate(arn string) error {
+	if _, err := awsc.acm.DeleteCertificate(&acm.DeleteCertificateInput{
+		CertificateArn: aws.String(arn),
+	}); err != nil {
+		log.Error(err, "unable to delete the ACM certificate", "CertificateARN", arn)
+		return err
+	}
+	awsc.certificates.reset()
+	return nil
+}
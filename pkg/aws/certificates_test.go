@@ -0,0 +1,83 @@
+package aws
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+)
+
+func TestSplitCertificateChain(t *testing.T) {
+	block := func(blockType, bytes string) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: []byte(bytes)}))
+	}
+	leaf, intermediate, root := block("CERTIFICATE", "leaf"), block("CERTIFICATE", "intermediate"), block("CERTIFICATE", "root")
+	tests := []struct {
+		name            string
+		data            string
+		wantCertificate string
+		wantChain       string
+		wantErr         bool
+	}{
+		{name: "leaf only", data: leaf, wantCertificate: leaf},
+		{
+			name:            "full chain",
+			data:            leaf + intermediate + root,
+			wantCertificate: leaf,
+			wantChain:       intermediate + root,
+		},
+		{name: "private key", data: leaf + block("PRIVATE KEY", "key"), wantErr: true},
+		{name: "not PEM", data: "certificate", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate, chain, err := SplitCertificateChain([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitCertificateChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(certificate) != tt.wantCertificate {
+				t.Errorf("SplitCertificateChain() certificate = %q, want %q", certificate, tt.wantCertificate)
+			}
+			if string(chain) != tt.wantChain {
+				t.Errorf("SplitCertificateChain() chain = %q, want %q", chain, tt.wantChain)
+			}
+		})
+	}
+}
+
+func TestAPIClient_GetImportedCertificates(t *testing.T) {
+	const cert = "arn:aws:acm:us-east-1:000000000000:certificate/a"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_, _ = w.Write([]byte(`{"ResourceTagMappingList":[{"ResourceARN":"` + cert + `","Tags":[` +
+			`{"Key":"aws-nlb-helper.3scale.net/secret","Value":"ns/tls"},` +
+			`{"Key":"aws-nlb-helper.3scale.net/certificate-sha256","Value":"abc"},` +
+			`{"Key":"aws-nlb-helper.3scale.net/imported-at","Value":"2023-01-02T03:04:05Z"}]}]}`))
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	awsc := &APIClient{rgtapi: resourcegroupstaggingapi.New(sess)}
+
+	got, err := awsc.GetImportedCertificates("instance")
+	if err != nil {
+		t.Fatalf("GetImportedCertificates() error = %v", err)
+	}
+	want := ImportedCertificate{
+		ARN: cert, Secret: "ns/tls", Hash: "abc", ImportedAt: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("GetImportedCertificates() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// CheckConnectivity verifies the AWS credentials and the access to the
+// elastic load balancing API of the configured region, using cheap read
+// only calls.
+func (awsc *APIClient) CheckConnectivity() error {
+
+	if _, err := awsc.sts.GetCallerIdentity(&sts.GetCallerIdentityInput{}); err != nil {
+		return fmt.Errorf("unable to get the AWS caller identity, check the credentials: %w", err)
+	}
+
+	_, err := awsc.elbv2.DescribeLoadBalancers(
+		&elbv2.DescribeLoadBalancersInput{PageSize: aws.Int64(1)},
+	)
+	if err != nil {
+		return fmt.Errorf(
+			"unable to describe the load balancers of the %s region, check the region and permissions: %w",
+			aws.StringValue(awsc.elbv2.Config.Region), err,
+		)
+	}
+
+	return nil
+}
+
+// ReadinessChecker periodically checks the AWS connectivity and caches the
+// result, so the readiness probe does not call the AWS APIs.
+type ReadinessChecker struct {
+	AWSClient *APIClient
+	// Interval between checks
+	Interval time.Duration
+
+	mutex sync.RWMutex
+	err   error
+	last  time.Time
+}
+
+// Start runs a check every Interval until the context is done, implementing
+// the manager Runnable interface.
+func (c *ReadinessChecker) Start(ctx context.Context) error {
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.Refresh()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes the checks run on all the instances
+func (c *ReadinessChecker) NeedLeaderElection() bool {
+	return false
+}
+
+// Refresh checks the AWS connectivity and caches the result
+func (c *ReadinessChecker) Refresh() {
+	err := c.AWSClient.CheckConnectivity()
+	if err != nil {
+		log.Error(err, "AWS connectivity check failed")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err == nil && c.err != nil {
+		log.Info("AWS connectivity check succeeded")
+	}
+	c.err, c.last = err, time.Now()
+}
+
+// Check returns the cached result of the last AWS connectivity check,
+// implementing the healthz Checker function.
+func (c *ReadinessChecker) Check(_ *http.Request) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.last.IsZero() {
+		return errors.New("AWS connectivity not checked yet")
+	}
+	if c.err != nil {
+		return fmt.Errorf("AWS connectivity check failed at %s: %v",
+			c.last.Format(time.RFC3339), c.err,
+		)
+	}
+	return nil
+}
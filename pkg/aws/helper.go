@@ -3,13 +3,22 @@ package aws
 import (
 	"fmt"
 	"os"
-	"strconv"
+	"strings"
+	"time"
 
+	"github.com/3scale-ops/aws-nlb-helper-operator/pkg/metrics"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/sts"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -21,99 +30,220 @@ const (
 	awsLoadBalancerResourceTypeFilter        = "elasticloadbalancing"
 	awsTargetGroupResourceTypeFilter         = "elasticloadbalancing:targetgroup"
 	awsNetworkLoadBalancerResourceTypeFilter = "elasticloadbalancing:loadbalancer/net"
-	awsNetworkLoadBalancerStickness          = "source_ip"
+	awsServiceNameTagKey                     = "kubernetes.io/service-name"
 )
 
 // APIClient is the struct implementing the AWS provider interface
 type APIClient struct {
 	elbv2  *elbv2.ELBV2
 	rgtapi *resourcegroupstaggingapi.ResourceGroupsTaggingAPI
+	sts    *sts.STS
+	iam    *iam.IAM
+	acm    *acm.ACM
+	ec2    *ec2.EC2
+	// elbv2Listeners sends the listener attributes operations, missing from
+	// the elbv2 client
+	elbv2Listeners *elasticloadbalancingv2.Client
+
+	// certificates caches the resolved domain and tag certificate
+	// references
+	certificates certificateCache
 }
 
-// NetworkLoadBalancerAttributes struct
-type NetworkLoadBalancerAttributes struct {
-	LoadBalancerTerminationProtection bool
-	TargetGroupDeregistrationDelay    int
-	TargetGroupStickness              bool
-	TargetGroupProxyProtocol          bool
+// NetworkLoadBalancer holds the discovered state of a network load balancer
+// and the target groups attached to it.
+type NetworkLoadBalancer struct {
+	ARN          string
+	DNSName      string
+	VpcID        string
+	Subnets      []string
+	Attributes   map[string]string
+	Tags         map[string]string
+	TargetGroups []TargetGroup
+	Listeners    []Listener
+	// IPAddressType is either `ipv4` or `dualstack`
+	IPAddressType string
+	// SecurityGroups are empty if the load balancer was created without
+	// security groups
+	SecurityGroups     []string
+	EnforcePrivateLink string
 }
 
-// UpdateNetworkLoadBalancer updates an AWS load balancer
-func UpdateNetworkLoadBalancer(
-	nlbDNS string,
-	serviceNameTagValue string,
-	nlbAttributes NetworkLoadBalancerAttributes) (bool, error) {
+// TargetGroup holds the discovered state of a network load balancer target
+// group.
+type TargetGroup struct {
+	ARN        string
+	TargetType string
+	Port       int64
+	Attributes map[string]string
+	Tags       map[string]string
+}
 
-	ulbLog := log.WithValues(
-		"LoadBalancerDNS", nlbDNS, "ServiceName", serviceNameTagValue,
-	)
+// NewAPIClient obtains an AWS session and initiates the needed AWS clients.
+// The region defaults to the AWS_REGION environment variable if empty.
+func NewAPIClient(region string) (*APIClient, error) {
 
-	// Get AWS Clients for ELBV2 and ResourceGroupsTaggingAPI APIs
-	awsClient, err := newAPIClient()
+	// Initialize an AWS session
+	sess, err := session.NewSession(newAWSConfig(region))
 	if err != nil {
-		ulbLog.Error(err, "unable to initialize an AWS Client")
-		return false, err
+		return nil, fmt.Errorf("unable to initialize AWS session: %v", err)
 	}
 
+	return NewAPIClientFromSession(sess), nil
+
+}
+
+// NewAPIClientFromSession returns the AWS API clients sharing the sess
+// session, which allows pointing them at a custom endpoint.
+func NewAPIClientFromSession(sess *session.Session) *APIClient {
+
+	sess.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "aws-nlb-helper/metrics", Fn: observeRequest,
+	})
+
+	// Return AWS clients for ELBV2, ResourceGroupsTaggingAPI, STS, IAM, ACM
+	// and EC2, along with the aws-sdk-go-v2 ELBV2 client for the listener
+	// attributes
+	return &APIClient{
+		elbv2:  elbv2.New(sess),
+		rgtapi: resourcegroupstaggingapi.New(sess),
+		sts:    sts.New(sess),
+		iam:    iam.New(sess),
+		acm:    acm.New(sess),
+		ec2:    ec2.New(sess),
+
+		elbv2Listeners: newListenerAttributesClient(sess),
+	}
+
+}
+
+// GetNetworkLoadBalancer discovers the network load balancer tagged with the
+// serviceNameTagValue service name and matching the nlbDNS DNS name, along with
+// the current attributes of the load balancer and its target groups.
+func (awsc *APIClient) GetNetworkLoadBalancer(
+	nlbDNS string, serviceNameTagValue string) (*NetworkLoadBalancer, error) {
+
+	gnlbLog := log.WithValues(
+		"LoadBalancerDNS", nlbDNS, "ServiceName", serviceNameTagValue,
+	)
+
 	// Generate resource tags map
 	tags := map[string]string{
-		"kubernetes.io/service-name": serviceNameTagValue,
+		awsServiceNameTagKey: serviceNameTagValue,
 		// https://github.com/3scale/aws-nlb-helper-operator/issues/1
 		// fmt.Sprintf("kubernetes.io/cluster/%s", clusterIDTagKey): "owned",
 	}
-	ulbLog.V(2).Info("Looking for tagged resources", "Tags", tags)
+	gnlbLog.V(2).Info("Looking for tagged resources", "Tags", tags)
 
 	// Get tagged network load balancers
-	filteredLoadBalancers, err := awsClient.getNetworkLoadBalancerByTag(tags)
+	filteredLoadBalancers, err := awsc.getNetworkLoadBalancerByTag(tags)
 	if err != nil {
-		ulbLog.Error(
+		gnlbLog.Error(
 			err, "unable to obtain load balancers matching the tags",
 			"Tags", tags,
 		)
-		return false, err
+		return nil, err
 	}
 
 	// Second filtering using DNS name as clusterIDTagKey is not available
 	// https://github.com/3scale/aws-nlb-helper-operator/issues/1
 
-	nlbARN, err := awsClient.getLoadBalancerByDNS(filteredLoadBalancers, nlbDNS)
+	description, err := awsc.getLoadBalancerByDNS(filteredLoadBalancers, nlbDNS)
 	if err != nil {
-		ulbLog.Error(
+		gnlbLog.Error(
 			err, "unable to obtain load balancers matching the DNS",
 			"Tags", tags,
 		)
-		return false, err
+		return nil, err
 	}
-
-	// update network load balancer attributes
-	ulbLog.Info("elastic load balancer matching tags and DNS found",
-		"NetowrkLoadBalancerARN", nlbARN, "NetowrkLoadBalancerDNS", nlbDNS,
+	nlbARN := aws.StringValue(description.LoadBalancerArn)
+	gnlbLog.V(1).Info("elastic load balancer matching tags and DNS found",
+		"NetworkLoadBalancerARN", nlbARN,
 	)
-	awsClient.updateNetworkLoadBalancerAttributes(nlbARN, nlbAttributes)
 
-	// update target group attributes
-	targetGroupARNs, err := awsClient.getTargetGroupsByLoadBalancer(nlbARN)
+	nlb := &NetworkLoadBalancer{ARN: nlbARN, DNSName: nlbDNS}
+	nlb.VpcID = aws.StringValue(description.VpcId)
+	nlb.IPAddressType = aws.StringValue(description.IpAddressType)
+	for _, az := range description.AvailabilityZones {
+		nlb.Subnets = append(nlb.Subnets, aws.StringValue(az.SubnetId))
+	}
+	nlb.SecurityGroups = aws.StringValueSlice(description.SecurityGroups)
+	nlb.EnforcePrivateLink = aws.StringValue(description.EnforceSecurityGroupInboundRulesOnPrivateLinkTraffic)
+
+	nlb.Attributes, err = awsc.getLoadBalancerAttributes(nlbARN)
+	if err != nil {
+		return nil, err
+	}
+
+	targetGroups, err := awsc.getTargetGroupsByLoadBalancer(nlbARN)
 	if err != nil {
-		ulbLog.Error(
+		gnlbLog.Error(
 			err, "unable to obtain load balancer target groups",
-			"NetowrkLoadBalancerARN", nlbARN,
+			"NetworkLoadBalancerARN", nlbARN,
 		)
-		return false, err
+		return nil, err
 	}
-	for _, targetGroupARN := range targetGroupARNs {
-		awsClient.updateNetworkTargetGroupAttribute(targetGroupARN, nlbAttributes)
+	targetGroupARNs := []string{}
+	for _, tg := range targetGroups {
+		tg.Attributes, err = awsc.getTargetGroupAttributes(tg.ARN)
+		if err != nil {
+			return nil, err
+		}
+		nlb.TargetGroups = append(nlb.TargetGroups, tg)
+		targetGroupARNs = append(targetGroupARNs, tg.ARN)
 	}
 
-	return true, nil
+	nlb.Listeners, err = awsc.getListeners(nlbARN)
+	if err != nil {
+		return nil, err
+	}
+
+	resourceTags, err := awsc.getTags(append([]string{nlbARN}, targetGroupARNs...))
+	if err != nil {
+		return nil, err
+	}
+	nlb.Tags = resourceTags[nlbARN]
+	for i := range nlb.TargetGroups {
+		nlb.TargetGroups[i].Tags = resourceTags[nlb.TargetGroups[i].ARN]
+	}
+
+	return nlb, nil
+}
+
+// observeRequest records the AWS API call count and latency metrics of a
+// completed request, retries included.
+func observeRequest(r *request.Request) {
+	operation := ""
+	if r.Operation != nil {
+		operation = r.Operation.Name
+	}
+	errorCode := ""
+	if r.Error != nil {
+		errorCode = "Unknown"
+		if aerr, ok := r.Error.(awserr.Error); ok {
+			errorCode = aerr.Code()
+		}
+	}
+	observeAPICall(r.ClientInfo.ServiceName, operation, errorCode, r.Time)
+}
+
+// observeAPICall records the metrics of an AWS API call started at start
+func observeAPICall(service, operation, errorCode string, start time.Time) {
+	metrics.AWSAPICalls.WithLabelValues(service, operation, errorCode).Inc()
+	metrics.AWSAPICallDuration.WithLabelValues(service, operation).
+		Observe(time.Since(start).Seconds())
 }
 
 // newAWSConfig generates an AWS config.
-func newAWSConfig() *aws.Config {
+func newAWSConfig(awsRegion string) *aws.Config {
 
 	awscfgLog := log.WithName("config")
 
 	// set aws client region
-	awsRegion, found := os.LookupEnv("AWS_REGION")
+	found := awsRegion != ""
+	if !found {
+		awsRegion, found = os.LookupEnv("AWS_REGION")
+	}
 	if !found {
 		awsRegion = awsDefaultRegion
 		awscfgLog.Info("Empty AWS_REGION, defaulting",
@@ -144,27 +274,17 @@ func newAWSConfig() *aws.Config {
 
 }
 
-// newAPIClient obtains an AWS session and initiates the needed AWS clients.
-func newAPIClient() (*APIClient, error) {
+// getLoadBalancerByDNS returns the description of the load balancer matching
+// the DNS name
+func (awsc *APIClient) getLoadBalancerByDNS(
+	loadBalancerARNs []string, loadBalancerDNS string) (*elbv2.LoadBalancer, error) {
 
-	// Initialize an AWS session
-	sess, err := session.NewSession(newAWSConfig())
-	if err != nil {
-		return nil, fmt.Errorf("unable to initialize AWS session: %v", err)
+	if len(loadBalancerARNs) == 0 {
+		return nil, fmt.Errorf(
+			"load balancer with DNS %s was not found", loadBalancerDNS,
+		)
 	}
 
-	// Return AWS clients for ELBV2 and ResourceGroupsTaggingAPI
-	return &APIClient{
-		elbv2:  elbv2.New(sess),
-		rgtapi: resourcegroupstaggingapi.New(sess),
-	}, nil
-
-}
-
-// getLoadBalancerByDNS returns the load balancer DNS name
-func (awsc *APIClient) getLoadBalancerByDNS(
-	loadBalancerARNs []string, loadBalancerDNS string) (string, error) {
-
 	dlbi := elbv2.DescribeLoadBalancersInput{}
 	for _, arn := range loadBalancerARNs {
 		dlbi.LoadBalancerArns = append(dlbi.LoadBalancerArns, aws.String(arn))
@@ -176,16 +296,16 @@ func (awsc *APIClient) getLoadBalancerByDNS(
 			err, "unable to describe load balancer",
 			"LoadBalancerARNs", loadBalancerARNs, "DescribeTargetGroupsOutput", &dlbo,
 		)
-		return "", err
+		return nil, err
 	}
 
 	for _, lb := range dlbo.LoadBalancers {
-		if *lb.DNSName == loadBalancerDNS {
-			return *lb.LoadBalancerArn, nil
+		if aws.StringValue(lb.DNSName) == loadBalancerDNS {
+			return lb, nil
 		}
 	}
 
-	return "", fmt.Errorf(
+	return nil, fmt.Errorf(
 		"load balancer with DNS %s was not found", loadBalancerDNS,
 	)
 
@@ -228,7 +348,6 @@ func (awsc *APIClient) getResourcesByFilter(
 
 	resources, err := awsc.rgtapi.GetResources(getResourcesInput)
 	if err != nil {
-		println(err.Error())
 		return nil, err
 	}
 
@@ -239,42 +358,9 @@ func (awsc *APIClient) getResourcesByFilter(
 	return elbARNs, nil
 }
 
-// updateNetworkLoadBalancerAttributes returns the result of a nlb update
-func (awsc *APIClient) updateNetworkLoadBalancerAttributes(
-	nlbARN string, nlbAttributes NetworkLoadBalancerAttributes) (bool, error) {
-
-	mlbai := elbv2.ModifyLoadBalancerAttributesInput{
-		LoadBalancerArn: aws.String(nlbARN),
-		Attributes: []*elbv2.LoadBalancerAttribute{
-			{
-				Key: aws.String("deletion_protection.enabled"),
-				Value: aws.String(
-					strconv.FormatBool(nlbAttributes.LoadBalancerTerminationProtection),
-				),
-			},
-		},
-	}
-
-	mlbao, err := awsc.elbv2.ModifyLoadBalancerAttributes(&mlbai)
-	log.V(2).Info("Modify load balancer aws command output",
-		"ModifyLoadBalancerAttributesOutput", &mlbao,
-	)
-
-	if err != nil {
-		log.Error(
-			err, "unable to modify the network load balancer",
-			"NetworkLoadBalancerARN", nlbARN,
-		)
-		return false, err
-	}
-
-	log.Info("Network load balancer updated", "NetworkLoadBalancerARN", nlbARN)
-	return true, nil
-}
-
 // getTargetGroupsByLoadBalancer returns a list of target groups attached to a
 // the load balancer defined by the loadBalancerARN parameter.
-func (awsc *APIClient) getTargetGroupsByLoadBalancer(elbARN string) ([]string, error) {
+func (awsc *APIClient) getTargetGroupsByLoadBalancer(elbARN string) ([]TargetGroup, error) {
 
 	dlbi := elbv2.DescribeTargetGroupsInput{
 		LoadBalancerArn: aws.String(elbARN),
@@ -288,57 +374,19 @@ func (awsc *APIClient) getTargetGroupsByLoadBalancer(elbARN string) ([]string, e
 		return nil, err
 	}
 
-	targetGroupARNs := []string{}
+	targetGroups := []TargetGroup{}
 	for _, tg := range dtgo.TargetGroups {
-		targetGroupARNs = append(targetGroupARNs, *tg.TargetGroupArn)
+		targetGroups = append(targetGroups, TargetGroup{
+			ARN:        aws.StringValue(tg.TargetGroupArn),
+			TargetType: aws.StringValue(tg.TargetType),
+			Port:       aws.Int64Value(tg.Port),
+		})
 	}
-	return targetGroupARNs, nil
+	return targetGroups, nil
 }
 
-// updateNetworkTargetGroupAttribute returns the result of updating the target groups
-func (awsc *APIClient) updateNetworkTargetGroupAttribute(
-	targetGroupARN string, nlbAttributes NetworkLoadBalancerAttributes) (bool, error) {
-
-	log.V(2).Info("Updating target group", "targetGroupARN", targetGroupARN)
-
-	mtgai := elbv2.ModifyTargetGroupAttributesInput{
-		TargetGroupArn: aws.String(targetGroupARN),
-		Attributes: []*elbv2.TargetGroupAttribute{
-			{
-				Key:   aws.String("stickiness.enabled"),
-				Value: aws.String(strconv.FormatBool(nlbAttributes.TargetGroupStickness)),
-			},
-			{
-				Key:   aws.String("stickiness.type"),
-				Value: aws.String(awsNetworkLoadBalancerStickness),
-			},
-			{
-				Key:   aws.String("proxy_protocol_v2.enabled"),
-				Value: aws.String(strconv.FormatBool(nlbAttributes.TargetGroupProxyProtocol)),
-			},
-			{
-				Key:   aws.String("deregistration_delay.timeout_seconds"),
-				Value: aws.String(strconv.Itoa(nlbAttributes.TargetGroupDeregistrationDelay)),
-			},
-		},
-	}
-
-	mtgao, err := awsc.elbv2.ModifyTargetGroupAttributes(&mtgai)
-	log.V(2).Info("Modify target group aws command output",
-		"ModifyTargetGroupAttributesOutput", &mtgao,
-	)
-
-	if err != nil {
-		log.Error(
-			err, "unable to update the target groups",
-			"TargetGroupARN", targetGroupARN,
-		)
-		return false, err
-	}
-
-	log.Info("Target groups succesfully updated",
-		"TargetGroupARN", targetGroupARN,
-	)
-	return true, nil
-
+// resourceName returns the resource part of an ARN, like
+// `loadbalancer/net/name/id` or `targetgroup/name/id`.
+func resourceName(arn string) string {
+	return arn[strings.LastIndex(arn, ":")+1:]
 }
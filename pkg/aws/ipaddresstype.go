@@ -0,0 +1,107 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const (
+	// IPAddressTypeIPv4 and IPAddressTypeDualstack are the IP address types
+	// of the network load balancers
+	IPAddressTypeIPv4      = elbv2.IpAddressTypeIpv4
+	IPAddressTypeDualstack = elbv2.IpAddressTypeDualstack
+)
+
+// IPAddressTypes lists the IP address types supported by the network load
+// balancers
+var IPAddressTypes = []string{IPAddressTypeIPv4, IPAddressTypeDualstack}
+
+// IPAddressTypeChange describes a change of the IP address type of a network
+// load balancer
+type IPAddressTypeChange struct {
+	LoadBalancerARN string
+	Current         string
+	Desired         string
+}
+
+// String returns a human readable representation of the change, like
+// `loadbalancer/net/name/id ip-address-type: ipv4 -> dualstack`.
+func (c IPAddressTypeChange) String() string {
+	return fmt.Sprintf("%s ip-address-type: %s -> %s", resourceName(c.LoadBalancerARN), c.Current, c.Desired)
+}
+
+// PlanIPAddressTypeChange compares the IP address type of the network load
+// balancer with the desired one, returning nil if there is nothing to change
+func (nlb *NetworkLoadBalancer) PlanIPAddressTypeChange(desired string) *IPAddressTypeChange {
+	if desired == "" || desired == nlb.IPAddressType {
+		return nil
+	}
+	return &IPAddressTypeChange{LoadBalancerARN: nlb.ARN, Current: nlb.IPAddressType, Desired: desired}
+}
+
+// ValidateDualstackSubnets returns an error listing the subnets without an
+// associated IPv6 CIDR block, as all the subnets of a dualstack load balancer
+// need one
+func (awsc *APIClient) ValidateDualstackSubnets(subnets []string) error {
+
+	if len(subnets) == 0 {
+		return nil
+	}
+	missing := map[string]bool{}
+	for _, subnet := range subnets {
+		missing[subnet] = true
+	}
+
+	err := awsc.ec2.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice(subnets)},
+		func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+			for _, subnet := range page.Subnets {
+				for _, association := range subnet.Ipv6CidrBlockAssociationSet {
+					if association.Ipv6CidrBlockState != nil && aws.StringValue(
+						association.Ipv6CidrBlockState.State) == ec2.SubnetCidrBlockStateCodeAssociated {
+						delete(missing, aws.StringValue(subnet.SubnetId))
+					}
+				}
+			}
+			return true
+		})
+	if err != nil {
+		log.Error(err, "unable to describe the load balancer subnets", "Subnets", subnets)
+		return err
+	}
+
+	if len(missing) > 0 {
+		list := make([]string, 0, len(missing))
+		for subnet := range missing {
+			list = append(list, subnet)
+		}
+		sort.Strings(list)
+		return fmt.Errorf("subnets without an IPv6 CIDR block: %s", strings.Join(list, ", "))
+	}
+	return nil
+}
+
+// ApplyIPAddressTypeChange sets the IP address type of the load balancer
+func (awsc *APIClient) ApplyIPAddressTypeChange(change IPAddressTypeChange) error {
+
+	output, err := awsc.elbv2.SetIpAddressType(&elbv2.SetIpAddressTypeInput{
+		LoadBalancerArn: aws.String(change.LoadBalancerARN),
+		IpAddressType:   aws.String(change.Desired),
+	})
+	log.V(2).Info("Set IP address type aws command output", "SetIpAddressTypeOutput", output)
+	if err != nil {
+		log.Error(err, "unable to set the load balancer IP address type",
+			"LoadBalancerARN", change.LoadBalancerARN,
+		)
+		return err
+	}
+
+	log.Info("Load balancer IP address type updated",
+		"LoadBalancerARN", change.LoadBalancerARN, "IPAddressType", change.Desired,
+	)
+	return nil
+}
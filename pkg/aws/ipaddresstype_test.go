@@ -0,0 +1,71 @@
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestNetworkLoadBalancer_PlanIPAddressTypeChange(t *testing.T) {
+	nlb := &NetworkLoadBalancer{
+		ARN:           "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/lb/1",
+		IPAddressType: IPAddressTypeIPv4,
+	}
+	tests := []struct {
+		name    string
+		desired string
+		want    string
+	}{
+		{name: "unmanaged", desired: ""},
+		{name: "up to date", desired: IPAddressTypeIPv4},
+		{name: "dualstack", desired: IPAddressTypeDualstack, want: "loadbalancer/net/lb/1 ip-address-type: ipv4 -> dualstack"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if change := nlb.PlanIPAddressTypeChange(tt.desired); change != nil {
+				got = change.String()
+			}
+			if got != tt.want {
+				t.Errorf("PlanIPAddressTypeChange() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIClient_ValidateDualstackSubnets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(`<DescribeSubnetsResponse><subnetSet>` +
+			`<item><subnetId>subnet-1</subnetId><ipv6CidrBlockAssociationSet><item>` +
+			`<ipv6CidrBlock>2001:db8:1::/64</ipv6CidrBlock><ipv6CidrBlockState><state>associated</state></ipv6CidrBlockState>` +
+			`</item></ipv6CidrBlockAssociationSet></item>` +
+			`<item><subnetId>subnet-2</subnetId><ipv6CidrBlockAssociationSet><item>` +
+			`<ipv6CidrBlock>2001:db8:2::/64</ipv6CidrBlock><ipv6CidrBlockState><state>disassociated</state></ipv6CidrBlockState>` +
+			`</item></ipv6CidrBlockAssociationSet></item>` +
+			`<item><subnetId>subnet-3</subnetId></item>` +
+			`</subnetSet></DescribeSubnetsResponse>`))
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	awsc := &APIClient{ec2: ec2.New(sess)}
+
+	err := awsc.ValidateDualstackSubnets([]string{"subnet-1", "subnet-2", "subnet-3"})
+	want := "subnets without an IPv6 CIDR block: subnet-2, subnet-3"
+	if err == nil || err.Error() != want {
+		t.Errorf("ValidateDualstackSubnets() error = %v, want %q", err, want)
+	}
+	if err := awsc.ValidateDualstackSubnets([]string{"subnet-1"}); err != nil {
+		t.Errorf("ValidateDualstackSubnets() error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,151 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const (
+	// ListenerProtocolTCP is the protocol of the TCP listeners
+	ListenerProtocolTCP = elbv2.ProtocolEnumTcp
+
+	// Listener attribute keys
+	ListenerTCPIdleTimeoutKey = "tcp.idle_timeout.seconds"
+	listenerResourceType      = "listener"
+
+	// MinListenerTCPIdleTimeout and MaxListenerTCPIdleTimeout are the AWS
+	// limits of the TCP listeners idle timeout, in seconds
+	MinListenerTCPIdleTimeout = 60
+	MaxListenerTCPIdleTimeout = 6000
+)
+
+// listenerAttributes returns the desired attributes of the TCP listener of
+// the port, nil if none is managed
+func (a NetworkLoadBalancerAttributes) listenerAttributes(port int64) map[string]string {
+	timeout, ok := a.ListenerTCPIdleTimeouts[port]
+	if !ok {
+		return nil
+	}
+	return map[string]string{ListenerTCPIdleTimeoutKey: strconv.Itoa(timeout)}
+}
+
+// managedListenerAttributes returns the keys of the listener attributes
+// managed by the helper
+func managedListenerAttributes() map[string]string {
+	return map[string]string{ListenerTCPIdleTimeoutKey: ""}
+}
+
+// newListenerAttributesClient returns an aws-sdk-go-v2 ELBv2 client sharing
+// the region, endpoint, credentials and metrics of the session, as the
+// listener attributes operations are missing from aws-sdk-go
+func newListenerAttributesClient(sess *session.Session) *elasticloadbalancingv2.Client {
+	options := elasticloadbalancingv2.Options{
+		Region: aws.StringValue(sess.Config.Region),
+		Credentials: awsv2.CredentialsProviderFunc(func(ctx context.Context) (awsv2.Credentials, error) {
+			value, err := sess.Config.Credentials.GetWithContext(ctx)
+			if err != nil {
+				return awsv2.Credentials{}, err
+			}
+			return awsv2.Credentials{
+				AccessKeyID:     value.AccessKeyID,
+				SecretAccessKey: value.SecretAccessKey,
+				SessionToken:    value.SessionToken,
+				Source:          value.ProviderName,
+			}, nil
+		}),
+		APIOptions: []func(*middleware.Stack) error{addObserveRequestMiddleware},
+	}
+	if endpoint := aws.StringValue(sess.Config.Endpoint); endpoint != "" {
+		options.BaseEndpoint = awsv2.String(endpoint)
+	}
+	return elasticloadbalancingv2.New(options)
+}
+
+// addObserveRequestMiddleware records the metrics of the aws-sdk-go-v2
+// requests, like observeRequest does for the aws-sdk-go ones
+func addObserveRequestMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("aws-nlb-helper/metrics",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+			middleware.InitializeOutput, middleware.Metadata, error) {
+
+			start := time.Now()
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			errorCode := ""
+			if err != nil {
+				errorCode = "Unknown"
+				var apiErr smithy.APIError
+				if errors.As(err, &apiErr) {
+					errorCode = apiErr.ErrorCode()
+				}
+			}
+			observeAPICall(elbv2.EndpointsID, awsmiddleware.GetOperationName(ctx), errorCode, start)
+			return out, metadata, err
+		}), middleware.After)
+}
+
+// getListenerAttributes returns the current attributes of a listener
+func (awsc *APIClient) getListenerAttributes(listenerARN string) (map[string]string, error) {
+
+	output, err := awsc.elbv2Listeners.DescribeListenerAttributes(context.Background(),
+		&elasticloadbalancingv2.DescribeListenerAttributesInput{ListenerArn: awsv2.String(listenerARN)},
+	)
+	if err != nil {
+		log.Error(err, "unable to describe listener attributes", "ListenerARN", listenerARN)
+		return nil, err
+	}
+
+	attributes := map[string]string{}
+	for _, a := range output.Attributes {
+		attributes[awsv2.ToString(a.Key)] = awsv2.ToString(a.Value)
+	}
+	return attributes, nil
+}
+
+// updateListenerAttributes modifies the attributes of a listener
+func (awsc *APIClient) updateListenerAttributes(listenerARN string, attributes []elbv2types.ListenerAttribute) error {
+
+	output, err := awsc.elbv2Listeners.ModifyListenerAttributes(context.Background(),
+		&elasticloadbalancingv2.ModifyListenerAttributesInput{
+			ListenerArn: awsv2.String(listenerARN),
+			Attributes:  attributes,
+		},
+	)
+	log.V(2).Info("Modify listener aws command output", "ModifyListenerAttributesOutput", output)
+	if err != nil {
+		log.Error(err, "unable to modify the listener attributes", "ListenerARN", listenerARN)
+		return err
+	}
+
+	log.Info("Listener updated", "ListenerARN", listenerARN)
+	return nil
+}
+
+// GetListenerAttributes discovers the attributes of the TCP listeners of the
+// load balancer. They are not discovered by GetNetworkLoadBalancer, only the
+// Services managing them need the elasticloadbalancing:DescribeListenerAttributes
+// permission.
+func (awsc *APIClient) GetListenerAttributes(nlb *NetworkLoadBalancer) error {
+	for i := range nlb.Listeners {
+		if nlb.Listeners[i].Protocol != ListenerProtocolTCP {
+			continue
+		}
+		attributes, err := awsc.getListenerAttributes(nlb.Listeners[i].ARN)
+		if err != nil {
+			return err
+		}
+		nlb.Listeners[i].Attributes = attributes
+	}
+	return nil
+}
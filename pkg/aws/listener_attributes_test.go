@@ -0,0 +1,80 @@
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func TestAPIClient_listenerAttributes(t *testing.T) {
+	const listenerARN = "arn:aws:elasticloadbalancing:us-east-1:000000000000:listener/net/lb/1/5432"
+
+	requests := []url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("unable to parse the request: %v", err)
+		}
+		requests = append(requests, r.PostForm)
+		action := r.PostForm.Get("Action")
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(`<` + action + `Response><` + action + `Result><Attributes><member>` +
+			`<Key>tcp.idle_timeout.seconds</Key><Value>350</Value>` +
+			`</member></Attributes></` + action + `Result></` + action + `Response>`))
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	awsc := &APIClient{elbv2: elbv2.New(sess), elbv2Listeners: newListenerAttributesClient(sess)}
+
+	nlb := &NetworkLoadBalancer{Listeners: []Listener{
+		{ARN: listenerARN, Port: 5432, Protocol: ListenerProtocolTCP},
+		{ARN: listenerARN + "3", Port: 443, Protocol: ListenerProtocolTLS},
+	}}
+	if err := awsc.GetListenerAttributes(nlb); err != nil {
+		t.Fatalf("GetListenerAttributes() error = %v", err)
+	}
+	if want := map[string]string{ListenerTCPIdleTimeoutKey: "350"}; !reflect.DeepEqual(nlb.Listeners[0].Attributes, want) {
+		t.Errorf("GetListenerAttributes() = %v, want %v", nlb.Listeners[0].Attributes, want)
+	}
+	if nlb.Listeners[1].Attributes != nil {
+		t.Errorf("GetListenerAttributes() TLS listener = %v, want nil", nlb.Listeners[1].Attributes)
+	}
+
+	if err := awsc.ApplyAttributeChanges([]AttributeChange{{
+		ResourceARN: listenerARN, ResourceType: listenerResourceType,
+		Key: ListenerTCPIdleTimeoutKey, Current: "350", Desired: "3600",
+	}}); err != nil {
+		t.Fatalf("ApplyAttributeChanges() error = %v", err)
+	}
+
+	want := []map[string]string{
+		{"Action": "DescribeListenerAttributes", "ListenerArn": listenerARN},
+		{
+			"Action":                    "ModifyListenerAttributes",
+			"ListenerArn":               listenerARN,
+			"Attributes.member.1.Key":   ListenerTCPIdleTimeoutKey,
+			"Attributes.member.1.Value": "3600",
+		},
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(requests), len(want))
+	}
+	for i, params := range want {
+		for key, value := range params {
+			if got := requests[i].Get(key); got != value {
+				t.Errorf("request %d %s = %q, want %q", i, key, got, value)
+			}
+		}
+	}
+}
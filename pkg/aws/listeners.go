@@ -0,0 +1,453 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const (
+	// ListenerProtocolTLS is the protocol of the listeners terminating TLS
+	ListenerProtocolTLS = elbv2.ProtocolEnumTls
+
+	// certificateARNPrefix, certificateDomainPrefix and certificateTagPrefix
+	// are the prefixes of the certificate references
+	certificateARNPrefix    = "arn:"
+	certificateDomainPrefix = "domain:"
+	certificateTagPrefix    = "tag:"
+
+	// certificateCacheTTL is how long the domain and tag certificate
+	// references stay resolved
+	certificateCacheTTL = 5 * time.Minute
+)
+
+// AlpnPolicies are the ALPN policies accepted by the TLS listeners
+var AlpnPolicies = []string{"HTTP1Only", "HTTP2Only", "HTTP2Optional", "HTTP2Preferred", "None"}
+
+// Listener holds the discovered state of a network load balancer listener,
+// the certificates being only discovered for the TLS listeners.
+type Listener struct {
+	ARN                string
+	Port               int64
+	Protocol           string
+	SslPolicy          string
+	AlpnPolicy         string
+	DefaultCertificate string
+	// Certificates are the additional SNI certificates, sorted
+	Certificates []string
+	// Attributes are only discovered for the TCP listeners, by
+	// GetListenerAttributes
+	Attributes map[string]string
+}
+
+// ListenerSettings are the desired settings of the TLS listeners, empty
+// values being left as they are. The first certificate is the default one,
+// the others are the SNI certificates.
+type ListenerSettings struct {
+	Certificates []string
+	SslPolicy    string
+	AlpnPolicy   string
+}
+
+// ListenerChange describes the changes to apply to a TLS listener, the empty
+// values being left as they are
+type ListenerChange struct {
+	ListenerARN        string
+	Port               int64
+	DefaultCertificate string
+	SslPolicy          string
+	AlpnPolicy         string
+	AddCertificates    []string
+	RemoveCertificates []string
+}
+
+// String returns a human readable representation of the change, like
+// `listener/net/name/id/id port 443: ssl-policy=ELBSecurityPolicy-TLS13-1-2-2021-06 +certificate/id`.
+func (c ListenerChange) String() string {
+	changes := []string{}
+	if c.DefaultCertificate != "" {
+		changes = append(changes, fmt.Sprintf("default-certificate=%s", resourceName(c.DefaultCertificate)))
+	}
+	if c.SslPolicy != "" {
+		changes = append(changes, fmt.Sprintf("ssl-policy=%s", c.SslPolicy))
+	}
+	if c.AlpnPolicy != "" {
+		changes = append(changes, fmt.Sprintf("alpn-policy=%s", c.AlpnPolicy))
+	}
+	for _, arn := range c.AddCertificates {
+		changes = append(changes, fmt.Sprintf("+%s", resourceName(arn)))
+	}
+	for _, arn := range c.RemoveCertificates {
+		changes = append(changes, fmt.Sprintf("-%s", resourceName(arn)))
+	}
+	return fmt.Sprintf("%s port %d: %s", resourceName(c.ListenerARN), c.Port, strings.Join(changes, " "))
+}
+
+// PlanListenerChanges compares the TLS listeners of the network load
+// balancer whose port is listed, all of them if ports is nil, with the
+// desired settings, returning the list of changes needed to reconcile them.
+// The SNI certificates not listed in the desired settings are removed.
+func (nlb *NetworkLoadBalancer) PlanListenerChanges(
+	ports map[int64]bool, desired ListenerSettings) []ListenerChange {
+
+	changes := []ListenerChange{}
+	for _, listener := range nlb.Listeners {
+		if listener.Protocol != ListenerProtocolTLS || (ports != nil && !ports[listener.Port]) {
+			continue
+		}
+
+		change := ListenerChange{ListenerARN: listener.ARN, Port: listener.Port}
+		if desired.SslPolicy != "" && desired.SslPolicy != listener.SslPolicy {
+			change.SslPolicy = desired.SslPolicy
+		}
+		if desired.AlpnPolicy != "" && desired.AlpnPolicy != listener.AlpnPolicy {
+			change.AlpnPolicy = desired.AlpnPolicy
+		}
+		if len(desired.Certificates) > 0 {
+			if desired.Certificates[0] != listener.DefaultCertificate {
+				change.DefaultCertificate = desired.Certificates[0]
+			}
+			current := map[string]bool{}
+			for _, arn := range listener.Certificates {
+				current[arn] = true
+			}
+			sni := map[string]bool{}
+			for _, arn := range desired.Certificates[1:] {
+				if arn == desired.Certificates[0] || sni[arn] {
+					continue
+				}
+				sni[arn] = true
+				if !current[arn] {
+					change.AddCertificates = append(change.AddCertificates, arn)
+				}
+			}
+			for _, arn := range listener.Certificates {
+				if !sni[arn] {
+					change.RemoveCertificates = append(change.RemoveCertificates, arn)
+				}
+			}
+		}
+
+		if change.DefaultCertificate != "" || change.SslPolicy != "" || change.AlpnPolicy != "" ||
+			len(change.AddCertificates) > 0 || len(change.RemoveCertificates) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// ApplyListenerChanges applies the listener changes, the default certificate
+// and policies with ModifyListener, the SNI certificates with
+// AddListenerCertificates and RemoveListenerCertificates.
+func (awsc *APIClient) ApplyListenerChanges(changes []ListenerChange) error {
+	for _, change := range changes {
+		if change.DefaultCertificate != "" || change.SslPolicy != "" || change.AlpnPolicy != "" {
+			input := &elbv2.ModifyListenerInput{ListenerArn: aws.String(change.ListenerARN)}
+			if change.DefaultCertificate != "" {
+				input.Certificates = []*elbv2.Certificate{{CertificateArn: aws.String(change.DefaultCertificate)}}
+			}
+			if change.SslPolicy != "" {
+				input.SslPolicy = aws.String(change.SslPolicy)
+			}
+			if change.AlpnPolicy != "" {
+				input.AlpnPolicy = aws.StringSlice([]string{change.AlpnPolicy})
+			}
+			if _, err := awsc.elbv2.ModifyListener(input); err != nil {
+				log.Error(err, "unable to modify the listener", "ListenerARN", change.ListenerARN)
+				return err
+			}
+		}
+		if len(change.AddCertificates) > 0 {
+			if _, err := awsc.elbv2.AddListenerCertificates(&elbv2.AddListenerCertificatesInput{
+				ListenerArn:  aws.String(change.ListenerARN),
+				Certificates: listenerCertificates(change.AddCertificates),
+			}); err != nil {
+				log.Error(err, "unable to add the listener certificates", "ListenerARN", change.ListenerARN)
+				return err
+			}
+		}
+		if len(change.RemoveCertificates) > 0 {
+			if _, err := awsc.elbv2.RemoveListenerCertificates(&elbv2.RemoveListenerCertificatesInput{
+				ListenerArn:  aws.String(change.ListenerARN),
+				Certificates: listenerCertificates(change.RemoveCertificates),
+			}); err != nil {
+				log.Error(err, "unable to remove the listener certificates", "ListenerARN", change.ListenerARN)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listenerCertificates returns the elbv2 certificates of the ARNs
+func listenerCertificates(arns []string) []*elbv2.Certificate {
+	certificates := make([]*elbv2.Certificate, 0, len(arns))
+	for _, arn := range arns {
+		certificates = append(certificates, &elbv2.Certificate{CertificateArn: aws.String(arn)})
+	}
+	return certificates
+}
+
+// getListeners returns the listeners of the load balancer, along with the
+// certificates of the TLS listeners
+func (awsc *APIClient) getListeners(elbARN string) ([]Listener, error) {
+
+	listeners := []Listener{}
+	err := awsc.elbv2.DescribeListenersPages(&elbv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(elbARN),
+	}, func(page *elbv2.DescribeListenersOutput, lastPage bool) bool {
+		for _, l := range page.Listeners {
+			listener := Listener{
+				ARN:       aws.StringValue(l.ListenerArn),
+				Port:      aws.Int64Value(l.Port),
+				Protocol:  aws.StringValue(l.Protocol),
+				SslPolicy: aws.StringValue(l.SslPolicy),
+			}
+			if len(l.AlpnPolicy) > 0 {
+				listener.AlpnPolicy = aws.StringValue(l.AlpnPolicy[0])
+			}
+			listeners = append(listeners, listener)
+		}
+		return true
+	})
+	if err != nil {
+		log.Error(err, "unable to describe the load balancer listeners", "LoadBalancerARN", elbARN)
+		return nil, err
+	}
+
+	for i := range listeners {
+		switch listeners[i].Protocol {
+		case ListenerProtocolTLS:
+			if err := awsc.getListenerCertificates(&listeners[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return listeners, nil
+}
+
+// getListenerCertificates discovers the default and SNI certificates of the
+// listener
+func (awsc *APIClient) getListenerCertificates(listener *Listener) error {
+
+	input := &elbv2.DescribeListenerCertificatesInput{ListenerArn: aws.String(listener.ARN)}
+	for {
+		dlco, err := awsc.elbv2.DescribeListenerCertificates(input)
+		if err != nil {
+			log.Error(err, "unable to describe the listener certificates", "ListenerARN", listener.ARN)
+			return err
+		}
+		for _, certificate := range dlco.Certificates {
+			if aws.BoolValue(certificate.IsDefault) {
+				listener.DefaultCertificate = aws.StringValue(certificate.CertificateArn)
+				continue
+			}
+			listener.Certificates = append(listener.Certificates, aws.StringValue(certificate.CertificateArn))
+		}
+		if aws.StringValue(dlco.NextMarker) == "" {
+			break
+		}
+		input.Marker = dlco.NextMarker
+	}
+	sort.Strings(listener.Certificates)
+	return nil
+}
+
+// ValidateCertificateReference returns an error if the certificate reference
+// is neither an ARN, a `domain:<name>` nor a `tag:<key>=<value>` reference
+func ValidateCertificateReference(ref string) error {
+	switch {
+	case strings.HasPrefix(ref, certificateARNPrefix):
+		return nil
+	case strings.HasPrefix(ref, certificateDomainPrefix) && len(ref) > len(certificateDomainPrefix):
+		return nil
+	case strings.HasPrefix(ref, certificateTagPrefix):
+		if key, _, ok := splitTag(strings.TrimPrefix(ref, certificateTagPrefix)); ok && key != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid certificate %q, expected an ARN, %s<name> or %s<key>=<value>",
+		ref, certificateDomainPrefix, certificateTagPrefix,
+	)
+}
+
+// ResolveCertificates returns the ARNs of the referenced ACM certificates. A
+// `domain:<name>` reference resolves to the issued certificate of the domain
+// expiring last, a `tag:<key>=<value>` reference to all the issued
+// certificates with the tag, sorted by ARN.
+func (awsc *APIClient) ResolveCertificates(refs []string) ([]string, error) {
+
+	arns := []string{}
+	var issued []*acm.CertificateSummary
+	for _, ref := range refs {
+		if err := ValidateCertificateReference(ref); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(ref, certificateARNPrefix) {
+			arns = append(arns, ref)
+			continue
+		}
+		if resolved, ok := awsc.certificates.get(ref); ok {
+			arns = append(arns, resolved...)
+			continue
+		}
+
+		if issued == nil {
+			var err error
+			if issued, err = awsc.listIssuedCertificates(); err != nil {
+				return nil, err
+			}
+		}
+
+		var resolved []string
+		var err error
+		if strings.HasPrefix(ref, certificateDomainPrefix) {
+			resolved, err = awsc.resolveCertificateDomain(issued, strings.TrimPrefix(ref, certificateDomainPrefix))
+		} else {
+			key, value, _ := splitTag(strings.TrimPrefix(ref, certificateTagPrefix))
+			resolved, err = awsc.resolveCertificateTag(issued, key, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(resolved) == 0 {
+			return nil, fmt.Errorf("no issued ACM certificate matches %q", ref)
+		}
+		awsc.certificates.set(ref, resolved)
+		arns = append(arns, resolved...)
+	}
+	return arns, nil
+}
+
+// certificateCache caches the ARNs of the resolved certificate references
+// for certificateCacheTTL, not to describe all the issued certificates on
+// every reconcile
+type certificateCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedCertificates
+}
+
+// cachedCertificates are the ARNs of a reference, cached until they expire
+type cachedCertificates struct {
+	arns    []string
+	expires time.Time
+}
+
+// get returns the cached ARNs of the reference, and false if missing or
+// expired
+func (c *certificateCache) get(ref string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[ref]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.arns, true
+}
+
+// set caches the ARNs of the reference
+func (c *certificateCache) set(ref string, arns []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]cachedCertificates{}
+	}
+	c.entries[ref] = cachedCertificates{arns: arns, expires: time.Now().Add(certificateCacheTTL)}
+}
+
+// reset drops the cached references, as a certificate they resolve to may
+// have been deleted
+func (c *certificateCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+// listIssuedCertificates returns the issued ACM certificates usable by the
+// TLS listeners
+func (awsc *APIClient) listIssuedCertificates() ([]*acm.CertificateSummary, error) {
+	certificates := []*acm.CertificateSummary{}
+	err := awsc.acm.ListCertificatesPages(&acm.ListCertificatesInput{
+		CertificateStatuses: aws.StringSlice([]string{acm.CertificateStatusIssued}),
+		Includes: &acm.Filters{KeyTypes: aws.StringSlice([]string{
+			acm.KeyAlgorithmRsa2048, acm.KeyAlgorithmRsa3072, acm.KeyAlgorithmRsa4096,
+			acm.KeyAlgorithmEcPrime256v1, acm.KeyAlgorithmEcSecp384r1, acm.KeyAlgorithmEcSecp521r1,
+		})},
+	}, func(page *acm.ListCertificatesOutput, lastPage bool) bool {
+		certificates = append(certificates, page.CertificateSummaryList...)
+		return true
+	})
+	if err != nil {
+		log.Error(err, "unable to list the ACM certificates")
+		return nil, err
+	}
+	return certificates, nil
+}
+
+// resolveCertificateDomain returns the ARN of the issued certificate of the
+// domain expiring last
+func (awsc *APIClient) resolveCertificateDomain(issued []*acm.CertificateSummary, domain string) ([]string, error) {
+	arn := ""
+	var notAfter int64
+	for _, summary := range issued {
+		if aws.StringValue(summary.DomainName) != domain {
+			continue
+		}
+		dco, err := awsc.acm.DescribeCertificate(&acm.DescribeCertificateInput{
+			CertificateArn: summary.CertificateArn,
+		})
+		if err != nil {
+			log.Error(err, "unable to describe the ACM certificate",
+				"CertificateARN", aws.StringValue(summary.CertificateArn),
+			)
+			return nil, err
+		}
+		if expiry := aws.TimeValue(dco.Certificate.NotAfter).Unix(); arn == "" || expiry > notAfter {
+			arn, notAfter = aws.StringValue(summary.CertificateArn), expiry
+		}
+	}
+	if arn == "" {
+		return nil, nil
+	}
+	return []string{arn}, nil
+}
+
+// resolveCertificateTag returns the ARNs of the issued certificates with the
+// tag, sorted
+func (awsc *APIClient) resolveCertificateTag(issued []*acm.CertificateSummary, key, value string) ([]string, error) {
+	arns := []string{}
+	for _, summary := range issued {
+		ltfco, err := awsc.acm.ListTagsForCertificate(&acm.ListTagsForCertificateInput{
+			CertificateArn: summary.CertificateArn,
+		})
+		if err != nil {
+			log.Error(err, "unable to list the ACM certificate tags",
+				"CertificateARN", aws.StringValue(summary.CertificateArn),
+			)
+			return nil, err
+		}
+		for _, tag := range ltfco.Tags {
+			if aws.StringValue(tag.Key) == key && aws.StringValue(tag.Value) == value {
+				arns = append(arns, aws.StringValue(summary.CertificateArn))
+				break
+			}
+		}
+	}
+	sort.Strings(arns)
+	return arns, nil
+}
+
+// splitTag splits a `key=value` tag
+func splitTag(tag string) (string, string, bool) {
+	i := strings.Index(tag, "=")
+	if i < 0 {
+		return "", "", false
+	}
+	return tag[:i], tag[i+1:], true
+}
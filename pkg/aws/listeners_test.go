@@ -0,0 +1,142 @@
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+)
+
+func TestNetworkLoadBalancer_PlanListenerChanges(t *testing.T) {
+	const (
+		certA = "arn:aws:acm:us-east-1:000000000000:certificate/a"
+		certB = "arn:aws:acm:us-east-1:000000000000:certificate/b"
+		certC = "arn:aws:acm:us-east-1:000000000000:certificate/c"
+	)
+	nlb := &NetworkLoadBalancer{Listeners: []Listener{
+		{
+			ARN:                "arn:aws:elasticloadbalancing:us-east-1:000000000000:listener/net/lb/1/443",
+			Port:               443,
+			Protocol:           "TLS",
+			SslPolicy:          "ELBSecurityPolicy-2016-08",
+			AlpnPolicy:         "None",
+			DefaultCertificate: certA,
+			Certificates:       []string{certB},
+		},
+		{
+			ARN:      "arn:aws:elasticloadbalancing:us-east-1:000000000000:listener/net/lb/1/80",
+			Port:     80,
+			Protocol: "TCP",
+		},
+	}}
+	tests := []struct {
+		name    string
+		ports   map[int64]bool
+		desired ListenerSettings
+		want    []string
+	}{
+		{
+			name:    "up to date",
+			desired: ListenerSettings{Certificates: []string{certA, certB}, SslPolicy: "ELBSecurityPolicy-2016-08"},
+			want:    []string{},
+		},
+		{
+			name: "policies and certificates",
+			desired: ListenerSettings{
+				Certificates: []string{certC, certA},
+				SslPolicy:    "ELBSecurityPolicy-TLS13-1-2-2021-06",
+				AlpnPolicy:   "HTTP2Preferred",
+			},
+			want: []string{
+				"listener/net/lb/1/443 port 443: default-certificate=certificate/c " +
+					"ssl-policy=ELBSecurityPolicy-TLS13-1-2-2021-06 alpn-policy=HTTP2Preferred " +
+					"+certificate/a -certificate/b",
+			},
+		},
+		{
+			name:    "port not listed",
+			ports:   map[int64]bool{8443: true},
+			desired: ListenerSettings{SslPolicy: "ELBSecurityPolicy-TLS13-1-2-2021-06"},
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, change := range nlb.PlanListenerChanges(tt.ports, tt.desired) {
+				got = append(got, change.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanListenerChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCertificateReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		wantErr bool
+	}{
+		{ref: "arn:aws:acm:us-east-1:000000000000:certificate/a"},
+		{ref: "domain:example.com"},
+		{ref: "tag:team=web"},
+		{ref: "tag:team="},
+		{ref: "domain:", wantErr: true},
+		{ref: "tag:team", wantErr: true},
+		{ref: "example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			if err := ValidateCertificateReference(tt.ref); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCertificateReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIClient_ResolveCertificates_cached(t *testing.T) {
+	const cert = "arn:aws:acm:us-east-1:000000000000:certificate/a"
+
+	actions := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "CertificateManager.")
+		actions = append(actions, action)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch action {
+		case "ListCertificates":
+			_, _ = w.Write([]byte(`{"CertificateSummaryList":[{"CertificateArn":"` + cert +
+				`","DomainName":"example.com"}]}`))
+		case "DescribeCertificate":
+			_, _ = w.Write([]byte(`{"Certificate":{"CertificateArn":"` + cert + `","NotAfter":1700000000}}`))
+		}
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	awsc := &APIClient{acm: acm.New(sess)}
+
+	for i := 0; i < 2; i++ {
+		got, err := awsc.ResolveCertificates([]string{"domain:example.com"})
+		if err != nil {
+			t.Fatalf("ResolveCertificates() error = %v", err)
+		}
+		if want := []string{cert}; !reflect.DeepEqual(got, want) {
+			t.Errorf("ResolveCertificates() = %v, want %v", got, want)
+		}
+	}
+	// the second resolution is served from the cache
+	if want := []string{"ListCertificates", "DescribeCertificate"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("ACM requests = %v, want %v", actions, want)
+	}
+}
@@ -0,0 +1,87 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+)
+
+const (
+	awsClusterTagKeyFormat = "kubernetes.io/cluster/%s"
+)
+
+// ServiceLoadBalancer is a network load balancer created for a Kubernetes
+// Service, as identified by the `kubernetes.io/service-name` tag.
+type ServiceLoadBalancer struct {
+	ARN     string
+	Service string
+	Tags    map[string]string
+}
+
+// ErrClusterIDRequired is returned when listing the Service load balancers
+// without a cluster ID, which would return the load balancers of all the
+// clusters of the account and region
+var ErrClusterIDRequired = fmt.Errorf("a cluster ID is required to list the service load balancers")
+
+// ListServiceLoadBalancers returns the network load balancers of the cluster,
+// tagged with `kubernetes.io/cluster/<clusterID>` and a
+// `kubernetes.io/service-name`.
+func (awsc *APIClient) ListServiceLoadBalancers(clusterID string) ([]ServiceLoadBalancer, error) {
+
+	if clusterID == "" {
+		return nil, ErrClusterIDRequired
+	}
+	gri := resourcegroupstaggingapi.GetResourcesInput{
+		TagFilters: []*resourcegroupstaggingapi.TagFilter{
+			{Key: aws.String(awsServiceNameTagKey)},
+			{Key: aws.String(fmt.Sprintf(awsClusterTagKeyFormat, clusterID))},
+		},
+		ResourceTypeFilters: []*string{aws.String(awsNetworkLoadBalancerResourceTypeFilter)},
+	}
+
+	loadBalancers := []ServiceLoadBalancer{}
+	err := awsc.rgtapi.GetResourcesPages(&gri,
+		func(page *resourcegroupstaggingapi.GetResourcesOutput, lastPage bool) bool {
+			for _, resource := range page.ResourceTagMappingList {
+				tags := map[string]string{}
+				for _, t := range resource.Tags {
+					tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+				}
+				loadBalancers = append(loadBalancers, ServiceLoadBalancer{
+					ARN:     aws.StringValue(resource.ResourceARN),
+					Service: tags[awsServiceNameTagKey],
+					Tags:    tags,
+				})
+			}
+			return true
+		})
+	if err != nil {
+		log.Error(err, "unable to list the service load balancers", "ClusterID", clusterID)
+		return nil, err
+	}
+
+	return loadBalancers, nil
+}
+
+// IsDeletionProtected returns true if the load balancer has the deletion
+// protection enabled.
+func (awsc *APIClient) IsDeletionProtected(nlbARN string) (bool, error) {
+	attributes, err := awsc.getLoadBalancerAttributes(nlbARN)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(attributes[LoadBalancerDeletionProtectionKey])
+}
+
+// DisableDeletionProtection disables the deletion protection of a load
+// balancer.
+func (awsc *APIClient) DisableDeletionProtection(nlbARN string) error {
+	return awsc.ApplyAttributeChanges([]AttributeChange{{
+		ResourceARN:  nlbARN,
+		ResourceType: loadBalancerResourceType,
+		Key:          LoadBalancerDeletionProtectionKey,
+		Desired:      strconv.FormatBool(false),
+	}})
+}
@@ -0,0 +1,165 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	iamPolicyVersion = "2012-10-17"
+	// simulatePolicyMaxActions is the maximum number of actions accepted by a
+	// single SimulatePrincipalPolicy call
+	simulatePolicyMaxActions = 128
+)
+
+// Features lists the operator features requiring IAM permissions beyond the
+// read only discovery of the load balancers.
+type Features struct {
+	// DryRun only plans the changes, no modify permission is needed
+	DryRun bool
+	// RemoveDeletionProtection disables the deletion protection of the
+	// orphaned load balancers, only planned in dry run mode
+	RemoveDeletionProtection bool
+	// Preflight checks the permissions at startup
+	Preflight bool
+	// DeregisterDrainingNodes deregisters the draining nodes from the
+	// target groups of the managed load balancers
+	DeregisterDrainingNodes bool
+}
+
+// RequiredActions returns the sorted list of IAM actions needed by the
+// enabled features.
+func RequiredActions(f Features) []string {
+	actions := map[string]bool{
+		// load balancers discovery and orphaned load balancers scans
+		"tag:GetResources":                                    true,
+		"elasticloadbalancing:DescribeLoadBalancers":          true,
+		"elasticloadbalancing:DescribeLoadBalancerAttributes": true,
+		"elasticloadbalancing:DescribeTargetGroups":           true,
+		"elasticloadbalancing:DescribeTargetGroupAttributes":  true,
+		"elasticloadbalancing:DescribeListenerAttributes":     true,
+		"elasticloadbalancing:DescribeTags":                   true,
+		// target health readiness gates and reporting
+		"elasticloadbalancing:DescribeTargetHealth": true,
+		// TLS listeners and their ACM certificates
+		"elasticloadbalancing:DescribeListeners":            true,
+		"elasticloadbalancing:DescribeListenerCertificates": true,
+		"acm:ListCertificates":                              true,
+		"acm:DescribeCertificate":                           true,
+		"acm:ListTagsForCertificate":                        true,
+		// security groups and subnets of the load balancers
+		"ec2:DescribeSecurityGroups": true,
+		"ec2:DescribeSubnets":        true,
+	}
+	if !f.DryRun {
+		// attributes, ownership tags and user defined tags
+		actions["elasticloadbalancing:ModifyLoadBalancerAttributes"] = true
+		actions["elasticloadbalancing:ModifyTargetGroupAttributes"] = true
+		actions["elasticloadbalancing:ModifyListenerAttributes"] = true
+		actions["elasticloadbalancing:AddTags"] = true
+		actions["elasticloadbalancing:RemoveTags"] = true
+		// TLS listeners
+		actions["elasticloadbalancing:ModifyListener"] = true
+		actions["elasticloadbalancing:AddListenerCertificates"] = true
+		actions["elasticloadbalancing:RemoveListenerCertificates"] = true
+		// certificates imported from the TLS Secrets
+		actions["acm:ImportCertificate"] = true
+		actions["acm:AddTagsToCertificate"] = true
+		actions["acm:DeleteCertificate"] = true
+		// security groups and the managed security groups
+		actions["elasticloadbalancing:SetSecurityGroups"] = true
+		actions["ec2:CreateSecurityGroup"] = true
+		actions["ec2:CreateTags"] = true
+		actions["ec2:AuthorizeSecurityGroupIngress"] = true
+		actions["ec2:RevokeSecurityGroupIngress"] = true
+		actions["ec2:DeleteSecurityGroup"] = true
+		// IP address type
+		actions["elasticloadbalancing:SetIpAddressType"] = true
+	}
+	if f.RemoveDeletionProtection && !f.DryRun {
+		actions["elasticloadbalancing:ModifyLoadBalancerAttributes"] = true
+	}
+	if f.DeregisterDrainingNodes && !f.DryRun {
+		actions["elasticloadbalancing:DeregisterTargets"] = true
+		actions["elasticloadbalancing:RegisterTargets"] = true
+	}
+	if f.Preflight {
+		actions["iam:SimulatePrincipalPolicy"] = true
+	}
+
+	list := make([]string, 0, len(actions))
+	for action := range actions {
+		list = append(list, action)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// PolicyDocument returns an IAM policy document allowing the actions
+func PolicyDocument(actions []string) ([]byte, error) {
+	return json.MarshalIndent(map[string]interface{}{
+		"Version": iamPolicyVersion,
+		"Statement": []map[string]interface{}{{
+			"Effect":   "Allow",
+			"Action":   actions,
+			"Resource": "*",
+		}},
+	}, "", "  ")
+}
+
+// MissingActions simulates the policies of the AWS principal used by the
+// client and returns the actions that are not allowed.
+func (awsc *APIClient) MissingActions(actions []string) ([]string, error) {
+
+	identity, err := awsc.sts.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the AWS caller identity: %w", err)
+	}
+	principal := principalARN(aws.StringValue(identity.Arn))
+
+	missing := []string{}
+	for start := 0; start < len(actions); start += simulatePolicyMaxActions {
+		end := start + simulatePolicyMaxActions
+		if end > len(actions) {
+			end = len(actions)
+		}
+
+		err := awsc.iam.SimulatePrincipalPolicyPages(&iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(principal),
+			ActionNames:     aws.StringSlice(actions[start:end]),
+		}, func(page *iam.SimulatePolicyResponse, lastPage bool) bool {
+			for _, result := range page.EvaluationResults {
+				if aws.StringValue(result.EvalDecision) != iam.PolicyEvaluationDecisionTypeAllowed {
+					missing = append(missing, aws.StringValue(result.EvalActionName))
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to simulate the policies of %s: %w", principal, err)
+		}
+	}
+
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// principalARN returns the IAM ARN of the principal, converting the STS
+// assumed role ARNs, like `arn:aws:sts::<account>:assumed-role/<role>/<session>`,
+// to the ARN of the role, like `arn:aws:iam::<account>:role/<role>`. The role
+// path is not part of the assumed role ARN, so roles with a path other than
+// `/` can't be simulated.
+func principalARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "sts" || !strings.HasPrefix(parts[5], "assumed-role/") {
+		return arn
+	}
+	role := strings.Split(strings.TrimPrefix(parts[5], "assumed-role/"), "/")[0]
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], role)
+}
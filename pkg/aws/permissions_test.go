@@ -0,0 +1,63 @@
+package aws
+
+import "testing"
+
+func Test_principalARN(t *testing.T) {
+	tests := []struct {
+		name string
+		arn  string
+		want string
+	}{
+		{
+			name: "assumed role",
+			arn:  "arn:aws:sts::000000000000:assumed-role/aws-nlb-helper/session",
+			want: "arn:aws:iam::000000000000:role/aws-nlb-helper",
+		},
+		{
+			name: "user",
+			arn:  "arn:aws:iam::000000000000:user/aws-nlb-helper",
+			want: "arn:aws:iam::000000000000:user/aws-nlb-helper",
+		},
+		{
+			name: "other partition",
+			arn:  "arn:aws-cn:sts::000000000000:assumed-role/aws-nlb-helper/session",
+			want: "arn:aws-cn:iam::000000000000:role/aws-nlb-helper",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := principalARN(tt.arn); got != tt.want {
+				t.Errorf("principalARN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredActions(t *testing.T) {
+	const modify = "elasticloadbalancing:ModifyLoadBalancerAttributes"
+	tests := []struct {
+		name     string
+		features Features
+		want     bool
+	}{
+		{name: "read only", features: Features{DryRun: true}, want: false},
+		{name: "reconcile", features: Features{}, want: true},
+		{
+			name:     "deletion protection removal in dry run",
+			features: Features{DryRun: true, RemoveDeletionProtection: true},
+			want:     false,
+		},
+		{name: "deletion protection removal", features: Features{RemoveDeletionProtection: true}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := false
+			for _, action := range RequiredActions(tt.features) {
+				got = got || action == modify
+			}
+			if got != tt.want {
+				t.Errorf("RequiredActions() requires %s = %t, want %t", modify, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,517 @@
+package aws
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const (
+	// securityGroupIDPrefix and securityGroupTagPrefix are the prefixes of
+	// the security group references
+	securityGroupIDPrefix  = "sg-"
+	securityGroupTagPrefix = "tag:"
+
+	// managedSecurityGroupNameFormat is the name of the security group
+	// created for a Service, from the helper instance and the Service
+	managedSecurityGroupNameFormat = "aws-nlb-helper/%s/%s"
+	managedSecurityGroupMaxName    = 255
+)
+
+// ErrSecurityGroupsNotSupported is returned when planning security groups
+// for a load balancer created without any, which can't have them added later
+var ErrSecurityGroupsNotSupported = fmt.Errorf(
+	"the load balancer was created without security groups, they can't be added later",
+)
+
+// SecurityGroupRule is an inbound rule of the managed security group
+type SecurityGroupRule struct {
+	Protocol string
+	FromPort int64
+	ToPort   int64
+	CIDR     string
+}
+
+// String returns a human readable representation of the rule, like
+// `tcp/443 from 10.0.0.0/8`
+func (r SecurityGroupRule) String() string {
+	ports := fmt.Sprintf("%d", r.FromPort)
+	if r.FromPort != r.ToPort {
+		ports = fmt.Sprintf("%d-%d", r.FromPort, r.ToPort)
+	}
+	return fmt.Sprintf("%s/%s from %s", r.Protocol, ports, r.CIDR)
+}
+
+// ManagedSecurityGroup is the security group created by the helper for a
+// Service
+type ManagedSecurityGroup struct {
+	ID      string
+	Service string
+	Rules   []SecurityGroupRule
+}
+
+// SecurityGroupSettings are the desired security groups of a network load
+// balancer. The security groups are left as they are if none is listed and
+// the managed security group is disabled.
+type SecurityGroupSettings struct {
+	// SecurityGroups are the IDs of the existing security groups
+	SecurityGroups []string
+	// Managed adds the managed security group, with the Rules inbound rules
+	Managed bool
+	Rules   []SecurityGroupRule
+	// EnforcePrivateLink is the private link inbound rules enforcement,
+	// `on` or `off`, left as it is if empty
+	EnforcePrivateLink string
+}
+
+// SecurityGroupChanges describes the changes to the security groups of a
+// network load balancer, and to its managed security group
+type SecurityGroupChanges struct {
+	LoadBalancerARN string
+	// CreateManaged creates the managed security group, added to the
+	// SecurityGroups once created
+	CreateManaged bool
+	ManagedID     string
+	Authorize     []SecurityGroupRule
+	Revoke        []SecurityGroupRule
+	// SecurityGroups are the desired security groups of the load balancer,
+	// nil if they are left as they are
+	SecurityGroups     []string
+	EnforcePrivateLink string
+	// DeleteManaged deletes the managed security group once detached
+	DeleteManaged bool
+
+	// current are the security groups of the load balancer, set again when
+	// only the private link setting changes
+	current []string
+}
+
+// IsEmpty returns true if there is nothing to change
+func (c SecurityGroupChanges) IsEmpty() bool {
+	return !c.CreateManaged && !c.DeleteManaged && c.SecurityGroups == nil &&
+		c.EnforcePrivateLink == "" && len(c.Authorize) == 0 && len(c.Revoke) == 0
+}
+
+// String returns a human readable representation of the changes, like
+// `loadbalancer/net/name/id security-groups=[sg-1 <managed>] +tcp/443 from 0.0.0.0/0`.
+func (c SecurityGroupChanges) String() string {
+	changes := []string{}
+	if c.CreateManaged {
+		changes = append(changes, "create managed security group")
+	}
+	if c.SecurityGroups != nil {
+		groups := c.SecurityGroups
+		if c.CreateManaged {
+			groups = append(append([]string{}, groups...), "<managed>")
+		}
+		changes = append(changes, fmt.Sprintf("security-groups=%v", groups))
+	}
+	if c.EnforcePrivateLink != "" {
+		changes = append(changes, fmt.Sprintf("enforce-private-link=%s", c.EnforcePrivateLink))
+	}
+	for _, rule := range c.Authorize {
+		changes = append(changes, fmt.Sprintf("+%s", rule))
+	}
+	for _, rule := range c.Revoke {
+		changes = append(changes, fmt.Sprintf("-%s", rule))
+	}
+	if c.DeleteManaged {
+		changes = append(changes, fmt.Sprintf("delete managed security group %s", c.ManagedID))
+	}
+	return fmt.Sprintf("%s %s", resourceName(c.LoadBalancerARN), strings.Join(changes, " "))
+}
+
+// PlanSecurityGroupChanges compares the security groups of the network load
+// balancer and its managed security group, nil if it doesn't exist, with the
+// desired settings, returning the changes needed to reconcile them. The
+// managed security group is deleted when it is no longer desired, unless it
+// is the last security group of the load balancer, as a load balancer created
+// with security groups must keep at least one.
+func (nlb *NetworkLoadBalancer) PlanSecurityGroupChanges(
+	desired SecurityGroupSettings, managed *ManagedSecurityGroup) (SecurityGroupChanges, error) {
+
+	changes := SecurityGroupChanges{LoadBalancerARN: nlb.ARN, current: nlb.SecurityGroups}
+	managing := len(desired.SecurityGroups) > 0 || desired.Managed
+	if len(nlb.SecurityGroups) == 0 {
+		if managing || desired.EnforcePrivateLink != "" {
+			return changes, ErrSecurityGroupsNotSupported
+		}
+		return changes, nil
+	}
+
+	// without a security groups list, the managed security group is added to
+	// the current ones
+	base := desired.SecurityGroups
+	if len(base) == 0 {
+		base = nlb.SecurityGroups
+	}
+
+	var groups []string
+	switch {
+	case desired.Managed && managed == nil:
+		changes.CreateManaged = true
+		changes.Authorize = desired.Rules
+		groups = base
+	case desired.Managed:
+		changes.ManagedID = managed.ID
+		changes.Authorize, changes.Revoke = diffSecurityGroupRules(managed.Rules, desired.Rules)
+		groups = append(append([]string{}, base...), managed.ID)
+	case managed != nil:
+		changes.ManagedID = managed.ID
+		changes.DeleteManaged = true
+		groups = desired.SecurityGroups
+		if !managing {
+			groups = removeString(nlb.SecurityGroups, managed.ID)
+		}
+		if len(groups) == 0 {
+			return SecurityGroupChanges{LoadBalancerARN: nlb.ARN}, fmt.Errorf(
+				"the managed security group %s is the last security group of the load balancer, "+
+					"it can't be detached", managed.ID,
+			)
+		}
+	default:
+		groups = desired.SecurityGroups
+	}
+
+	if changes.CreateManaged || (groups != nil && !sameStrings(groups, nlb.SecurityGroups)) {
+		changes.SecurityGroups = uniqueStrings(groups)
+	}
+	if desired.EnforcePrivateLink != "" && desired.EnforcePrivateLink != nlb.EnforcePrivateLink {
+		changes.EnforcePrivateLink = desired.EnforcePrivateLink
+	}
+	return changes, nil
+}
+
+// diffSecurityGroupRules returns the rules to authorize and to revoke to go
+// from the current to the desired rules
+func diffSecurityGroupRules(current, desired []SecurityGroupRule) ([]SecurityGroupRule, []SecurityGroupRule) {
+	existing := map[SecurityGroupRule]bool{}
+	for _, rule := range current {
+		existing[rule] = true
+	}
+	wanted := map[SecurityGroupRule]bool{}
+	authorize := []SecurityGroupRule{}
+	for _, rule := range desired {
+		wanted[rule] = true
+		if !existing[rule] {
+			authorize = append(authorize, rule)
+		}
+	}
+	revoke := []SecurityGroupRule{}
+	for _, rule := range current {
+		if !wanted[rule] {
+			revoke = append(revoke, rule)
+		}
+	}
+	return authorize, revoke
+}
+
+// ApplySecurityGroupChanges applies the security group changes, creating the
+// managed security group of the Service in the load balancer VPC if needed
+func (awsc *APIClient) ApplySecurityGroupChanges(
+	changes SecurityGroupChanges, o Ownership, vpcID string) error {
+
+	if changes.CreateManaged {
+		id, err := awsc.createManagedSecurityGroup(o, vpcID)
+		if err != nil {
+			return err
+		}
+		changes.ManagedID = id
+		changes.SecurityGroups = append(changes.SecurityGroups, id)
+	}
+	if err := awsc.updateSecurityGroupRules(changes.ManagedID, changes.Authorize, changes.Revoke); err != nil {
+		return err
+	}
+
+	if changes.SecurityGroups != nil || changes.EnforcePrivateLink != "" {
+		input := &elbv2.SetSecurityGroupsInput{
+			LoadBalancerArn: aws.String(changes.LoadBalancerARN),
+			SecurityGroups:  aws.StringSlice(changes.SecurityGroups),
+		}
+		if changes.SecurityGroups == nil {
+			// the security groups must always be given, the current ones
+			// are kept when only the private link setting changes
+			input.SecurityGroups = aws.StringSlice(changes.current)
+		}
+		if changes.EnforcePrivateLink != "" {
+			input.EnforceSecurityGroupInboundRulesOnPrivateLinkTraffic = aws.String(changes.EnforcePrivateLink)
+		}
+		if _, err := awsc.elbv2.SetSecurityGroups(input); err != nil {
+			log.Error(err, "unable to set the load balancer security groups",
+				"LoadBalancerARN", changes.LoadBalancerARN,
+			)
+			return err
+		}
+		log.Info("Load balancer security groups updated", "LoadBalancerARN", changes.LoadBalancerARN)
+	}
+
+	if changes.DeleteManaged {
+		return awsc.DeleteSecurityGroup(changes.ManagedID)
+	}
+	return nil
+}
+
+// DeleteSecurityGroup deletes the security group, which fails with a
+// DependencyViolation error while a load balancer still uses it
+func (awsc *APIClient) DeleteSecurityGroup(groupID string) error {
+	if _, err := awsc.ec2.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
+		GroupId: aws.String(groupID),
+	}); err != nil {
+		log.Error(err, "unable to delete the managed security group", "SecurityGroupID", groupID)
+		return err
+	}
+	log.Info("Managed security group deleted", "SecurityGroupID", groupID)
+	return nil
+}
+
+// ValidateSecurityGroupReference returns an error if the security group
+// reference is neither an ID nor a `tag:<key>=<value>` reference
+func ValidateSecurityGroupReference(ref string) error {
+	switch {
+	case strings.HasPrefix(ref, securityGroupIDPrefix):
+		return nil
+	case strings.HasPrefix(ref, securityGroupTagPrefix):
+		if key, _, ok := splitTag(strings.TrimPrefix(ref, securityGroupTagPrefix)); ok && key != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid security group %q, expected an ID or tag:<key>=<value>", ref)
+}
+
+// ResolveSecurityGroups resolves the security group references to the IDs of
+// the security groups of the VPC. A tag reference matches all the security
+// groups with the tag, sorted by ID.
+func (awsc *APIClient) ResolveSecurityGroups(vpcID string, refs []string) ([]string, error) {
+	ids := []string{}
+	for _, ref := range refs {
+		if strings.HasPrefix(ref, securityGroupIDPrefix) {
+			ids = append(ids, ref)
+			continue
+		}
+		key, value, _ := splitTag(strings.TrimPrefix(ref, securityGroupTagPrefix))
+		groups, err := awsc.describeSecurityGroups(vpcID, map[string]string{key: value})
+		if err != nil {
+			return nil, err
+		}
+		if len(groups) == 0 {
+			return nil, fmt.Errorf("no security group of the VPC %s matches %q", vpcID, ref)
+		}
+		resolved := []string{}
+		for _, group := range groups {
+			resolved = append(resolved, aws.StringValue(group.GroupId))
+		}
+		sort.Strings(resolved)
+		ids = append(ids, resolved...)
+	}
+	return ids, nil
+}
+
+// GetManagedSecurityGroup returns the security group managed by the helper
+// instance for the Service, nil if it doesn't exist
+func (awsc *APIClient) GetManagedSecurityGroup(o Ownership, vpcID string) (*ManagedSecurityGroup, error) {
+
+	groups, err := awsc.describeSecurityGroups(vpcID, map[string]string{
+		ManagedByTagKey:  ManagedByTagValue,
+		InstanceIDTagKey: o.InstanceID,
+		ServiceTagKey:    o.Service,
+	})
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+	return managedSecurityGroup(groups[0]), nil
+}
+
+// ListManagedSecurityGroups returns the security groups managed by the helper
+// instance in all the VPCs, along with the Service they were created for
+func (awsc *APIClient) ListManagedSecurityGroups(instanceID string) ([]ManagedSecurityGroup, error) {
+
+	groups, err := awsc.describeSecurityGroups("", map[string]string{
+		ManagedByTagKey:  ManagedByTagValue,
+		InstanceIDTagKey: instanceID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	managed := []ManagedSecurityGroup{}
+	for _, group := range groups {
+		managed = append(managed, *managedSecurityGroup(group))
+	}
+	return managed, nil
+}
+
+// managedSecurityGroup converts a managed security group, with its inbound
+// rules and the Service of its ownership tags
+func managedSecurityGroup(group *ec2.SecurityGroup) *ManagedSecurityGroup {
+
+	managed := &ManagedSecurityGroup{ID: aws.StringValue(group.GroupId), Rules: []SecurityGroupRule{}}
+	for _, tag := range group.Tags {
+		if aws.StringValue(tag.Key) == ServiceTagKey {
+			managed.Service = aws.StringValue(tag.Value)
+		}
+	}
+	for _, permission := range group.IpPermissions {
+		rule := SecurityGroupRule{
+			Protocol: aws.StringValue(permission.IpProtocol),
+			FromPort: aws.Int64Value(permission.FromPort),
+			ToPort:   aws.Int64Value(permission.ToPort),
+		}
+		for _, r := range permission.IpRanges {
+			rule.CIDR = aws.StringValue(r.CidrIp)
+			managed.Rules = append(managed.Rules, rule)
+		}
+		for _, r := range permission.Ipv6Ranges {
+			rule.CIDR = aws.StringValue(r.CidrIpv6)
+			managed.Rules = append(managed.Rules, rule)
+		}
+	}
+	return managed
+}
+
+// describeSecurityGroups returns the security groups of the VPC with the
+// tags, of all the VPCs if vpcID is empty
+func (awsc *APIClient) describeSecurityGroups(vpcID string, tags map[string]string) ([]*ec2.SecurityGroup, error) {
+
+	input := &ec2.DescribeSecurityGroupsInput{Filters: []*ec2.Filter{}}
+	if vpcID != "" {
+		input.Filters = append(input.Filters, &ec2.Filter{
+			Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{vpcID}),
+		})
+	}
+	for key, value := range tags {
+		input.Filters = append(input.Filters, &ec2.Filter{
+			Name: aws.String("tag:" + key), Values: aws.StringSlice([]string{value}),
+		})
+	}
+
+	groups := []*ec2.SecurityGroup{}
+	err := awsc.ec2.DescribeSecurityGroupsPages(input,
+		func(page *ec2.DescribeSecurityGroupsOutput, lastPage bool) bool {
+			groups = append(groups, page.SecurityGroups...)
+			return true
+		})
+	if err != nil {
+		log.Error(err, "unable to describe the security groups", "VpcID", vpcID, "Tags", tags)
+		return nil, err
+	}
+	return groups, nil
+}
+
+// createManagedSecurityGroup creates the security group of the Service,
+// tagged with the ownership tags, returning its ID
+func (awsc *APIClient) createManagedSecurityGroup(o Ownership, vpcID string) (string, error) {
+
+	name := fmt.Sprintf(managedSecurityGroupNameFormat, o.InstanceID, o.Service)
+	if len(name) > managedSecurityGroupMaxName {
+		name = name[:managedSecurityGroupMaxName]
+	}
+	csgo, err := awsc.ec2.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(name),
+		Description: aws.String(fmt.Sprintf("Load balancer security group of the Service %s", o.Service)),
+		VpcId:       aws.String(vpcID),
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeSecurityGroup),
+			Tags: []*ec2.Tag{
+				{Key: aws.String(ManagedByTagKey), Value: aws.String(ManagedByTagValue)},
+				{Key: aws.String(InstanceIDTagKey), Value: aws.String(o.InstanceID)},
+				{Key: aws.String(ServiceTagKey), Value: aws.String(o.Service)},
+			},
+		}},
+	})
+	if err != nil {
+		log.Error(err, "unable to create the managed security group", "Service", o.Service, "VpcID", vpcID)
+		return "", err
+	}
+	log.Info("Managed security group created",
+		"Service", o.Service, "SecurityGroupID", aws.StringValue(csgo.GroupId),
+	)
+	return aws.StringValue(csgo.GroupId), nil
+}
+
+// updateSecurityGroupRules authorizes and revokes the inbound rules of the
+// security group
+func (awsc *APIClient) updateSecurityGroupRules(groupID string, authorize, revoke []SecurityGroupRule) error {
+	if len(authorize) > 0 {
+		if _, err := awsc.ec2.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupID),
+			IpPermissions: ipPermissions(authorize),
+		}); err != nil {
+			log.Error(err, "unable to authorize the security group rules", "SecurityGroupID", groupID)
+			return err
+		}
+	}
+	if len(revoke) > 0 {
+		if _, err := awsc.ec2.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupID),
+			IpPermissions: ipPermissions(revoke),
+		}); err != nil {
+			log.Error(err, "unable to revoke the security group rules", "SecurityGroupID", groupID)
+			return err
+		}
+	}
+	return nil
+}
+
+// ipPermissions converts the rules to EC2 IP permissions, one per rule
+func ipPermissions(rules []SecurityGroupRule) []*ec2.IpPermission {
+	permissions := []*ec2.IpPermission{}
+	for _, rule := range rules {
+		permission := &ec2.IpPermission{
+			IpProtocol: aws.String(rule.Protocol),
+			FromPort:   aws.Int64(rule.FromPort),
+			ToPort:     aws.Int64(rule.ToPort),
+		}
+		if ip, _, err := net.ParseCIDR(rule.CIDR); err == nil && ip.To4() == nil {
+			permission.Ipv6Ranges = []*ec2.Ipv6Range{{CidrIpv6: aws.String(rule.CIDR)}}
+		} else {
+			permission.IpRanges = []*ec2.IpRange{{CidrIp: aws.String(rule.CIDR)}}
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions
+}
+
+// sameStrings returns true if both lists hold the same strings, whatever the
+// order
+func sameStrings(a, b []string) bool {
+	a, b = uniqueStrings(a), uniqueStrings(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueStrings returns the sorted list without duplicates
+func uniqueStrings(list []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// removeString returns the list without the string
+func removeString(list []string, s string) []string {
+	kept := []string{}
+	for _, item := range list {
+		if item != s {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
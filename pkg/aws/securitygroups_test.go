@@ -0,0 +1,195 @@
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func TestNetworkLoadBalancer_PlanSecurityGroupChanges(t *testing.T) {
+	const nlbARN = "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/lb/1"
+	tls := SecurityGroupRule{Protocol: "tcp", FromPort: 443, ToPort: 443, CIDR: "0.0.0.0/0"}
+	plain := SecurityGroupRule{Protocol: "tcp", FromPort: 80, ToPort: 80, CIDR: "0.0.0.0/0"}
+
+	tests := []struct {
+		name    string
+		nlb     *NetworkLoadBalancer
+		desired SecurityGroupSettings
+		managed *ManagedSecurityGroup
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "unmanaged",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-1"}},
+			desired: SecurityGroupSettings{},
+			want:    "",
+		},
+		{
+			name:    "up to date",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-2", "sg-1"}, EnforcePrivateLink: "on"},
+			desired: SecurityGroupSettings{SecurityGroups: []string{"sg-1", "sg-2"}, EnforcePrivateLink: "on"},
+			want:    "",
+		},
+		{
+			name:    "replaced",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-1"}, EnforcePrivateLink: "on"},
+			desired: SecurityGroupSettings{SecurityGroups: []string{"sg-2"}, EnforcePrivateLink: "off"},
+			want:    "loadbalancer/net/lb/1 security-groups=[sg-2] enforce-private-link=off",
+		},
+		{
+			name:    "without security groups",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN},
+			desired: SecurityGroupSettings{SecurityGroups: []string{"sg-1"}},
+			wantErr: true,
+		},
+		{
+			name:    "create managed",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-1"}},
+			desired: SecurityGroupSettings{SecurityGroups: []string{"sg-1"}, Managed: true, Rules: []SecurityGroupRule{tls}},
+			want:    "loadbalancer/net/lb/1 create managed security group security-groups=[sg-1 <managed>] +tcp/443 from 0.0.0.0/0",
+		},
+		{
+			name:    "create managed without a list",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-2", "sg-1"}},
+			desired: SecurityGroupSettings{Managed: true, Rules: []SecurityGroupRule{tls}},
+			want:    "loadbalancer/net/lb/1 create managed security group security-groups=[sg-1 sg-2 <managed>] +tcp/443 from 0.0.0.0/0",
+		},
+		{
+			name:    "managed attached without a list",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-1"}},
+			desired: SecurityGroupSettings{Managed: true, Rules: []SecurityGroupRule{tls}},
+			managed: &ManagedSecurityGroup{ID: "sg-managed", Rules: []SecurityGroupRule{tls}},
+			want:    "loadbalancer/net/lb/1 security-groups=[sg-1 sg-managed]",
+		},
+		{
+			name:    "managed up to date without a list",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-managed", "sg-1"}},
+			desired: SecurityGroupSettings{Managed: true, Rules: []SecurityGroupRule{tls}},
+			managed: &ManagedSecurityGroup{ID: "sg-managed", Rules: []SecurityGroupRule{tls}},
+			want:    "",
+		},
+		{
+			name:    "managed rules drifted",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-managed"}},
+			desired: SecurityGroupSettings{Managed: true, Rules: []SecurityGroupRule{tls}},
+			managed: &ManagedSecurityGroup{ID: "sg-managed", Rules: []SecurityGroupRule{plain}},
+			want:    "loadbalancer/net/lb/1 +tcp/443 from 0.0.0.0/0 -tcp/80 from 0.0.0.0/0",
+		},
+		{
+			name:    "managed disabled",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-1", "sg-managed"}},
+			desired: SecurityGroupSettings{},
+			managed: &ManagedSecurityGroup{ID: "sg-managed", Rules: []SecurityGroupRule{plain}},
+			want:    "loadbalancer/net/lb/1 security-groups=[sg-1] delete managed security group sg-managed",
+		},
+		{
+			name:    "managed last security group",
+			nlb:     &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-managed"}},
+			desired: SecurityGroupSettings{},
+			managed: &ManagedSecurityGroup{ID: "sg-managed"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := tt.nlb.PlanSecurityGroupChanges(tt.desired, tt.managed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlanSecurityGroupChanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := ""
+			if !changes.IsEmpty() {
+				got = changes.String()
+			}
+			if got != tt.want {
+				t.Errorf("PlanSecurityGroupChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIClient_securityGroups(t *testing.T) {
+	const (
+		nlbARN = "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/lb/1"
+		nlbDNS = "lb-1.elb.us-east-1.amazonaws.com"
+	)
+
+	requests := []url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("unable to parse the request: %v", err)
+		}
+		requests = append(requests, r.PostForm)
+		action := r.PostForm.Get("Action")
+		result := `<SecurityGroupIds><member>sg-1</member></SecurityGroupIds>`
+		if action == "DescribeLoadBalancers" {
+			result = `<LoadBalancers><member><LoadBalancerArn>` + nlbARN + `</LoadBalancerArn>` +
+				`<DNSName>` + nlbDNS + `</DNSName><Type>network</Type><VpcId>vpc-1</VpcId>` +
+				`<SecurityGroups><member>sg-1</member></SecurityGroups>` +
+				`<EnforceSecurityGroupInboundRulesOnPrivateLinkTraffic>on</EnforceSecurityGroupInboundRulesOnPrivateLinkTraffic>` +
+				`</member></LoadBalancers>`
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(`<` + action + `Response><` + action + `Result>` + result +
+			`</` + action + `Result></` + action + `Response>`))
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	awsc := &APIClient{elbv2: elbv2.New(sess)}
+
+	lb, err := awsc.getLoadBalancerByDNS([]string{nlbARN}, nlbDNS)
+	if err != nil {
+		t.Fatalf("getLoadBalancerByDNS() error = %v", err)
+	}
+	got := []string{aws.StringValue(lb.VpcId), aws.StringValue(lb.EnforceSecurityGroupInboundRulesOnPrivateLinkTraffic)}
+	got = append(got, aws.StringValueSlice(lb.SecurityGroups)...)
+	if want := []string{"vpc-1", "on", "sg-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getLoadBalancerByDNS() = %v, want %v", got, want)
+	}
+
+	// only the private link setting changes, the current security groups are
+	// set again
+	nlb := &NetworkLoadBalancer{ARN: nlbARN, SecurityGroups: []string{"sg-1"}, EnforcePrivateLink: "on"}
+	changes, err := nlb.PlanSecurityGroupChanges(SecurityGroupSettings{EnforcePrivateLink: "off"}, nil)
+	if err != nil {
+		t.Fatalf("PlanSecurityGroupChanges() error = %v", err)
+	}
+	if err := awsc.ApplySecurityGroupChanges(changes, Ownership{}, "vpc-1"); err != nil {
+		t.Fatalf("ApplySecurityGroupChanges() error = %v", err)
+	}
+
+	want := []map[string]string{
+		{"Action": "DescribeLoadBalancers", "LoadBalancerArns.member.1": nlbARN},
+		{
+			"Action":                  "SetSecurityGroups",
+			"LoadBalancerArn":         nlbARN,
+			"SecurityGroups.member.1": "sg-1",
+			"EnforceSecurityGroupInboundRulesOnPrivateLinkTraffic": "off",
+		},
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(requests), len(want))
+	}
+	for i, params := range want {
+		for key, value := range params {
+			if got := requests[i].Get(key); got != value {
+				t.Errorf("request %d %s = %q, want %q", i, key, got, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package aws
+
+// AttributeSnapshot holds the values of the helper managed attributes of a
+// network load balancer, its target groups and its TCP listeners, taken
+// before the helper modifies them for the first time.
+type AttributeSnapshot struct {
+	LoadBalancer map[string]string            `json:"loadBalancer,omitempty"`
+	TargetGroups map[string]map[string]string `json:"targetGroups,omitempty"`
+	Listeners    map[string]map[string]string `json:"listeners,omitempty"`
+}
+
+// Snapshot returns the current values of the helper managed attributes of the
+// network load balancer, its target groups and its TCP listeners.
+func (nlb *NetworkLoadBalancer) Snapshot() AttributeSnapshot {
+	snapshot := AttributeSnapshot{
+		LoadBalancer: filterAttributes(
+			nlb.Attributes, NetworkLoadBalancerAttributes{}.loadBalancerAttributes(),
+		),
+		TargetGroups: map[string]map[string]string{},
+	}
+	for _, tg := range nlb.TargetGroups {
+		snapshot.TargetGroups[tg.ARN] = filterAttributes(
+			tg.Attributes, NetworkLoadBalancerAttributes{}.targetGroupAttributes(),
+		)
+	}
+	for _, listener := range nlb.Listeners {
+		// the attributes of the listeners not discovered are added to the
+		// snapshot once they are
+		if listener.Protocol != ListenerProtocolTCP || listener.Attributes == nil {
+			continue
+		}
+		if snapshot.Listeners == nil {
+			snapshot.Listeners = map[string]map[string]string{}
+		}
+		snapshot.Listeners[listener.ARN] = filterAttributes(listener.Attributes, managedListenerAttributes())
+	}
+	return snapshot
+}
+
+// Merge adds to the snapshot the resources from other that are not already
+// part of it, returning true if the snapshot has been modified.
+func (s *AttributeSnapshot) Merge(other AttributeSnapshot) bool {
+	modified := false
+	if s.LoadBalancer == nil && other.LoadBalancer != nil {
+		s.LoadBalancer = other.LoadBalancer
+		modified = true
+	}
+	for arn, attributes := range other.TargetGroups {
+		if _, ok := s.TargetGroups[arn]; ok {
+			continue
+		}
+		if s.TargetGroups == nil {
+			s.TargetGroups = map[string]map[string]string{}
+		}
+		s.TargetGroups[arn] = attributes
+		modified = true
+	}
+	for arn, attributes := range other.Listeners {
+		if _, ok := s.Listeners[arn]; ok {
+			continue
+		}
+		if s.Listeners == nil {
+			s.Listeners = map[string]map[string]string{}
+		}
+		s.Listeners[arn] = attributes
+		modified = true
+	}
+	return modified
+}
+
+// PlanRestoreChanges returns the list of changes needed to restore the
+// network load balancer, its target groups and its TCP listeners attributes to
+// the values of the snapshot. Target groups and listeners not present in the
+// snapshot are left untouched.
+func (nlb *NetworkLoadBalancer) PlanRestoreChanges(snapshot AttributeSnapshot) []AttributeChange {
+	changes := diffAttributes(
+		nlb.ARN, loadBalancerResourceType, nlb.Attributes, snapshot.LoadBalancer,
+	)
+	for _, tg := range nlb.TargetGroups {
+		if attributes, ok := snapshot.TargetGroups[tg.ARN]; ok {
+			changes = append(changes, diffAttributes(
+				tg.ARN, targetGroupResourceType, tg.Attributes, attributes,
+			)...)
+		}
+	}
+	for _, listener := range nlb.Listeners {
+		if attributes, ok := snapshot.Listeners[listener.ARN]; ok {
+			changes = append(changes, diffAttributes(
+				listener.ARN, listenerResourceType, listener.Attributes, attributes,
+			)...)
+		}
+	}
+	return changes
+}
+
+// filterAttributes returns the attributes whose keys are present in keys
+func filterAttributes(attributes, keys map[string]string) map[string]string {
+	filtered := map[string]string{}
+	for key := range keys {
+		if value, ok := attributes[key]; ok {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,37 @@
+package aws
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNetworkLoadBalancer_Snapshot(t *testing.T) {
+	const listenerARN = "arn:aws:elasticloadbalancing:us-east-1:000000000000:listener/net/lb/1/5432"
+	tests := []struct {
+		name      string
+		listeners []Listener
+		want      map[string]map[string]string
+	}{
+		{
+			name: "listener attributes discovered",
+			listeners: []Listener{{
+				ARN: listenerARN, Port: 5432, Protocol: ListenerProtocolTCP,
+				Attributes: map[string]string{ListenerTCPIdleTimeoutKey: "350"},
+			}},
+			want: map[string]map[string]string{listenerARN: {ListenerTCPIdleTimeoutKey: "350"}},
+		},
+		{
+			name:      "listener attributes not discovered",
+			listeners: []Listener{{ARN: listenerARN, Port: 5432, Protocol: ListenerProtocolTCP}},
+			want:      nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nlb := &NetworkLoadBalancer{Listeners: tt.listeners}
+			if got := nlb.Snapshot().Listeners; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Snapshot() listeners = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const (
+	// ManagedByTagKey is the tag identifying the resources managed by the helper
+	ManagedByTagKey = "aws-nlb-helper.3scale.net/managed-by"
+	// InstanceIDTagKey is the tag identifying the helper instance managing a resource
+	InstanceIDTagKey = "aws-nlb-helper.3scale.net/instance-id"
+	// ServiceTagKey is the tag identifying the Service owning a resource
+	ServiceTagKey = "aws-nlb-helper.3scale.net/service"
+	// OwnedAttributesTagKey is the tag listing the attributes managed by the helper
+	OwnedAttributesTagKey = "aws-nlb-helper.3scale.net/owned-attributes"
+	// ResourceTagsTagKey is the tag listing the user defined tags managed by
+	// the helper
+	ResourceTagsTagKey = "aws-nlb-helper.3scale.net/resource-tags"
+	// ManagedByTagValue is the value of the ManagedByTagKey tag
+	ManagedByTagValue = "aws-nlb-helper-operator"
+	// helperTagPrefix is the prefix of the tags reserved for the helper
+	helperTagPrefix = "aws-nlb-helper.3scale.net/"
+	// awsReservedTagPrefix is the prefix of the tags reserved by AWS
+	awsReservedTagPrefix = "aws:"
+
+	// describeTagsMaxResources is the maximum number of resources accepted by
+	// a single DescribeTags call
+	describeTagsMaxResources = 20
+)
+
+// Ownership identifies the helper instance and the Service managing a
+// network load balancer.
+type Ownership struct {
+	InstanceID string
+	Service    string
+}
+
+// TagChange describes the tags to add or remove from a resource
+type TagChange struct {
+	ResourceARN string
+	Add         map[string]string
+	Remove      []string
+}
+
+// String returns a human readable representation of the change, like
+// `targetgroup/name/id tags: +key=value -key`.
+func (c TagChange) String() string {
+	changes := []string{}
+	for key, value := range c.Add {
+		changes = append(changes, fmt.Sprintf("+%s=%s", key, value))
+	}
+	for _, key := range c.Remove {
+		changes = append(changes, fmt.Sprintf("-%s", key))
+	}
+	sort.Strings(changes)
+	return fmt.Sprintf("%s tags: %s", resourceName(c.ResourceARN), strings.Join(changes, " "))
+}
+
+// ownershipTags returns the ownership tags of a resource whose owned
+// attributes are the keys of the attributes map.
+func (o Ownership) ownershipTags(attributes map[string]string) map[string]string {
+	keys := []string{}
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return map[string]string{
+		ManagedByTagKey:       ManagedByTagValue,
+		InstanceIDTagKey:      o.InstanceID,
+		ServiceTagKey:         o.Service,
+		OwnedAttributesTagKey: strings.Join(keys, " "),
+	}
+}
+
+// PlanOwnershipTags returns the tag changes needed to flag the network load
+// balancer and its target groups as managed by the helper.
+func (nlb *NetworkLoadBalancer) PlanOwnershipTags(o Ownership) []TagChange {
+	changes := diffTags(nlb.ARN, nlb.Tags,
+		o.ownershipTags(NetworkLoadBalancerAttributes{}.loadBalancerAttributes()), nil,
+	)
+	for _, tg := range nlb.TargetGroups {
+		changes = append(changes, diffTags(tg.ARN, tg.Tags,
+			o.ownershipTags(NetworkLoadBalancerAttributes{}.targetGroupAttributes()), nil,
+		)...)
+	}
+	return changes
+}
+
+// ManagingInstance returns the helper instance managing the network load
+// balancer, as set in its guard tag, and whether it is another instance than
+// the given one. Unmanaged load balancers have no managing instance.
+func (nlb *NetworkLoadBalancer) ManagingInstance(instanceID string) (string, bool) {
+	owner := nlb.Tags[InstanceIDTagKey]
+	return owner, owner != "" && owner != instanceID
+}
+
+// PlanOwnershipTagsRemoval returns the tag changes needed to remove the
+// ownership tags from the network load balancer and its target groups.
+func (nlb *NetworkLoadBalancer) PlanOwnershipTagsRemoval() []TagChange {
+	keys := []string{ManagedByTagKey, InstanceIDTagKey, ServiceTagKey, OwnedAttributesTagKey}
+	changes := diffTags(nlb.ARN, nlb.Tags, nil, keys)
+	for _, tg := range nlb.TargetGroups {
+		changes = append(changes, diffTags(tg.ARN, tg.Tags, nil, keys)...)
+	}
+	return changes
+}
+
+// PlanResourceTags returns the tag changes needed to set the desired user
+// defined tags on the network load balancer and its target groups. The tags
+// previously set by the helper that are no longer desired are removed. Tags
+// matching any of the protectedPrefixes, reserved by AWS or by the helper are
+// never modified.
+func (nlb *NetworkLoadBalancer) PlanResourceTags(
+	desired map[string]string, protectedPrefixes []string) []TagChange {
+
+	protectedPrefixes = append(
+		[]string{awsReservedTagPrefix, helperTagPrefix}, protectedPrefixes...,
+	)
+	allowed := map[string]string{}
+	for key, value := range desired {
+		if !hasAnyPrefix(key, protectedPrefixes) {
+			allowed[key] = value
+		}
+	}
+
+	changes := planResourceTags(nlb.ARN, nlb.Tags, allowed, protectedPrefixes)
+	for _, tg := range nlb.TargetGroups {
+		changes = append(changes, planResourceTags(tg.ARN, tg.Tags, allowed, protectedPrefixes)...)
+	}
+	return changes
+}
+
+// PlanResourceTagsRemoval returns the tag changes needed to remove the user
+// defined tags set by the helper from the network load balancer and its
+// target groups.
+func (nlb *NetworkLoadBalancer) PlanResourceTagsRemoval(protectedPrefixes []string) []TagChange {
+	return nlb.PlanResourceTags(map[string]string{}, protectedPrefixes)
+}
+
+// planResourceTags returns the tag change of a single resource for the
+// PlanResourceTags function.
+func planResourceTags(arn string, current, desired map[string]string,
+	protectedPrefixes []string) []TagChange {
+
+	keys := []string{}
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := map[string]string{}
+	for key, value := range desired {
+		tags[key] = value
+	}
+	unwanted := []string{}
+	if len(keys) > 0 {
+		tags[ResourceTagsTagKey] = strings.Join(keys, " ")
+	} else {
+		unwanted = append(unwanted, ResourceTagsTagKey)
+	}
+
+	for _, key := range strings.Fields(current[ResourceTagsTagKey]) {
+		if _, ok := desired[key]; !ok && !hasAnyPrefix(key, protectedPrefixes) {
+			unwanted = append(unwanted, key)
+		}
+	}
+
+	return diffTags(arn, current, tags, unwanted)
+}
+
+// hasAnyPrefix returns true if s starts with any of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffTags returns the change needed to add the desired tags and remove the
+// unwanted tag keys from a resource, if any.
+func diffTags(arn string, current, desired map[string]string, unwanted []string) []TagChange {
+	change := TagChange{ResourceARN: arn, Add: map[string]string{}}
+	for key, value := range desired {
+		if currentValue, ok := current[key]; !ok || currentValue != value {
+			change.Add[key] = value
+		}
+	}
+	for _, key := range unwanted {
+		if _, ok := current[key]; ok {
+			change.Remove = append(change.Remove, key)
+		}
+	}
+	if len(change.Add) == 0 && len(change.Remove) == 0 {
+		return nil
+	}
+	return []TagChange{change}
+}
+
+// ApplyTagChanges adds and removes the tags described by the changes list
+func (awsc *APIClient) ApplyTagChanges(changes []TagChange) error {
+
+	for _, c := range changes {
+		if len(c.Add) > 0 {
+			ati := elbv2.AddTagsInput{ResourceArns: []*string{aws.String(c.ResourceARN)}}
+			for key, value := range c.Add {
+				ati.Tags = append(ati.Tags, &elbv2.Tag{Key: aws.String(key), Value: aws.String(value)})
+			}
+			if _, err := awsc.elbv2.AddTags(&ati); err != nil {
+				log.Error(err, "unable to tag the resource", "ResourceARN", c.ResourceARN)
+				return err
+			}
+		}
+		if len(c.Remove) > 0 {
+			rti := elbv2.RemoveTagsInput{
+				ResourceArns: []*string{aws.String(c.ResourceARN)},
+				TagKeys:      aws.StringSlice(c.Remove),
+			}
+			if _, err := awsc.elbv2.RemoveTags(&rti); err != nil {
+				log.Error(err, "unable to untag the resource", "ResourceARN", c.ResourceARN)
+				return err
+			}
+		}
+		log.V(1).Info("Resource tags updated", "change", c.String())
+	}
+
+	return nil
+}
+
+// getTags returns the tags of a list of load balancers or target groups,
+// indexed by resource ARN.
+func (awsc *APIClient) getTags(arns []string) (map[string]map[string]string, error) {
+
+	tags := map[string]map[string]string{}
+	for start := 0; start < len(arns); start += describeTagsMaxResources {
+		end := start + describeTagsMaxResources
+		if end > len(arns) {
+			end = len(arns)
+		}
+
+		dto, err := awsc.elbv2.DescribeTags(
+			&elbv2.DescribeTagsInput{ResourceArns: aws.StringSlice(arns[start:end])},
+		)
+		if err != nil {
+			log.Error(err, "unable to describe resource tags", "ResourceARNs", arns[start:end])
+			return nil, err
+		}
+
+		for _, td := range dto.TagDescriptions {
+			resourceTags := map[string]string{}
+			for _, t := range td.Tags {
+				resourceTags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+			}
+			tags[aws.StringValue(td.ResourceArn)] = resourceTags
+		}
+	}
+	return tags, nil
+}
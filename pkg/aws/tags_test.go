@@ -0,0 +1,77 @@
+package aws
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNetworkLoadBalancer_PlanResourceTags(t *testing.T) {
+	tests := []struct {
+		name              string
+		tags              map[string]string
+		desired           map[string]string
+		protectedPrefixes []string
+		want              []string
+	}{
+		{
+			name:    "up to date",
+			tags:    map[string]string{"team": "a", ResourceTagsTagKey: "team"},
+			desired: map[string]string{"team": "a"},
+			want:    []string{},
+		},
+		{
+			name: "drifted and removed tags",
+			tags: map[string]string{
+				"team": "b", "env": "dev", "other": "x", ResourceTagsTagKey: "env team",
+			},
+			desired: map[string]string{"team": "a"},
+			want: []string{
+				"loadbalancer/net/lb/1 tags: +aws-nlb-helper.3scale.net/resource-tags=team +team=a -env",
+			},
+		},
+		{
+			name:              "protected tags",
+			tags:              map[string]string{"kubernetes.io/service-name": "ns/svc"},
+			desired:           map[string]string{"kubernetes.io/service-name": "other", "aws:x": "y"},
+			protectedPrefixes: []string{"kubernetes.io/"},
+			want:              []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nlb := &NetworkLoadBalancer{
+				ARN:  "arn:aws:elasticloadbalancing:us-east-1:000000000000:loadbalancer/net/lb/1",
+				Tags: tt.tags,
+			}
+			got := []string{}
+			for _, change := range nlb.PlanResourceTags(tt.desired, tt.protectedPrefixes) {
+				got = append(got, change.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanResourceTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkLoadBalancer_ManagingInstance(t *testing.T) {
+	tests := []struct {
+		name         string
+		tags         map[string]string
+		want         string
+		wantConflict bool
+	}{
+		{name: "unmanaged", tags: map[string]string{}, want: "", wantConflict: false},
+		{name: "same instance", tags: map[string]string{InstanceIDTagKey: "a"}, want: "a", wantConflict: false},
+		{name: "another instance", tags: map[string]string{InstanceIDTagKey: "b"}, want: "b", wantConflict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nlb := &NetworkLoadBalancer{Tags: tt.tags}
+			got, conflict := nlb.ManagingInstance("a")
+			if got != tt.want || conflict != tt.wantConflict {
+				t.Errorf("ManagingInstance() = %v, %v, want %v, %v", got, conflict, tt.want, tt.wantConflict)
+			}
+		})
+	}
+}
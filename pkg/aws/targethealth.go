@@ -0,0 +1,196 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const (
+	// TargetHealthHealthy is the state of a target passing the health checks
+	TargetHealthHealthy = elbv2.TargetHealthStateEnumHealthy
+	// TargetHealthDraining is the state of a target being deregistered
+	TargetHealthDraining = elbv2.TargetHealthStateEnumDraining
+
+	// TargetTypeInstance and TargetTypeIP are the target types of a target
+	// group, registering EC2 instances or IP addresses
+	TargetTypeInstance = elbv2.TargetTypeEnumInstance
+	TargetTypeIP       = elbv2.TargetTypeEnumIp
+)
+
+// TargetHealth is the health of a target registered in a target group, the
+// ID being either an instance ID or an IP address depending on the target
+// group type.
+type TargetHealth struct {
+	ID          string
+	Port        int64
+	State       string
+	Reason      string
+	Description string
+}
+
+// IsHealthy returns true if the target passes the health checks
+func (th TargetHealth) IsHealthy() bool {
+	return th.State == TargetHealthHealthy
+}
+
+// GetTargetHealth returns the health of the targets registered in the target
+// group.
+func (awsc *APIClient) GetTargetHealth(targetGroupARN string) ([]TargetHealth, error) {
+
+	dtho, err := awsc.elbv2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+	})
+	if err != nil {
+		log.Error(err, "unable to describe the target group targets health",
+			"TargetGroupARN", targetGroupARN,
+		)
+		return nil, err
+	}
+
+	targets := []TargetHealth{}
+	for _, thd := range dtho.TargetHealthDescriptions {
+		th := TargetHealth{}
+		if thd.Target != nil {
+			th.ID = aws.StringValue(thd.Target.Id)
+			th.Port = aws.Int64Value(thd.Target.Port)
+		}
+		if thd.TargetHealth != nil {
+			th.State = aws.StringValue(thd.TargetHealth.State)
+			th.Reason = aws.StringValue(thd.TargetHealth.Reason)
+			th.Description = aws.StringValue(thd.TargetHealth.Description)
+		}
+		targets = append(targets, th)
+	}
+	return targets, nil
+}
+
+// DeregisterTargets deregisters the targets from the target group, their
+// connections being drained for the target group deregistration delay.
+func (awsc *APIClient) DeregisterTargets(targetGroupARN string, targets []TargetHealth) error {
+
+	descriptions := make([]*elbv2.TargetDescription, 0, len(targets))
+	for _, th := range targets {
+		descriptions = append(descriptions, &elbv2.TargetDescription{
+			Id:   aws.String(th.ID),
+			Port: aws.Int64(th.Port),
+		})
+	}
+	_, err := awsc.elbv2.DeregisterTargets(&elbv2.DeregisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Targets:        descriptions,
+	})
+	if err != nil {
+		log.Error(err, "unable to deregister the targets from the target group",
+			"TargetGroupARN", targetGroupARN,
+		)
+		return err
+	}
+	return nil
+}
+
+// RegisterTargets registers back the targets in the target group
+func (awsc *APIClient) RegisterTargets(targetGroupARN string, targets []TargetHealth) error {
+
+	descriptions := make([]*elbv2.TargetDescription, 0, len(targets))
+	for _, th := range targets {
+		descriptions = append(descriptions, &elbv2.TargetDescription{
+			Id:   aws.String(th.ID),
+			Port: aws.Int64(th.Port),
+		})
+	}
+	_, err := awsc.elbv2.RegisterTargets(&elbv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Targets:        descriptions,
+	})
+	if err != nil {
+		log.Error(err, "unable to register the targets in the target group",
+			"TargetGroupARN", targetGroupARN,
+		)
+		return err
+	}
+	return nil
+}
+
+// IsTargetGroupNotFound returns true if the error is returned because the
+// target group doesn't exist anymore
+func IsTargetGroupNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == elbv2.ErrCodeTargetGroupNotFoundException
+}
+
+// TargetHealthSummary counts the targets of a target group by health state,
+// along with the reasons of the targets not healthy
+type TargetHealthSummary struct {
+	Healthy   int
+	Unhealthy int
+	Initial   int
+	Draining  int
+	Unused    int
+	Reasons   map[string]int
+}
+
+// SummarizeTargetHealth returns the health summary of the targets, the
+// unavailable targets being counted as unhealthy
+func SummarizeTargetHealth(targets []TargetHealth) TargetHealthSummary {
+	s := TargetHealthSummary{Reasons: map[string]int{}}
+	s.Add(targets)
+	return s
+}
+
+// Add adds the targets to the summary
+func (s *TargetHealthSummary) Add(targets []TargetHealth) {
+	if s.Reasons == nil {
+		s.Reasons = map[string]int{}
+	}
+	for _, th := range targets {
+		switch th.State {
+		case elbv2.TargetHealthStateEnumHealthy:
+			s.Healthy++
+		case elbv2.TargetHealthStateEnumInitial:
+			s.Initial++
+		case elbv2.TargetHealthStateEnumDraining:
+			s.Draining++
+		case elbv2.TargetHealthStateEnumUnused:
+			s.Unused++
+		default:
+			s.Unhealthy++
+		}
+		if th.Reason != "" {
+			s.Reasons[th.Reason]++
+		}
+	}
+}
+
+// Counts returns the number of targets by health state
+func (s TargetHealthSummary) Counts() map[string]int {
+	return map[string]int{
+		elbv2.TargetHealthStateEnumHealthy:   s.Healthy,
+		elbv2.TargetHealthStateEnumUnhealthy: s.Unhealthy,
+		elbv2.TargetHealthStateEnumInitial:   s.Initial,
+		elbv2.TargetHealthStateEnumDraining:  s.Draining,
+		elbv2.TargetHealthStateEnumUnused:    s.Unused,
+	}
+}
+
+// String returns a human readable summary, like
+// `healthy=2 unhealthy=1 initial=0 draining=0 unused=0 (Target.FailedHealthChecks=1)`
+func (s TargetHealthSummary) String() string {
+	summary := fmt.Sprintf("healthy=%d unhealthy=%d initial=%d draining=%d unused=%d",
+		s.Healthy, s.Unhealthy, s.Initial, s.Draining, s.Unused,
+	)
+	if len(s.Reasons) == 0 {
+		return summary
+	}
+	reasons := make([]string, 0, len(s.Reasons))
+	for reason, count := range s.Reasons {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, count))
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("%s (%s)", summary, strings.Join(reasons, " "))
+}
@@ -0,0 +1,328 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion is the version of the operator config file format
+	APIVersion = "config.aws-nlb-helper.3scale.net/v1alpha1"
+	// Kind is the kind of the operator config file
+	Kind = "OperatorConfig"
+
+	// DefaultAnnotationPrefix is the prefix of the helper annotations
+	DefaultAnnotationPrefix = "aws-nlb-helper.3scale.net"
+	// DefaultResyncInterval is the interval between reconciles of a managed
+	// Service
+	DefaultResyncInterval = 60 * time.Second
+	// DefaultConcurrency is the number of Services reconciled in parallel
+	DefaultConcurrency = 1
+	// leaderElectionIDPrefix is prepended to the annotation prefix to build
+	// the default leader election ID
+	leaderElectionIDPrefix = "804187e3."
+	// DefaultProxyProtocolSoakPeriod is the default soak period of a target
+	// group during a guarded proxy protocol rollout
+	DefaultProxyProtocolSoakPeriod = 2 * time.Minute
+	// DefaultProxyProtocolMinHealthyPercent is the default percentage of
+	// healthy targets below which a proxy protocol change is rolled back
+	DefaultProxyProtocolMinHealthyPercent = 80
+
+	minResyncInterval                 = 10 * time.Second
+	minProxyProtocolSoakPeriod        = 10 * time.Second
+	maxTargetGroupDeregistrationDelay = 3600
+)
+
+// OperatorConfig is the operator config file. Besides the operator settings,
+// it accepts the controller-runtime ControllerManagerConfig settings, like the
+// metrics, health probes and leader election ones.
+type OperatorConfig struct {
+	metav1.TypeMeta                        `json:",inline"`
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// Region is the AWS region of the load balancers, it takes precedence
+	// over the AWS_REGION environment variable
+	Region string `json:"region,omitempty"`
+	// ClusterID is the cluster ID set in the kubernetes.io/cluster/<id> tag
+	// of the cluster load balancers
+	ClusterID string `json:"clusterID,omitempty"`
+	// AnnotationPrefix is the prefix of the annotations handled by the operator
+	AnnotationPrefix string `json:"annotationPrefix,omitempty"`
+	// Concurrency is the number of Services reconciled in parallel
+	Concurrency int `json:"concurrency,omitempty"`
+	// Namespaces lists the namespaces watched by the operator, all the
+	// namespaces are watched if empty. The WATCH_NAMESPACE environment
+	// variable takes precedence.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ServiceSelector restricts the managed Services to the ones matching the
+	// selector. It is applied to the Services cache, so Services not matching
+	// the selector are never loaded.
+	ServiceSelector *metav1.LabelSelector `json:"serviceSelector,omitempty"`
+
+	// Settings can be changed without restarting the operator
+	Settings `json:",inline"`
+}
+
+// Settings are the operator settings reloaded when the config file changes
+type Settings struct {
+	// DryRun plans the load balancer changes without applying them
+	DryRun bool `json:"dryRun,omitempty"`
+	// Strict refuses to apply any change to the load balancer of a Service
+	// with an invalid annotation, instead of ignoring the invalid values
+	Strict bool `json:"strict,omitempty"`
+	// ResyncInterval is the interval between reconciles of a managed Service
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
+	// Defaults are the attribute values used when a Service is not annotated
+	Defaults AttributeDefaults `json:"defaults,omitempty"`
+	// NamespaceSelector restricts the managed Services to the namespaces
+	// matching the selector, the Services are rescoped as soon as their
+	// namespace labels change
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ProxyProtocolRollout configures the rollout of the target groups proxy
+	// protocol changes
+	ProxyProtocolRollout ProxyProtocolRollout `json:"proxyProtocolRollout,omitempty"`
+}
+
+// ProxyProtocolRollout configures the guarded rollout of the target groups
+// proxy protocol changes: the target groups are changed one at a time, and
+// each change is rolled back if the health of the target group drops during
+// the soak period.
+type ProxyProtocolRollout struct {
+	// Guarded enables the guarded rollout for all the Services
+	Guarded bool `json:"guarded,omitempty"`
+	// SoakPeriod is the time a changed target group is watched before
+	// changing the next one
+	SoakPeriod *metav1.Duration `json:"soakPeriod,omitempty"`
+	// MinHealthyPercent is the percentage of healthy targets of a changed
+	// target group below which the change is rolled back
+	MinHealthyPercent *int `json:"minHealthyPercent,omitempty"`
+	// ErrorSignalURL is polled during the soak period, the change is rolled
+	// back if it doesn't answer with a 2xx status
+	ErrorSignalURL string `json:"errorSignalURL,omitempty"`
+	// CheckBackend detects the ingress-nginx or OpenShift router behind the
+	// Services, the proxy protocol is not changed while their configuration
+	// disagrees with the desired value
+	CheckBackend bool `json:"checkBackend,omitempty"`
+}
+
+// AttributeDefaults are the load balancer attribute values used when a
+// Service is not annotated, the built-in defaults are used if unset.
+type AttributeDefaults struct {
+	LoadBalancerTerminationProtection *bool `json:"loadBalancerTerminationProtection,omitempty"`
+	TargetGroupProxyProtocol          *bool `json:"targetGroupProxyProtocol,omitempty"`
+	TargetGroupStickiness             *bool `json:"targetGroupStickiness,omitempty"`
+	TargetGroupDeregistrationDelay    *int  `json:"targetGroupDeregistrationDelay,omitempty"`
+}
+
+// New returns an OperatorConfig with the built-in defaults
+func New() *OperatorConfig {
+	c := &OperatorConfig{TypeMeta: metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind}}
+	c.Default()
+	return c
+}
+
+// Load reads, defaults and validates the operator config file
+func Load(path string) (*OperatorConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the config file: %w", err)
+	}
+	return Parse(content)
+}
+
+// Parse decodes, defaults and validates the content of an operator config
+// file. Unknown fields are rejected.
+func Parse(content []byte) (*OperatorConfig, error) {
+	c := &OperatorConfig{}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return nil, fmt.Errorf("unable to parse the config file: %w", err)
+	}
+	c.Default()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Default sets the built-in default values of the unset fields
+func (c *OperatorConfig) Default() {
+	if c.AnnotationPrefix == "" {
+		c.AnnotationPrefix = DefaultAnnotationPrefix
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+	if c.ResyncInterval == nil {
+		c.ResyncInterval = &metav1.Duration{Duration: DefaultResyncInterval}
+	}
+	if c.ProxyProtocolRollout.SoakPeriod == nil {
+		c.ProxyProtocolRollout.SoakPeriod = &metav1.Duration{Duration: DefaultProxyProtocolSoakPeriod}
+	}
+	if c.ProxyProtocolRollout.MinHealthyPercent == nil {
+		percent := DefaultProxyProtocolMinHealthyPercent
+		c.ProxyProtocolRollout.MinHealthyPercent = &percent
+	}
+}
+
+// Validate returns an error listing all the invalid fields
+func (c *OperatorConfig) Validate() error {
+	errs := field.ErrorList{}
+
+	if c.APIVersion != APIVersion {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), c.APIVersion, []string{APIVersion}))
+	}
+	if c.Kind != Kind {
+		errs = append(errs, field.NotSupported(field.NewPath("kind"), c.Kind, []string{Kind}))
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(c.AnnotationPrefix) {
+		errs = append(errs, field.Invalid(field.NewPath("annotationPrefix"), c.AnnotationPrefix, msg))
+	}
+	if c.Concurrency < 1 {
+		errs = append(errs, field.Invalid(field.NewPath("concurrency"), c.Concurrency, "must be greater than 0"))
+	}
+	for i, ns := range c.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(field.NewPath("namespaces").Index(i), ns, msg))
+		}
+	}
+	if _, err := metav1.LabelSelectorAsSelector(c.ServiceSelector); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("serviceSelector"), c.ServiceSelector, err.Error()))
+	}
+
+	return append(errs, c.Settings.validate()...).ToAggregate()
+}
+
+// validate returns the invalid Settings fields
+func (s *Settings) validate() field.ErrorList {
+	errs := field.ErrorList{}
+
+	if s.ResyncInterval != nil && s.ResyncInterval.Duration < minResyncInterval {
+		errs = append(errs, field.Invalid(field.NewPath("resyncInterval"), s.ResyncInterval.Duration.String(),
+			fmt.Sprintf("must be at least %s", minResyncInterval),
+		))
+	}
+	if delay := s.Defaults.TargetGroupDeregistrationDelay; delay != nil &&
+		(*delay < 0 || *delay > maxTargetGroupDeregistrationDelay) {
+		errs = append(errs, field.Invalid(field.NewPath("defaults", "targetGroupDeregistrationDelay"), *delay,
+			fmt.Sprintf("must be between 0 and %d", maxTargetGroupDeregistrationDelay),
+		))
+	}
+	if _, err := metav1.LabelSelectorAsSelector(s.NamespaceSelector); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("namespaceSelector"), s.NamespaceSelector, err.Error()))
+	}
+
+	rollout := field.NewPath("proxyProtocolRollout")
+	if p := s.ProxyProtocolRollout.SoakPeriod; p != nil && p.Duration < minProxyProtocolSoakPeriod {
+		errs = append(errs, field.Invalid(rollout.Child("soakPeriod"), p.Duration.String(),
+			fmt.Sprintf("must be at least %s", minProxyProtocolSoakPeriod),
+		))
+	}
+	if p := s.ProxyProtocolRollout.MinHealthyPercent; p != nil && (*p < 0 || *p > 100) {
+		errs = append(errs, field.Invalid(rollout.Child("minHealthyPercent"), *p, "must be between 0 and 100"))
+	}
+	if u := s.ProxyProtocolRollout.ErrorSignalURL; u != "" {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			errs = append(errs, field.Invalid(rollout.Child("errorSignalURL"), u, "must be an http or https URL"))
+		}
+	}
+
+	return errs
+}
+
+// NamespaceLabelSelector returns the namespace selector, matching everything
+// if unset
+func (s *Settings) NamespaceLabelSelector() labels.Selector {
+	return labelSelector(s.NamespaceSelector)
+}
+
+// ServiceLabelSelector returns the Service selector, matching everything if
+// unset
+func (c *OperatorConfig) ServiceLabelSelector() labels.Selector {
+	return labelSelector(c.ServiceSelector)
+}
+
+// labelSelector converts a validated label selector, a nil selector matches
+// everything
+func labelSelector(ls *metav1.LabelSelector) labels.Selector {
+	if ls == nil {
+		return labels.Everything()
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ls)
+	if err != nil {
+		return labels.Nothing()
+	}
+	return selector
+}
+
+// LeaderElectionID returns the default leader election ID, derived from the
+// annotation prefix so the instances using different prefixes don't compete
+// for the same lock.
+func (c *OperatorConfig) LeaderElectionID() string {
+	return leaderElectionIDPrefix + c.AnnotationPrefix
+}
+
+// Complete returns the controller-runtime settings, implementing the
+// controller-runtime ControllerManagerConfiguration interface.
+func (c *OperatorConfig) Complete() (cfg.ControllerManagerConfigurationSpec, error) {
+	return c.ControllerManagerConfigurationSpec, nil
+}
+
+// DeepCopyObject implements the runtime.Object interface
+func (c *OperatorConfig) DeepCopyObject() runtime.Object {
+	out := &OperatorConfig{}
+	*out = *c
+	c.ControllerManagerConfigurationSpec.DeepCopyInto(&out.ControllerManagerConfigurationSpec)
+	out.Namespaces = append([]string(nil), c.Namespaces...)
+	out.ServiceSelector = c.ServiceSelector.DeepCopy()
+	out.Settings = *c.Settings.DeepCopy()
+	return out
+}
+
+// DeepCopy returns a deep copy of the settings
+func (s *Settings) DeepCopy() *Settings {
+	out := &Settings{DryRun: s.DryRun, Strict: s.Strict}
+	if s.ResyncInterval != nil {
+		out.ResyncInterval = &metav1.Duration{Duration: s.ResyncInterval.Duration}
+	}
+	out.NamespaceSelector = s.NamespaceSelector.DeepCopy()
+	if d := s.Defaults.LoadBalancerTerminationProtection; d != nil {
+		out.Defaults.LoadBalancerTerminationProtection = boolPtr(*d)
+	}
+	if d := s.Defaults.TargetGroupProxyProtocol; d != nil {
+		out.Defaults.TargetGroupProxyProtocol = boolPtr(*d)
+	}
+	if d := s.Defaults.TargetGroupStickiness; d != nil {
+		out.Defaults.TargetGroupStickiness = boolPtr(*d)
+	}
+	if d := s.Defaults.TargetGroupDeregistrationDelay; d != nil {
+		delay := *d
+		out.Defaults.TargetGroupDeregistrationDelay = &delay
+	}
+	out.ProxyProtocolRollout = ProxyProtocolRollout{
+		Guarded:        s.ProxyProtocolRollout.Guarded,
+		ErrorSignalURL: s.ProxyProtocolRollout.ErrorSignalURL,
+		CheckBackend:   s.ProxyProtocolRollout.CheckBackend,
+	}
+	if p := s.ProxyProtocolRollout.SoakPeriod; p != nil {
+		out.ProxyProtocolRollout.SoakPeriod = &metav1.Duration{Duration: p.Duration}
+	}
+	if p := s.ProxyProtocolRollout.MinHealthyPercent; p != nil {
+		percent := *p
+		out.ProxyProtocolRollout.MinHealthyPercent = &percent
+	}
+	return out
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	c, err := Load("../../config/manager/controller_manager_config.yaml")
+	if err != nil {
+		t.Fatalf("Load() of the shipped config file error = %v", err)
+	}
+	if c.AnnotationPrefix != DefaultAnnotationPrefix {
+		t.Errorf("Load() annotationPrefix = %v, want %v", c.AnnotationPrefix, DefaultAnnotationPrefix)
+	}
+	if *c.LeaderElection.LeaderElect != true {
+		t.Errorf("Load() leaderElection.leaderElect = %v, want true", *c.LeaderElection.LeaderElect)
+	}
+}
+
+func TestParse(t *testing.T) {
+	header := "apiVersion: " + APIVersion + "\nkind: " + Kind + "\n"
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+		check   func(*OperatorConfig) bool
+	}{
+		{
+			name:    "defaults",
+			content: header,
+			check: func(c *OperatorConfig) bool {
+				return c.Concurrency == DefaultConcurrency &&
+					c.ResyncInterval.Duration == DefaultResyncInterval &&
+					c.ProxyProtocolRollout.SoakPeriod.Duration == DefaultProxyProtocolSoakPeriod
+			},
+		},
+		{
+			name:    "settings",
+			content: header + "resyncInterval: 5m\ndefaults:\n  targetGroupDeregistrationDelay: 30\n",
+			check: func(c *OperatorConfig) bool {
+				return c.ResyncInterval.Duration == 5*time.Minute &&
+					*c.Defaults.TargetGroupDeregistrationDelay == 30
+			},
+		},
+		{
+			name:    "unsupported kind",
+			content: "apiVersion: " + APIVersion + "\nkind: ControllerManagerConfig\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			content: header + "unknown: true\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid values",
+			content: header + "resyncInterval: 1s\nconcurrency: -1\nannotationPrefix: Invalid_Prefix\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid proxy protocol rollout",
+			content: header + "proxyProtocolRollout:\n  minHealthyPercent: 101\n  errorSignalURL: ftp://x\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid selector",
+			content: header + "serviceSelector:\n  matchExpressions:\n  - key: a\n    operator: Unknown\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(c) {
+				t.Errorf("Parse() = %+v", c)
+			}
+		})
+	}
+}
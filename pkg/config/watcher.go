@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Store holds the current operator Settings, safe for concurrent use
+type Store struct {
+	mutex    sync.RWMutex
+	settings Settings
+	onChange []func(old, new Settings)
+}
+
+// NewStore returns a Store holding the settings
+func NewStore(settings Settings) *Store {
+	return &Store{settings: *settings.DeepCopy()}
+}
+
+// Get returns a copy of the current settings
+func (s *Store) Get() Settings {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return *s.settings.DeepCopy()
+}
+
+// Set replaces the current settings, running the OnChange functions if they
+// differ
+func (s *Store) Set(settings Settings) {
+	s.mutex.Lock()
+	old := s.settings
+	s.settings = *settings.DeepCopy()
+	onChange := s.onChange
+	s.mutex.Unlock()
+
+	if reflect.DeepEqual(old, settings) {
+		return
+	}
+	for _, f := range onChange {
+		f(old, settings)
+	}
+}
+
+// OnChange registers a function run when the settings change
+func (s *Store) OnChange(f func(old, new Settings)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onChange = append(s.onChange, f)
+}
+
+// Watcher reloads the Settings of the operator config file when it changes.
+// The file is polled, as the ConfigMap volumes are updated by swapping
+// symlinks. The settings that can't be changed at runtime are ignored, a
+// restart is needed to apply them.
+type Watcher struct {
+	Path     string
+	Store    *Store
+	Log      logr.Logger
+	Interval time.Duration
+
+	content []byte
+	current *OperatorConfig
+}
+
+// NewWatcher returns a Watcher of the config file loaded as current
+func NewWatcher(path string, current *OperatorConfig, store *Store, log logr.Logger) *Watcher {
+	content, _ := ioutil.ReadFile(path)
+	return &Watcher{
+		Path: path, Store: store, Log: log, Interval: 10 * time.Second,
+		content: content, current: current,
+	}
+}
+
+// Start polls the config file every Interval until the context is done,
+// implementing the manager Runnable interface.
+func (w *Watcher) Start(ctx context.Context) error {
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.reload()
+		}
+	}
+}
+
+// NeedLeaderElection makes the config reloaded on all the instances
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// reload applies the settings of the config file if its content changed and
+// is valid
+func (w *Watcher) reload() {
+	content, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		w.Log.Error(err, "unable to read the config file", "path", w.Path)
+		return
+	}
+	if bytes.Equal(content, w.content) {
+		return
+	}
+	w.content = content
+
+	c, err := Parse(content)
+	if err != nil {
+		w.Log.Error(err, "invalid config file, keeping the current settings", "path", w.Path)
+		return
+	}
+
+	if !restartFieldsEqual(w.current, c) {
+		w.Log.Info("Config file settings that require a restart changed, they won't be applied until then",
+			"path", w.Path,
+		)
+	}
+	w.Log.Info("Config file changed, reloading the settings", "path", w.Path)
+	w.Store.Set(c.Settings)
+}
+
+// restartFieldsEqual returns true if the settings that require a restart are
+// equal in both configs
+func restartFieldsEqual(a, b *OperatorConfig) bool {
+	return reflect.DeepEqual(a.ControllerManagerConfigurationSpec, b.ControllerManagerConfigurationSpec) &&
+		a.Region == b.Region && a.ClusterID == b.ClusterID &&
+		a.AnnotationPrefix == b.AnnotationPrefix && a.Concurrency == b.Concurrency &&
+		reflect.DeepEqual(a.Namespaces, b.Namespaces) &&
+		reflect.DeepEqual(a.ServiceSelector, b.ServiceSelector)
+}
@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "aws_nlb_helper"
+)
+
+var (
+	// PlannedChanges is the number of attribute changes detected for a
+	// Service load balancer during its last reconcile
+	PlannedChanges = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "planned_changes",
+			Help:      "Number of load balancer attribute changes planned for a Service during its last reconcile",
+		},
+		[]string{"namespace", "service", "dry_run"},
+	)
+
+	// OrphanedLoadBalancers reports the load balancers whose Service no
+	// longer exists, as found by the last orphaned load balancers scan
+	OrphanedLoadBalancers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "orphaned_load_balancers",
+			Help:      "Load balancers tagged with a Service that no longer exists",
+		},
+		[]string{"service", "load_balancer", "deletion_protection"},
+	)
+
+	// Reconciles counts the Service reconciles by outcome, and by error
+	// class for the failed ones
+	Reconciles = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconciles_total",
+			Help:      "Number of Service reconciles by outcome",
+		},
+		[]string{"outcome", "error_class"},
+	)
+
+	// AWSAPICalls counts the AWS API calls by service, operation and error
+	// code, empty for the successful calls
+	AWSAPICalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "aws_api_calls_total",
+			Help:      "Number of AWS API calls by service, operation and error code",
+		},
+		[]string{"service", "operation", "error_code"},
+	)
+
+	// AWSAPICallDuration observes the AWS API calls latency, retries
+	// included, by service and operation
+	AWSAPICallDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "aws_api_call_duration_seconds",
+			Help:      "Latency of the AWS API calls by service and operation",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"service", "operation"},
+	)
+
+	// ManagedServices is the number of Services managed by the helper by
+	// namespace and elastic load balancer type
+	ManagedServices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "managed_services",
+			Help:      "Number of Services managed by the helper by namespace and elastic load balancer type",
+		},
+		[]string{"namespace", "elb_type"},
+	)
+
+	// DriftedAttributes is the number of attributes and tags found drifted
+	// from the desired state, already applied, during the last resync of a
+	// Service
+	DriftedAttributes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "drifted_attributes",
+			Help:      "Number of load balancer attributes and tags drifted from the desired state detected during the last resync",
+		},
+		[]string{"namespace", "service"},
+	)
+
+	// Targets is the number of load balancer targets of a Service port by
+	// health state
+	Targets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "targets",
+			Help:      "Number of load balancer targets of a Service port by health state",
+		},
+		[]string{"namespace", "service", "port", "state"},
+	)
+)
+
+// managedServices tracks the elastic load balancer type of the Services
+// managed by the helper, to compute the ManagedServices gauge
+var managedServices = struct {
+	sync.Mutex
+	types map[types.NamespacedName]string
+}{types: map[types.NamespacedName]string{}}
+
+// SetManagedService flags a Service as managed by the helper
+func SetManagedService(service types.NamespacedName, elbType string) {
+	managedServices.Lock()
+	defer managedServices.Unlock()
+	if managedServices.types[service] == elbType {
+		return
+	}
+	managedServices.types[service] = elbType
+	updateManagedServices()
+}
+
+// DeleteManagedService flags a Service as no longer managed by the helper
+func DeleteManagedService(service types.NamespacedName) {
+	DeleteTargetHealth(service)
+	managedServices.Lock()
+	defer managedServices.Unlock()
+	if _, ok := managedServices.types[service]; !ok {
+		return
+	}
+	delete(managedServices.types, service)
+	DriftedAttributes.DeleteLabelValues(service.Namespace, service.Name)
+	PlannedChanges.DeleteLabelValues(service.Namespace, service.Name, "true")
+	PlannedChanges.DeleteLabelValues(service.Namespace, service.Name, "false")
+	updateManagedServices()
+}
+
+// targetHealthPorts tracks the Service ports reported by the Targets gauge,
+// to delete them once the Service is no longer managed
+var targetHealthPorts = struct {
+	sync.Mutex
+	ports map[types.NamespacedName]map[string][]string
+}{ports: map[types.NamespacedName]map[string][]string{}}
+
+// SetTargetHealth sets the Targets gauge of a Service from the number of
+// targets by port and health state, deleting the ports no longer reported
+func SetTargetHealth(service types.NamespacedName, counts map[string]map[string]int) {
+	targetHealthPorts.Lock()
+	defer targetHealthPorts.Unlock()
+
+	for port, states := range targetHealthPorts.ports[service] {
+		for _, state := range states {
+			if _, ok := counts[port][state]; !ok {
+				Targets.DeleteLabelValues(service.Namespace, service.Name, port, state)
+			}
+		}
+	}
+	ports := map[string][]string{}
+	for port, states := range counts {
+		for state, count := range states {
+			Targets.WithLabelValues(service.Namespace, service.Name, port, state).Set(float64(count))
+			ports[port] = append(ports[port], state)
+		}
+	}
+	targetHealthPorts.ports[service] = ports
+}
+
+// DeleteTargetHealth deletes the Targets gauge of a Service
+func DeleteTargetHealth(service types.NamespacedName) {
+	SetTargetHealth(service, nil)
+	targetHealthPorts.Lock()
+	defer targetHealthPorts.Unlock()
+	delete(targetHealthPorts.ports, service)
+}
+
+// updateManagedServices computes the ManagedServices gauge, it must be
+// called with the managedServices lock held
+func updateManagedServices() {
+	ManagedServices.Reset()
+	for service, elbType := range managedServices.types {
+		ManagedServices.WithLabelValues(service.Namespace, elbType).Inc()
+	}
+}
+
+func init() {
+	metrics.Registry.MustRegister(
+		PlannedChanges,
+		OrphanedLoadBalancers,
+		Reconciles,
+		AWSAPICalls,
+		AWSAPICallDuration,
+		ManagedServices,
+		DriftedAttributes,
+		Targets,
+	)
+}
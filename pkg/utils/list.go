@@ -0,0 +1,14 @@
+package util
+
+import "strings"
+
+// SplitList splits a comma separated list, ignoring the empty items
+func SplitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}